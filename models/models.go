@@ -3,5 +3,5 @@ package models
 type Column struct {
 	Name     string `json:"name"`
 	Type     string `json:"type"`
-	JSONType string `json:"-"`
+	JSONType string `json:"json_type"`
 }
@@ -6,6 +6,8 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net/http"
+	"net/netip"
+	"sync"
 	"time"
 
 	"github.com/go-chi/jwtauth/v5"
@@ -32,8 +34,18 @@ type ScratchDataAPIStruct struct {
 	googleOauthConfig  *oauth2.Config
 	tokenAuth          *jwtauth.JWTAuth
 	config             config.API
+	workersConfig      config.Workers
 	apiKeyCache        *ttlcache.Cache[string, models.APIKey]
 	apiKeyCacheEnabled bool
+
+	// Proxies whose X-Forwarded-For headers are trusted
+	trustedProxies []netip.Prefix
+
+	// Idempotency keys of requests which are being processed
+	idempotencyInFlight sync.Map
+
+	// Cached results of pinging destinations for /readyz
+	destinationPings destinationPings
 }
 
 func NewScratchDataAPI(
@@ -57,6 +69,11 @@ func NewScratchDataAPI(
 		return nil, err
 	}
 
+	trustedProxies, err := parseTrustedProxies(conf.API.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
 	var (
 		apiKeyCache        *ttlcache.Cache[string, models.APIKey]
 		apiKeyCacheEnabled = true
@@ -76,6 +93,7 @@ func NewScratchDataAPI(
 		dataSink:           dataSink,
 		snow:               snow,
 		config:             conf.API,
+		workersConfig:      conf.Workers,
 		tokenAuth:          jwtauth.New("RS256", privateKey, nil),
 		googleOauthConfig: &oauth2.Config{
 			RedirectURL:  conf.Dashboard.GoogleRedirectURL,
@@ -86,6 +104,7 @@ func NewScratchDataAPI(
 		},
 		apiKeyCache:        apiKeyCache,
 		apiKeyCacheEnabled: apiKeyCacheEnabled,
+		trustedProxies:     trustedProxies,
 	}, nil
 }
 
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Most bytes a compressed request body may decompress to, unless
+// api.max_decompressed_bytes is set
+const defaultMaxDecompressedBytes = 1_000_000_000
+
+var errBodyTooLarge = errors.New("decompressed request body is too large")
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// compressedBody decodes a request body sent with a Content-Encoding, keeping
+// track of its size before and after decompression
+type compressedBody struct {
+	body    io.ReadCloser
+	wire    *countingReader
+	decoder io.Reader
+	closer  func()
+
+	read  int64
+	limit int64
+}
+
+func (b *compressedBody) Read(p []byte) (int, error) {
+	if b.read > b.limit {
+		return 0, errBodyTooLarge
+	}
+
+	// Read one byte past the limit so that a body of exactly the limit is allowed
+	if remaining := b.limit - b.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := b.decoder.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+func (b *compressedBody) Close() error {
+	if b.closer != nil {
+		b.closer()
+	}
+	return b.body.Close()
+}
+
+func (a *ScratchDataAPIStruct) maxDecompressedBytes() int64 {
+	if a.config.MaxDecompressedBytes > 0 {
+		return a.config.MaxDecompressedBytes
+	}
+	return defaultMaxDecompressedBytes
+}
+
+func newCompressedBody(encoding string, body io.ReadCloser, limit int64) (*compressedBody, error) {
+	rc := &compressedBody{
+		body:  body,
+		wire:  &countingReader{r: body},
+		limit: limit,
+	}
+
+	switch encoding {
+	case "gzip", "x-gzip":
+		decoder, err := gzip.NewReader(rc.wire)
+		if err != nil {
+			return nil, err
+		}
+		rc.decoder = decoder
+		rc.closer = func() { decoder.Close() }
+	case "zstd":
+		decoder, err := zstd.NewReader(rc.wire, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		rc.decoder = decoder
+		rc.closer = decoder.Close
+	case "snappy":
+		rc.decoder = snappy.NewReader(rc.wire)
+	default:
+		return nil, fmt.Errorf("%w %q", errUnsupportedEncoding, encoding)
+	}
+
+	return rc, nil
+}
+
+// Decompress transparently decodes request bodies sent with a gzip, zstd or
+// snappy (framed) Content-Encoding
+func (a *ScratchDataAPIStruct) Decompress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == "identity" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := newCompressedBody(encoding, r.Body, a.maxDecompressedBytes())
+		if errors.Is(err, errUnsupportedEncoding) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		} else if err != nil {
+			http.Error(w, "Unable to decompress data", http.StatusBadRequest)
+			return
+		}
+
+		r.Body = body
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = -1
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodySizes returns how many bytes of a request body were sent and how many were
+// read after decompression
+func bodySizes(r *http.Request, read int64) (int64, int64) {
+	if body, ok := r.Body.(*compressedBody); ok {
+		return body.wire.n, body.read
+	}
+	return read, read
+}
+
+// observeInsertSize records the size of an insert as sent and after decompression
+func observeInsertSize(r *http.Request, read int64) {
+	sent, decompressed := bodySizes(r, read)
+	insertSize.Observe(float64(sent))
+	insertDecompressedSize.Observe(float64(decompressed))
+}
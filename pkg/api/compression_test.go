@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/scratchdata/scratchdata/pkg/config"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		var err error
+		if w, err = zstd.NewWriter(&buf); err != nil {
+			t.Fatal(err)
+		}
+	case "snappy":
+		w = snappy.NewBufferedWriter(&buf)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	data := []byte(strings.Repeat(`{"user": "alice"}`+"\n", 1000))
+	a := &ScratchDataAPIStruct{config: config.API{MaxDecompressedBytes: int64(len(data))}}
+
+	var got []byte
+	var readErr error
+	var sizes [2]int64
+	handler := a.Decompress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, readErr = io.ReadAll(r.Body)
+		sizes[0], sizes[1] = bodySizes(r, int64(len(got)))
+	}))
+
+	for _, encoding := range []string{"gzip", "zstd", "snappy"} {
+		compressed := compress(t, encoding, data)
+
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed))
+		r.Header.Set("Content-Encoding", encoding)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		if readErr != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Expected %s body to be decompressed; Got %v", encoding, readErr)
+		}
+		if sizes[0] != int64(len(compressed)) || sizes[1] != int64(len(data)) {
+			t.Fatalf("Expected %s sizes %d and %d; Got %v", encoding, len(compressed), len(data), sizes)
+		}
+	}
+
+	// One byte over the limit
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compress(t, "gzip", append(data, '\n'))))
+	r.Header.Set("Content-Encoding", "gzip")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !errors.Is(readErr, errBodyTooLarge) {
+		t.Fatalf("Expected body over the limit to fail; Got %v", readErr)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	r.Header.Set("Content-Encoding", "br")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Expected unsupported encoding to be rejected; Got %d", w.Code)
+	}
+}
+
+func TestInsertCompressedTooLarge(t *testing.T) {
+	a, sink := newTestInsertAPI()
+	a.config.MaxDecompressedBytes = 100
+
+	body := `[` + strings.Repeat(`{"user": "alice"},`, 100) + `{"user": "bob"}]`
+	r := newInsertRequest(string(compress(t, "zstd", []byte(body))), "application/json")
+	r.Header.Set("Content-Encoding", "zstd")
+
+	w := httptest.NewRecorder()
+	a.Decompress(http.HandlerFunc(a.Insert)).ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge || len(sink.rows) != 0 {
+		t.Fatalf("Expected insert to be rejected as too large; Got %d with %d rows", w.Code, len(sink.rows))
+	}
+}
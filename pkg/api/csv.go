@@ -0,0 +1,326 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/render"
+)
+
+var errUnterminatedQuote = errors.New("unterminated quoted field")
+
+// Matches numbers which can be written into JSON as they are
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+type csvOptions struct {
+	delimiter byte
+	// 0 when fields aren't quoted
+	quote byte
+	// "auto", "true" or "false"
+	header string
+}
+
+func isDelimited(r *http.Request) bool {
+	mediaType := requestMediaType(r)
+	return mediaType == "text/csv" || mediaType == "text/tab-separated-values"
+}
+
+// parseCSVOptions reads the delimiter, quote and header query parameters. The
+// delimiter defaults to a comma, or a tab for text/tab-separated-values.
+func parseCSVOptions(r *http.Request) (csvOptions, error) {
+	opts := csvOptions{delimiter: ',', quote: '"', header: "auto"}
+	if requestMediaType(r) == "text/tab-separated-values" {
+		opts.delimiter = '\t'
+	}
+
+	query := r.URL.Query()
+
+	if query.Has("delimiter") {
+		delimiter := query.Get("delimiter")
+		if delimiter == "tab" || delimiter == `\t` {
+			delimiter = "\t"
+		}
+		if len(delimiter) != 1 || delimiter == "\n" || delimiter == "\r" {
+			return opts, errors.New("delimiter must be a single character")
+		}
+		opts.delimiter = delimiter[0]
+	}
+
+	if query.Has("quote") {
+		quote := query.Get("quote")
+		switch {
+		case quote == "" || quote == "none":
+			opts.quote = 0
+		case len(quote) == 1:
+			opts.quote = quote[0]
+		default:
+			return opts, errors.New("quote must be a single character or none")
+		}
+	}
+
+	if opts.quote != 0 && opts.quote == opts.delimiter {
+		return opts, errors.New("quote and delimiter must be different")
+	}
+
+	if query.Has("header") {
+		opts.header = strings.ToLower(query.Get("header"))
+		if opts.header != "auto" && opts.header != "true" && opts.header != "false" {
+			return opts, errors.New("header must be auto, true or false")
+		}
+	}
+
+	return opts, nil
+}
+
+// delimitedReader reads records from CSV or TSV data. Quoted fields may contain
+// delimiters and newlines, and a quote is escaped by doubling it.
+type delimitedReader struct {
+	r         *bufio.Reader
+	delimiter byte
+	quote     byte
+	max       int
+}
+
+func (d *delimitedReader) Read() ([]string, error) {
+	var record []string
+	var field bytes.Buffer
+	size := 0
+
+	inQuotes := false
+	fieldStart := true
+	empty := true
+
+	endField := func() {
+		record = append(record, field.String())
+		field.Reset()
+		fieldStart = true
+	}
+
+	for {
+		c, err := d.r.ReadByte()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errUnterminatedQuote
+			}
+			if empty {
+				return nil, io.EOF
+			}
+			endField()
+			return record, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		empty = false
+		size++
+		if size > d.max {
+			return nil, errLineTooLong
+		}
+
+		if inQuotes {
+			if c != d.quote {
+				field.WriteByte(c)
+				continue
+			}
+
+			next, err := d.r.Peek(1)
+			if err == nil && next[0] == d.quote {
+				d.r.ReadByte()
+				field.WriteByte(c)
+			} else {
+				inQuotes = false
+			}
+			continue
+		}
+
+		switch {
+		case d.quote != 0 && c == d.quote && fieldStart:
+			inQuotes = true
+			fieldStart = false
+		case c == d.delimiter:
+			endField()
+		case c == '\n':
+			endField()
+			return record, nil
+		case c == '\r':
+			next, err := d.r.Peek(1)
+			if err == nil && next[0] == '\n' {
+				continue
+			}
+			field.WriteByte(c)
+			fieldStart = false
+		default:
+			field.WriteByte(c)
+			fieldStart = false
+		}
+	}
+}
+
+// csvLiteral converts a CSV value into JSON, keeping numbers and booleans so that
+// column types can be inferred in the same way as for JSON inserts. Empty values
+// are null.
+func csvLiteral(value string) []byte {
+	if value == "" {
+		return []byte("null")
+	}
+
+	switch strings.ToLower(value) {
+	case "true":
+		return []byte("true")
+	case "false":
+		return []byte("false")
+	}
+
+	if jsonNumber.MatchString(value) {
+		return []byte(value)
+	}
+
+	rc, _ := json.Marshal(value)
+	return rc
+}
+
+// isHeader guesses whether the first record of a file names its columns, which
+// is the case when every value is a distinct piece of text
+func isHeader(record []string) bool {
+	seen := map[string]bool{}
+	for _, value := range record {
+		value = strings.TrimSpace(value)
+		if value == "" || seen[value] {
+			return false
+		}
+		if literal := csvLiteral(value); literal[0] != '"' {
+			return false
+		}
+		seen[value] = true
+	}
+	return true
+}
+
+// columnNames names columns from a header, or as column_1, column_2... when there
+// isn't one
+func columnNames(header []string, count int) []string {
+	rc := make([]string, count)
+	seen := map[string]bool{}
+	for i := range rc {
+		name := ""
+		if i < len(header) {
+			name = strings.TrimSpace(header[i])
+		}
+		if name == "" || seen[name] {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		seen[name] = true
+		rc[i] = name
+	}
+	return rc
+}
+
+// csvRows reads delimited records and converts each one into a JSON object. The
+// index of a row doesn't count the header.
+func csvRows(body io.Reader, opts csvOptions) rowReader {
+	reader := &delimitedReader{
+		r:         bufio.NewReaderSize(body, 64*1024),
+		delimiter: opts.delimiter,
+		quote:     opts.quote,
+		max:       maxNDJSONLineBytes,
+	}
+
+	var columns [][]byte
+	var pending []string
+	index := -1
+
+	// Reads the first record to work out the column names
+	start := func() error {
+		first, err := reader.Read()
+		if err != nil {
+			return err
+		}
+
+		// Spreadsheet exports often start with a byte order mark
+		first[0] = strings.TrimPrefix(first[0], "\ufeff")
+
+		header := opts.header == "true" || (opts.header == "auto" && isHeader(first))
+		var names []string
+		if header {
+			names = columnNames(first, len(first))
+		} else {
+			names = columnNames(nil, len(first))
+			pending = first
+		}
+
+		for _, name := range names {
+			key, _ := json.Marshal(name)
+			columns = append(columns, key)
+		}
+		return nil
+	}
+
+	return func() ([]byte, int, error) {
+		if columns == nil {
+			if err := start(); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		var record []string
+		for {
+			if pending != nil {
+				record, pending = pending, nil
+			} else {
+				var err error
+				record, err = reader.Read()
+				if err != nil {
+					return nil, index + 1, err
+				}
+			}
+
+			// Skip blank lines
+			if len(record) > 1 || record[0] != "" {
+				break
+			}
+		}
+
+		index++
+
+		if len(record) != len(columns) {
+			return nil, index, rejectedRowError{
+				reason: rejectInvalidCSV,
+				err:    fmt.Errorf("expected %d fields; got %d", len(columns), len(record)),
+			}
+		}
+
+		var row bytes.Buffer
+		row.WriteByte('{')
+		for i, value := range record {
+			if i > 0 {
+				row.WriteByte(',')
+			}
+			row.Write(columns[i])
+			row.WriteByte(':')
+			row.Write(csvLiteral(value))
+		}
+		row.WriteByte('}')
+
+		return row.Bytes(), index, nil
+	}
+}
+
+func (a *ScratchDataAPIStruct) insertDelimited(w http.ResponseWriter, r *http.Request, databaseID int64, table string, flattener Flattener, system []systemColumn, strict bool) {
+	opts, err := parseCSVOptions(r)
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, InsertResult{Error: err.Error()})
+		return
+	}
+
+	body := &countingReader{r: r.Body}
+	a.insertStream(w, r, body, csvRows(body, opts), databaseID, table, flattener, system, strict)
+}
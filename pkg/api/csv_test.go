@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/sjson"
+)
+
+func TestDelimitedReader(t *testing.T) {
+	data := "a,\"b,c\",\"say \"\"hi\"\"\"\r\n\"multi\nline\",,x\n'q',3,4"
+
+	tests := []struct {
+		quote    byte
+		expected string
+	}{
+		{'"', `[a b,c say "hi"] [multi` + "\n" + `line  x] ['q' 3 4]`},
+		{'\'', `[a "b c" "say ""hi"""] ["multi] [line"  x] [q 3 4]`},
+	}
+
+	for _, test := range tests {
+		reader := &delimitedReader{r: bufio.NewReader(strings.NewReader(data)), delimiter: ',', quote: test.quote, max: 1000}
+
+		var records []string
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, fmt.Sprint(record))
+		}
+
+		if got := strings.Join(records, " "); got != test.expected {
+			t.Fatalf("Expected %s; Got %s", test.expected, got)
+		}
+	}
+
+	reader := &delimitedReader{r: bufio.NewReader(strings.NewReader(`a,"b`)), delimiter: ',', quote: '"', max: 1000}
+	if _, err := reader.Read(); err != errUnterminatedQuote {
+		t.Fatalf("Expected unterminated quote error; Got %v", err)
+	}
+}
+
+func TestCSVLiteral(t *testing.T) {
+	for value, expected := range map[string]string{
+		"":      "null",
+		"12":    "12",
+		"-1.5":  "-1.5",
+		"1e3":   "1e3",
+		"007":   `"007"`,
+		"TRUE":  "true",
+		"NaN":   `"NaN"`,
+		"a\"b":  `"a\"b"`,
+		"+1":    `"+1"`,
+		"1,000": `"1,000"`,
+	} {
+		if got := string(csvLiteral(value)); got != expected {
+			t.Fatalf("Expected %q to be %s; Got %s", value, expected, got)
+		}
+	}
+}
+
+func withoutRowID(row string) string {
+	rc, _ := sjson.Delete(row, "__row_id")
+	return rc
+}
+
+func TestInsertCSV(t *testing.T) {
+	a, sink := newTestInsertAPI()
+
+	body := "\ufeffname,age,score,active\nalice,30,1.5,true\n\nbob,,2,false\ncarol,41\n"
+	code, result := doInsert(t, a, newInsertRequest(body, "text/csv; charset=utf-8"))
+
+	if code != http.StatusOK || result.Accepted != 2 || rejectedRows(result) != "2:invalid_csv" {
+		t.Fatalf("Expected 2 rows to be accepted and row 2 to be rejected; Got %d %+v", code, result)
+	}
+
+	for i, expected := range []string{
+		`{"active":true,"age":30,"name":"alice","score":1.5}`,
+		`{"active":false,"age":null,"name":"bob","score":2}`,
+	} {
+		if got := withoutRowID(sink.rows[i]); got != expected {
+			t.Fatalf("Expected %s; Got %s", expected, got)
+		}
+	}
+
+	// Without a header, columns are numbered
+	sink.rows = nil
+	code, result = doInsert(t, a, newInsertRequest("1\tx y\n2\tz\n", "text/tab-separated-values"))
+
+	if code != http.StatusOK || result.Accepted != 2 || withoutRowID(sink.rows[0]) != `{"column_1":1,"column_2":"x y"}` {
+		t.Fatalf("Expected numbered columns; Got %d %+v %v", code, result, sink.rows)
+	}
+
+	r := newInsertRequest("a;b\n1;2\n", "text/csv")
+	r.URL.RawQuery = "delimiter=%3B&header=false"
+	sink.rows = nil
+	code, result = doInsert(t, a, r)
+
+	if code != http.StatusOK || result.Accepted != 2 || withoutRowID(sink.rows[0]) != `{"column_1":"a","column_2":"b"}` {
+		t.Fatalf("Expected header to be read as a row; Got %d %+v %v", code, result, sink.rows)
+	}
+
+	// Invalid options get the same JSON response as other insert errors
+	r = newInsertRequest("a,b\n", "text/csv")
+	r.URL.RawQuery = "delimiter=ab"
+	code, result = doInsert(t, a, r)
+
+	if code != http.StatusBadRequest || result.Error != "delimiter must be a single character" {
+		t.Fatalf("Expected a 400 with the option error; Got %d %+v", code, result)
+	}
+}
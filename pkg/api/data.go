@@ -2,8 +2,13 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
@@ -11,8 +16,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/datasink"
 	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
 	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
@@ -23,12 +30,128 @@ var insertSize = promauto.NewHistogram(prometheus.HistogramOpts{
 	Buckets: prometheus.ExponentialBucketsRange(1000, 100_000_000, 5),
 })
 
+var insertDecompressedSize = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "insert_decompressed_bytes",
+	Help:    "Bytes inserted in single request after decompression",
+	Buckets: prometheus.ExponentialBucketsRange(1000, 100_000_000, 5),
+})
+
 var insertArraySize = promauto.NewHistogram(prometheus.HistogramOpts{
 	Name:    "insert_array_length",
 	Help:    "Items in single request",
 	Buckets: prometheus.LinearBuckets(1, 50, 10),
 })
 
+// Reasons a row can be rejected by an insert
+const (
+	rejectInvalidJSON = "invalid_json"
+	rejectFlatten     = "flatten_error"
+	rejectWrite       = "write_error"
+	rejectInvalidCSV  = "invalid_csv"
+)
+
+// At most this many rejected rows are listed in an insert response. All of them
+// are counted.
+const maxRejectedRows = 1000
+
+type RejectedRow struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+}
+
+type InsertResult struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Errors   []RejectedRow `json:"errors,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	// Rows skipped because their dedup_field value was already inserted
+	Duplicates int `json:"duplicates,omitempty"`
+
+	// Set when the accepted rows were written, but the data sink couldn't confirm
+	// that they were saved. Retrying the insert may insert them twice.
+	Unconfirmed bool `json:"unconfirmed,omitempty"`
+
+	writeFailed bool
+
+	// Set when a strict insert failed after some of its rows were written
+	partial bool
+
+	// Set if the data sink was too busy to write a row
+	busy datasink.Busy
+
+	// Tables which rows were written to, and need to be flushed
+	tables []string
+}
+
+// wrote records that rows were written to table
+func (r *InsertResult) wrote(table string) {
+	if !slices.Contains(r.tables, table) {
+		r.tables = append(r.tables, table)
+	}
+}
+
+func (r *InsertResult) reject(index int, reason string, err error) {
+	r.Rejected++
+	if reason == rejectWrite {
+		r.writeFailed = true
+	}
+	var busy datasink.Busy
+	if errors.As(err, &busy) {
+		r.busy = busy
+	}
+	if len(r.Errors) < maxRejectedRows {
+		r.Errors = append(r.Errors, RejectedRow{Index: index, Reason: reason, Error: err.Error()})
+	}
+}
+
+// strictFailed records that a strict insert couldn't write every row. Rows
+// which were written before the failure can't be taken back.
+func (r *InsertResult) strictFailed() {
+	if r.Accepted == 0 {
+		return
+	}
+	r.partial = true
+	r.Error = fmt.Sprintf("The insert failed after %d rows were written, which can't be taken back", r.Accepted)
+}
+
+// insert records the outcome of inserting a row
+func (r *InsertResult) insert(index int, reason string, err error) {
+	if err != nil {
+		r.reject(index, reason, err)
+		return
+	}
+	r.Accepted++
+}
+
+// status is OK if any rows were accepted, or accepted if they couldn't be
+// confirmed as saved. Otherwise it's too many requests if the
+// data sink was busy, a server error if it failed, and a bad request if the rows
+// themselves were the problem. A strict insert which failed part way through is
+// never OK.
+func (r InsertResult) status() int {
+	if r.partial {
+		if r.busy != nil {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusInternalServerError
+	}
+	if r.Unconfirmed {
+		return http.StatusAccepted
+	}
+	if r.Accepted > 0 || r.Rejected == 0 {
+		return http.StatusOK
+	}
+	if r.busy != nil {
+		return http.StatusTooManyRequests
+	}
+	if r.writeFailed {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
 func (a *ScratchDataAPIStruct) Copy(w http.ResponseWriter, r *http.Request) {
 	message := queue_models.CopyDataMessage{}
 
@@ -38,6 +161,12 @@ func (a *ScratchDataAPIStruct) Copy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = message.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	message.SourceID = a.AuthGetDatabaseID(r.Context())
 
 	teamId := a.AuthGetTeamID(r.Context())
@@ -50,7 +179,7 @@ func (a *ScratchDataAPIStruct) Copy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// enqueue the copy job
-	msg, err := a.storageServices.Database.Enqueue(models.CopyData, message)
+	msg, err := a.storageServices.Database.Enqueue(models.CopyData, message, message.DestinationID, message.DestinationTable)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -70,6 +199,10 @@ func (a *ScratchDataAPIStruct) Select(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == "POST" {
 		queryBytes, err := io.ReadAll(r.Body)
+		if errors.Is(err, errBodyTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
 		if err != nil && len(queryBytes) > 0 {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("Unable to read query"))
@@ -102,6 +235,15 @@ func (a *ScratchDataAPIStruct) executeQueryAndStreamData(ctx context.Context, w
 	case "ndjson":
 		w.Header().Set("Content-Type", "text/plain")
 		return dest.QueryNDJson(query, w)
+	case util.FormatParquet:
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		return dest.QueryFormat(query, util.FormatParquet, w)
+	case util.FormatArrow:
+		w.Header().Set("Content-Type", "application/vnd.apache.arrow.stream")
+		return dest.QueryFormat(query, util.FormatArrow, w)
+	case util.FormatXLSX:
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		return dest.QueryFormat(query, util.FormatXLSX, w)
 	default:
 		w.Header().Set("Content-Type", "application/json")
 		return dest.QueryJSON(query, w)
@@ -122,71 +264,216 @@ func (a *ScratchDataAPIStruct) Insert(w http.ResponseWriter, r *http.Request) {
 		flattener = HorizontalFlattener{}
 	}
 
-	body, err := io.ReadAll(r.Body)
-	insertSize.Observe(float64(len(body)))
+	strict := r.URL.Query().Get("strict") == "true"
 
+	system, err := a.systemColumns(r, databaseID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Unable to read data"))
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, InsertResult{Error: err.Error()})
+		return
+	}
+
+	if isNDJSON(r) {
+		a.insertNDJSON(w, r, databaseID, table, flattener, system, strict)
+		return
+	}
+
+	if isDelimited(r) {
+		a.insertDelimited(w, r, databaseID, table, flattener, system, strict)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	observeInsertSize(r, int64(len(body)))
+
+	if errors.Is(err, errBodyTooLarge) {
+		render.Status(r, http.StatusRequestEntityTooLarge)
+		render.JSON(w, r, InsertResult{Error: err.Error()})
+		return
+	} else if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, InsertResult{Error: "Unable to read data"})
 		return
 	}
 
 	if !gjson.ValidBytes(body) {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Invalid JSON"))
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, InsertResult{Error: "Invalid JSON"})
 		return
 	}
 
 	parsed := gjson.ParseBytes(body)
-
-	parsed.IsArray()
 	lines := parsed.Array()
 
 	insertArraySize.Observe(float64(len(lines)))
 
-	errorItems := map[int]bool{}
-	for i, line := range lines {
-		flatItems, err := flattener.Flatten(table, line.Raw)
-		if err != nil {
-			errorItems[i] = true
-			log.Trace().Err(err).Str("json", line.Raw).Msg("Unable to flatten JSON")
-			continue
-		}
+	dedup := a.newRowDeduper(r, databaseID, table)
+
+	result := InsertResult{}
+	if strict {
+		// Check every row before writing any of them
+		rows := make([][]JSONData, len(lines))
+		keys := make([]string, len(lines))
+		duplicate := make([]bool, len(lines))
+		for i, line := range lines {
+			keys[i] = dedup.key(line.Raw)
+			if dedup.seen(keys[i]) {
+				duplicate[i] = true
+				result.Duplicates++
+				continue
+			}
 
-		for _, flatItem := range flatItems {
-			var writeErr error
-			var toWrite string
+			rows[i], err = a.flattenRow(table, flattener, system, line.Raw)
+			if err != nil {
+				result.reject(i, rejectFlatten, err)
+			}
+		}
 
-			toWrite = flatItem.JSON
+		if result.Rejected == 0 {
+			for i := range rows {
+				if duplicate[i] {
+					continue
+				}
 
-			if !gjson.Get(flatItem.JSON, "__row_id").Exists() {
-				snowID := a.snow.Generate()
-				rowID := snowID.Int64()
-				if toWrite, err = sjson.Set(flatItem.JSON, "__row_id", rowID); err != nil {
-					log.Trace().Err(err).Str("json", flatItem.JSON).Msg("Unable to add __row_id")
+				err = a.writeRows(&result, databaseID, rows[i])
+				if err != nil {
+					result.reject(i, rejectWrite, err)
+					result.Rejected = len(rows) - result.Duplicates - result.Accepted
+					result.strictFailed()
+					break
 				}
+				dedup.remember(keys[i])
+				result.Accepted++
 			}
+		} else {
+			result.Rejected = len(lines) - result.Duplicates
+		}
+	} else {
+		for i, line := range lines {
+			a.insertUnlessDuplicate(&result, dedup, i, databaseID, table, flattener, system, line.Raw)
+		}
+	}
+
+	a.flushSink(databaseID, &result)
 
-			writeErr = a.dataSink.WriteData(databaseID, flatItem.Table, []byte(toWrite))
+	// Rejected rows need to be sent again, so a retry can't get this response
+	if result.Rejected > 0 {
+		dontRemember(r)
+	}
 
-			if writeErr != nil {
-				errorItems[i] = true
-				log.Trace().Err(writeErr).Str("json", flatItem.JSON).Msg("Unable to write JSON")
+	setRetryAfter(w, result)
+	render.Status(r, result.status())
+	render.JSON(w, r, result)
+}
+
+// flattenRow flattens a single JSON object, adding a __row_id and any system
+// columns to each resulting row
+func (a *ScratchDataAPIStruct) flattenRow(table string, flattener Flattener, system []systemColumn, row string) ([]JSONData, error) {
+	flatItems, err := flattener.Flatten(table, row)
+	if err != nil {
+		log.Trace().Err(err).Str("json", row).Msg("Unable to flatten JSON")
+		return nil, err
+	}
+
+	for i, flatItem := range flatItems {
+		if !gjson.Get(flatItem.JSON, "__row_id").Exists() {
+			snowID := a.snow.Generate()
+			rowID := snowID.Int64()
+			if flatItems[i].JSON, err = sjson.Set(flatItem.JSON, "__row_id", rowID); err != nil {
+				log.Trace().Err(err).Str("json", flatItem.JSON).Msg("Unable to add __row_id")
+				return nil, err
 			}
 		}
+
+		if flatItems[i].JSON, err = addSystemColumns(flatItems[i].JSON, system); err != nil {
+			log.Trace().Err(err).Str("json", flatItem.JSON).Msg("Unable to add system columns")
+			return nil, err
+		}
 	}
 
-	if len(errorItems) > 0 {
-		if len(errorItems) == len(lines) {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Unable to insert data"))
-			return
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Partially inserted data"))
-			return
+	return flatItems, nil
+}
+
+// flushSink makes sure the rows accepted by an insert are saved by the data sink.
+// The rows have already been written, so if they can't be confirmed as saved
+// they are still reported as accepted, and the insert is marked unconfirmed.
+func (a *ScratchDataAPIStruct) flushSink(databaseID int64, result *InsertResult) {
+	flusher, ok := a.dataSink.(datasink.Flusher)
+	if !ok || result.Accepted == 0 {
+		return
+	}
+
+	err := flusher.Flush(databaseID, result.tables)
+	if err != nil {
+		log.Error().Err(err).Int64("database_id", databaseID).Strs("tables", result.tables).Msg("Unable to flush data sink")
+		if result.Error == "" {
+			result.Error = "Data was written but could not be confirmed as saved"
 		}
+		result.Unconfirmed = true
+	}
+}
+
+// setRetryAfter tells the client when to retry an insert which was rejected
+// because the data sink was busy
+func setRetryAfter(w http.ResponseWriter, result InsertResult) {
+	if result.busy == nil {
+		return
+	}
+
+	seconds := int(math.Ceil(result.busy.RetryAfter().Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+}
+
+func (a *ScratchDataAPIStruct) writeRows(result *InsertResult, databaseID int64, rows []JSONData) error {
+	var rc error
+	for _, row := range rows {
+		result.wrote(row.Table)
+		err := a.dataSink.WriteData(databaseID, row.Table, []byte(row.JSON))
+		if err != nil {
+			rc = err
+			log.Trace().Err(err).Str("json", row.JSON).Msg("Unable to write JSON")
+		}
+	}
+	return rc
+}
+
+// insertUnlessDuplicate inserts a row unless its dedup_field value has already
+// been inserted, and records the outcome in result
+func (a *ScratchDataAPIStruct) insertUnlessDuplicate(result *InsertResult, dedup *rowDeduper, index int, databaseID int64, table string, flattener Flattener, system []systemColumn, row string) {
+	key := dedup.key(row)
+	if dedup.seen(key) {
+		result.Duplicates++
+		return
+	}
+
+	// Once the sink is busy, the rest of the rows are rejected without waiting on it
+	if result.busy != nil {
+		result.reject(index, rejectWrite, result.busy)
+		dedup.forget(key)
+		return
+	}
+
+	reason, err := a.insertRow(result, databaseID, table, flattener, system, row)
+	result.insert(index, reason, err)
+	if err != nil {
+		dedup.forget(key)
+	} else {
+		dedup.remember(key)
+	}
+}
+
+// insertRow flattens a single JSON object and writes the result to the data sink.
+// If the row can't be inserted, the reason it was rejected is returned with the error.
+func (a *ScratchDataAPIStruct) insertRow(result *InsertResult, databaseID int64, table string, flattener Flattener, system []systemColumn, row string) (string, error) {
+	rows, err := a.flattenRow(table, flattener, system, row)
+	if err != nil {
+		return rejectFlatten, err
+	}
+
+	err = a.writeRows(result, databaseID, rows)
+	if err != nil {
+		return rejectWrite, err
 	}
 
-	w.Write([]byte("ok"))
+	return "", nil
 }
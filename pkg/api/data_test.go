@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	sink_models "github.com/scratchdata/scratchdata/pkg/datasink/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
+	"github.com/tidwall/gjson"
+)
+
+type testSink struct {
+	mu   sync.Mutex
+	rows []string
+}
+
+func (s *testSink) Start(ctx context.Context) error { return nil }
+
+func (s *testSink) WriteData(databaseID int64, table string, data []byte) error {
+	if gjson.GetBytes(data, "fail").Bool() {
+		return errors.New("sink unavailable")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rows = append(s.rows, string(data))
+	return nil
+}
+
+func newInsertRequest(body string, contentType string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/data/insert/events", strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("table", "events")
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+	ctx = context.WithValue(ctx, "databaseId", uint(1))
+
+	return r.WithContext(ctx)
+}
+
+func newTestInsertAPI() (*ScratchDataAPIStruct, *testSink) {
+	snow, _ := util.NewSnowflakeGenerator()
+	sink := &testSink{}
+	return &ScratchDataAPIStruct{dataSink: sink, snow: snow}, sink
+}
+
+func doInsert(t *testing.T, a *ScratchDataAPIStruct, r *http.Request) (int, InsertResult) {
+	w := httptest.NewRecorder()
+	a.Insert(w, r)
+
+	var result InsertResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Unable to parse response %q: %s", w.Body.String(), err)
+	}
+	return w.Code, result
+}
+
+func rejectedRows(result InsertResult) string {
+	var rc []string
+	for _, row := range result.Errors {
+		rc = append(rc, fmt.Sprintf("%d:%s", row.Index, row.Reason))
+	}
+	return strings.Join(rc, ",")
+}
+
+func TestInsertReport(t *testing.T) {
+	a, sink := newTestInsertAPI()
+
+	body := `[{"user": "alice"}, 5, {"fail": true}, {"user": "bob"}]`
+	code, result := doInsert(t, a, newInsertRequest(body, "application/json"))
+
+	if code != http.StatusOK || result.Accepted != 2 || result.Rejected != 2 || len(sink.rows) != 2 {
+		t.Fatalf("Expected 2 of 4 rows to be accepted; Got %d %+v", code, result)
+	}
+	if rows := rejectedRows(result); rows != "1:flatten_error,2:write_error" {
+		t.Fatalf("Expected rows 1 and 2 to be rejected; Got %s", rows)
+	}
+
+	code, result = doInsert(t, a, newInsertRequest(`[{"fail": true}]`, "application/json"))
+	if code != http.StatusInternalServerError || result.Rejected != 1 {
+		t.Fatalf("Expected a server error when the sink fails; Got %d %+v", code, result)
+	}
+
+	r := newInsertRequest(body, "application/json")
+	r.URL.RawQuery = "strict=true"
+	code, result = doInsert(t, a, r)
+
+	if code != http.StatusBadRequest || result.Accepted != 0 || result.Rejected != 4 || len(sink.rows) != 2 {
+		t.Fatalf("Expected strict insert to reject every row; Got %d %+v", code, result)
+	}
+	if rows := rejectedRows(result); rows != "1:flatten_error" {
+		t.Fatalf("Expected row 1 to be reported; Got %s", rows)
+	}
+
+	// A strict insert which fails part way through isn't OK, even though some
+	// rows were written
+	r = newInsertRequest(`[{"user": "erin"}, {"fail": true}]`, "application/json")
+	r.URL.RawQuery = "strict=true"
+	code, result = doInsert(t, a, r)
+
+	if code != http.StatusInternalServerError || result.Accepted != 1 || result.Rejected != 1 || result.Error == "" {
+		t.Fatalf("Expected a server error reporting the row which was written; Got %d %+v", code, result)
+	}
+}
+
+type flushingSink struct {
+	testSink
+	err    error
+	tables []string
+}
+
+func (s *flushingSink) Flush(databaseID int64, tables []string) error {
+	s.tables = tables
+	return s.err
+}
+
+func TestInsertFlushFailure(t *testing.T) {
+	a, _ := newTestInsertAPI()
+	sink := &flushingSink{err: errors.New("disk unavailable")}
+	a.dataSink = sink
+
+	code, result := doInsert(t, a, newInsertRequest("{\"user\": \"alice\"}\n{\"user\": \"bob\"}\n", "application/x-ndjson"))
+	if code != http.StatusAccepted || result.Accepted != 2 || result.Rejected != 0 || !result.Unconfirmed {
+		t.Fatalf("Expected written rows to be accepted but unconfirmed; Got %d %+v", code, result)
+	}
+	if len(sink.tables) != 1 || sink.tables[0] != "events" {
+		t.Fatalf("Expected the table written to be flushed; Got %v", sink.tables)
+	}
+
+	sink.err = nil
+	code, result = doInsert(t, a, newInsertRequest(`[{"user": "carol"}]`, "application/json"))
+	if code != http.StatusOK || result.Accepted != 1 {
+		t.Fatalf("Expected the insert to succeed; Got %d %+v", code, result)
+	}
+}
+
+type busySink struct {
+	testSink
+}
+
+func (s *busySink) WriteData(databaseID int64, table string, data []byte) error {
+	return sink_models.BusyError{Reason: "Disk is full", Wait: 10 * time.Second}
+}
+
+func TestInsertBusySink(t *testing.T) {
+	a, _ := newTestInsertAPI()
+	a.dataSink = &busySink{}
+
+	w := httptest.NewRecorder()
+	a.Insert(w, newInsertRequest(`[{"user": "alice"}, {"user": "bob"}]`, "application/json"))
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "10" {
+		t.Fatalf("Expected a 429 with Retry-After; Got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -1,25 +1,255 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/go-chi/render"
 	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/datasink"
+	sink_models "github.com/scratchdata/scratchdata/pkg/datasink/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
 )
 
-func (a *ScratchDataAPIStruct) Healthcheck(w http.ResponseWriter, r *http.Request) {
+// How long readiness checks, such as pinging destinations, may take
+const readinessTimeout = 5 * time.Second
+
+// How long destination pings are reused for. /readyz is unauthenticated, so
+// destinations are pinged at most this often however often it's called.
+const destinationPingInterval = time.Minute
+
+// destinationPings is the last result of pinging every destination
+type destinationPings struct {
+	mu        sync.Mutex
+	check     *DestinationsCheck
+	checkedAt time.Time
+	running   bool
+}
+
+// unhealthy returns an error if the healthcheck fail file has been created, so
+// that the server is taken out of service
+func (a *ScratchDataAPIStruct) unhealthy() error {
 	_, err := os.Stat(a.config.HealthCheckFailFile)
 	if err == nil {
-		http.Error(w, "Status set to unhealthy", http.StatusServiceUnavailable)
-		return
+		return errors.New("Status set to unhealthy")
 	} else if !errors.Is(err, os.ErrNotExist) {
 		log.Error().Err(err).Msg("Unable to check for unhealthy file")
 	}
+	return nil
+}
 
-	// TODO: make sure disk is not full when ingesting
-	// a.dataSink.Healthcheck
+func (a *ScratchDataAPIStruct) Healthcheck(w http.ResponseWriter, r *http.Request) {
+	if err := a.unhealthy(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
 	render.PlainText(w, r, "ok")
 }
+
+// Healthz is a liveness check. It succeeds as long as the server can respond.
+func (a *ScratchDataAPIStruct) Healthz(w http.ResponseWriter, r *http.Request) {
+	render.PlainText(w, r, "ok")
+}
+
+type Check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func newCheck(err error) Check {
+	if err != nil {
+		return Check{OK: false, Error: err.Error()}
+	}
+	return Check{OK: true}
+}
+
+// redactedCheck is a check whose error is logged rather than reported, as it
+// may include internal details such as host names
+func redactedCheck(err error, message string) Check {
+	if err != nil {
+		log.Error().Err(err).Msg(message)
+		return Check{OK: false, Error: message}
+	}
+	return Check{OK: true}
+}
+
+type SinkCheck struct {
+	Check
+	sink_models.Health
+}
+
+type DiskCheck struct {
+	Check
+	FreeBytes     uint64 `json:"free_bytes"`
+	RequiredBytes int64  `json:"required_bytes"`
+}
+
+// DestinationsCheck counts destinations which can't be reached. Which
+// destinations failed, and why, is only logged since /readyz isn't
+// authenticated.
+type DestinationsCheck struct {
+	Check
+	Checked     int       `json:"checked"`
+	Unreachable int       `json:"unreachable"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+type QueueCheck struct {
+	Check
+	Pending  int64 `json:"pending"`
+	InFlight int64 `json:"in_flight"`
+}
+
+// ReadinessReport is the result of each readiness check. Destinations don't
+// affect readiness, since they are shared with other servers.
+type ReadinessReport struct {
+	Ready        bool               `json:"ready"`
+	Status       Check              `json:"status"`
+	Sink         SinkCheck          `json:"sink"`
+	WorkerDisk   *DiskCheck         `json:"worker_disk,omitempty"`
+	Database     Check              `json:"database"`
+	Queue        QueueCheck         `json:"queue"`
+	Destinations *DestinationsCheck `json:"destinations,omitempty"`
+}
+
+// Readyz is a readiness check. It fails when this server can't accept inserts,
+// such as when the data sink is out of disk space or the database can't be
+// reached. Destinations are only reported with ?destinations=true.
+func (a *ScratchDataAPIStruct) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	report := ReadinessReport{
+		Status:     newCheck(a.unhealthy()),
+		Sink:       a.checkSink(),
+		WorkerDisk: a.checkWorkerDisk(),
+		Database:   redactedCheck(a.storageServices.Database.Ping(ctx), "Unable to reach the database"),
+		Queue:      a.checkQueue(),
+	}
+	if r.URL.Query().Get("destinations") == "true" {
+		report.Destinations = a.checkDestinations()
+	}
+
+	report.Ready = report.Status.OK && report.Sink.OK && report.Database.OK && report.Queue.OK
+	if report.WorkerDisk != nil {
+		report.Ready = report.Ready && report.WorkerDisk.OK
+	}
+
+	if report.Ready {
+		render.Status(r, http.StatusOK)
+	} else {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, report)
+}
+
+func (a *ScratchDataAPIStruct) checkSink() SinkCheck {
+	reporter, ok := a.dataSink.(datasink.HealthReporter)
+	if !ok {
+		return SinkCheck{Check: Check{OK: true}}
+	}
+
+	health, err := reporter.Health()
+	return SinkCheck{Check: newCheck(err), Health: health}
+}
+
+// checkWorkerDisk checks that workers have free_space_required_bytes of disk
+// space to download files into. It returns nil if workers aren't enabled.
+func (a *ScratchDataAPIStruct) checkWorkerDisk() *DiskCheck {
+	if !a.workersConfig.Enabled || a.workersConfig.FreeSpaceRequiredBytes <= 0 {
+		return nil
+	}
+
+	check := &DiskCheck{
+		Check:         Check{OK: true},
+		FreeBytes:     util.FreeDiskSpace(a.workersConfig.DataDirectory),
+		RequiredBytes: a.workersConfig.FreeSpaceRequiredBytes,
+	}
+	if check.FreeBytes < uint64(check.RequiredBytes) {
+		check.Check = newCheck(errors.New("Not enough free disk space for workers"))
+	}
+	return check
+}
+
+// checkQueue counts queued messages. The queue is full if more messages are
+// waiting than api.ready_max_queue_depth.
+func (a *ScratchDataAPIStruct) checkQueue() QueueCheck {
+	pending, err := a.storageServices.Database.QueueDepth()
+	if err != nil {
+		return QueueCheck{Check: redactedCheck(err, "Unable to count queued messages")}
+	}
+
+	inFlight, err := a.storageServices.Database.InFlightCounts()
+	if err != nil {
+		return QueueCheck{Check: redactedCheck(err, "Unable to count queued messages")}
+	}
+
+	check := QueueCheck{Check: Check{OK: true}, Pending: pending}
+	for _, count := range inFlight {
+		check.InFlight += count
+	}
+
+	if a.config.ReadyMaxQueueDepth > 0 && pending > a.config.ReadyMaxQueueDepth {
+		check.Check = newCheck(fmt.Errorf("%d messages are queued, more than %d", pending, a.config.ReadyMaxQueueDepth))
+	}
+	return check
+}
+
+// checkDestinations returns the last result of pinging every destination, and
+// starts pinging them again in the background if it's older than
+// destinationPingInterval. It's nil until the first pings finish, or if this
+// server doesn't manage destinations.
+func (a *ScratchDataAPIStruct) checkDestinations() *DestinationsCheck {
+	if a.destinationManager == nil {
+		return nil
+	}
+
+	pings := &a.destinationPings
+	pings.mu.Lock()
+	defer pings.mu.Unlock()
+
+	if !pings.running && time.Since(pings.checkedAt) >= destinationPingInterval {
+		pings.running = true
+		go a.pingDestinations()
+	}
+	return pings.check
+}
+
+// pingDestinations pings every destination and saves the result for
+// checkDestinations
+func (a *ScratchDataAPIStruct) pingDestinations() {
+	ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+	defer cancel()
+
+	check := &DestinationsCheck{Check: Check{OK: true}, CheckedAt: time.Now()}
+
+	results, err := a.destinationManager.PingAll(ctx)
+	if err != nil {
+		check.Check = redactedCheck(err, "Unable to list destinations")
+	}
+
+	check.Checked = len(results)
+	for id, err := range results {
+		if err != nil {
+			log.Error().Err(err).Int64("destination_id", id).Msg("Unable to ping destination")
+			check.Unreachable++
+		}
+	}
+
+	if check.Unreachable > 0 {
+		check.Check = newCheck(fmt.Errorf("%d of %d destinations can't be reached", check.Unreachable, check.Checked))
+	}
+
+	pings := &a.destinationPings
+	pings.mu.Lock()
+	defer pings.mu.Unlock()
+	pings.check = check
+	pings.checkedAt = check.CheckedAt
+	pings.running = false
+}
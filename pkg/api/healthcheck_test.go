@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sink_models "github.com/scratchdata/scratchdata/pkg/datasink/models"
+	"github.com/scratchdata/scratchdata/pkg/destinations"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+)
+
+type healthSink struct {
+	testSink
+	health sink_models.Health
+	err    error
+}
+
+func (s *healthSink) Health() (sink_models.Health, error) {
+	return s.health, s.err
+}
+
+func doReadyz(t *testing.T, a *ScratchDataAPIStruct) (int, ReadinessReport) {
+	w := httptest.NewRecorder()
+	a.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report ReadinessReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Unable to parse response %q: %s", w.Body.String(), err)
+	}
+	return w.Code, report
+}
+
+func TestReadyz(t *testing.T) {
+	a, db, _ := newTestUploadAPI(t)
+	sink := &healthSink{health: sink_models.Health{FreeDiskBytes: 1000, BacklogBytes: 10}}
+	a.dataSink = sink
+	a.config.HealthCheckFailFile = t.TempDir() + "/unhealthy"
+
+	code, report := doReadyz(t, a)
+	if code != http.StatusOK || !report.Ready || report.Sink.BacklogBytes != 10 {
+		t.Fatalf("Expected ready; Got %d %+v", code, report)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := db.Enqueue(models.InsertData, queue_models.FileUploadMessage{DatabaseID: 1, Table: "events"}, 1, "events")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.config.ReadyMaxQueueDepth = 1
+
+	code, report = doReadyz(t, a)
+	if code != http.StatusServiceUnavailable || report.Queue.OK || report.Queue.Pending != 2 {
+		t.Fatalf("Expected the queue to be full; Got %d %+v", code, report.Queue)
+	}
+
+	a.config.ReadyMaxQueueDepth = 0
+	sink.err = errors.New("Disk is full")
+
+	code, report = doReadyz(t, a)
+	if code != http.StatusServiceUnavailable || report.Sink.OK || report.Sink.Error != "Disk is full" {
+		t.Fatalf("Expected the sink to be unavailable; Got %d %+v", code, report.Sink)
+	}
+}
+
+func TestReadyzDestinations(t *testing.T) {
+	a, db, _ := newTestUploadAPI(t)
+	a.dataSink = &healthSink{}
+	a.config.HealthCheckFailFile = t.TempDir() + "/unhealthy"
+	a.destinationManager = destinations.NewDestinationManager(a.storageServices)
+
+	_, err := db.CreateDestination(context.Background(), 1, "broken", "nosuch", map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Destinations are only pinged when asked for
+	_, report := doReadyz(t, a)
+	if report.Destinations != nil || a.destinationPings.running {
+		t.Fatalf("Expected destinations not to be checked; Got %+v", report.Destinations)
+	}
+
+	a.pingDestinations()
+
+	w := httptest.NewRecorder()
+	a.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz?destinations=true", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unreachable destination doesn't make the server unready
+	if w.Code != http.StatusOK || report.Destinations == nil {
+		t.Fatalf("Expected ready with destinations checked; Got %d %s", w.Code, w.Body.String())
+	}
+	if report.Destinations.OK || report.Destinations.Checked != 1 || report.Destinations.Unreachable != 1 {
+		t.Fatalf("Expected 1 unreachable destination; Got %+v", report.Destinations)
+	}
+	if strings.Contains(w.Body.String(), "nosuch") {
+		t.Fatalf("Expected destination errors to be redacted; Got %s", w.Body.String())
+	}
+
+	// Recent pings are reused rather than pinging every destination again
+	if a.destinationPings.running {
+		t.Fatalf("Expected destinations not to be pinged again so soon")
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	a, _ := newTestInsertAPI()
+
+	w := httptest.NewRecorder()
+	a.Healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("Expected ok; Got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/storage/cache"
+	"github.com/tidwall/gjson"
+)
+
+// How long idempotency keys and dedup values are remembered, unless
+// api.idempotency_window_seconds is set
+const defaultIdempotencyWindow = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+// idempotentResponse is the response to a request with an Idempotency-Key, saved
+// so that it can be sent again when the request is retried
+type idempotentResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// unrememberedKey is the context key of the flag handlers set with
+// dontRemember
+type unrememberedKey struct{}
+
+// dontRemember stops the response to a request from being replayed for its
+// Idempotency-Key, such as when some rows were rejected and the request needs
+// to be sent again
+func dontRemember(r *http.Request) {
+	if flag, ok := r.Context().Value(unrememberedKey{}).(*bool); ok {
+		*flag = true
+	}
+}
+
+func (a *ScratchDataAPIStruct) cache() cache.Cache {
+	if a.storageServices == nil {
+		return nil
+	}
+	return a.storageServices.Cache
+}
+
+func (a *ScratchDataAPIStruct) idempotencyWindow() time.Duration {
+	if a.config.IdempotencyWindowSeconds > 0 {
+		return time.Duration(a.config.IdempotencyWindowSeconds) * time.Second
+	}
+	return defaultIdempotencyWindow
+}
+
+// Idempotent remembers the response to a successful request sent with an
+// Idempotency-Key header. A retry with the same key gets the same response
+// without the request being processed again. A retry which arrives while the
+// first request is still running is rejected with a 409. Responses which the
+// handler marked with dontRemember aren't saved.
+func (a *ScratchDataAPIStruct) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		c := a.cache()
+		if key == "" || c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		databaseID := a.AuthGetDatabaseID(r.Context())
+		cacheKey := fmt.Sprintf("idempotency/%d%s/%s", databaseID, r.URL.Path, key)
+
+		if _, running := a.idempotencyInFlight.LoadOrStore(cacheKey, true); running {
+			http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+			return
+		}
+		defer a.idempotencyInFlight.Delete(cacheKey)
+
+		if saved, ok := c.Get(cacheKey); ok {
+			var response idempotentResponse
+			if err := json.Unmarshal(saved, &response); err == nil {
+				w.Header().Set("Content-Type", response.ContentType)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(response.Status)
+				w.Write(response.Body)
+				return
+			}
+		}
+
+		var body bytes.Buffer
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&body)
+
+		unremembered := false
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), unrememberedKey{}, &unremembered)))
+
+		// Failed requests may be retried with the same key
+		status := ww.Status()
+		if status < 200 || status > 299 || unremembered {
+			return
+		}
+
+		saved, err := json.Marshal(idempotentResponse{
+			Status:      status,
+			ContentType: ww.Header().Get("Content-Type"),
+			Body:        body.Bytes(),
+		})
+		if err != nil {
+			return
+		}
+
+		window := a.idempotencyWindow()
+		err = c.Set(cacheKey, saved, &window)
+		if err != nil {
+			log.Error().Err(err).Int64("database_id", databaseID).Msg("Unable to save idempotent response")
+		}
+	})
+}
+
+// rowDeduper skips rows whose dedup field has the same value as a row which was
+// already inserted. A nil rowDeduper doesn't skip anything.
+type rowDeduper struct {
+	cache  cache.Cache
+	prefix string
+	field  string
+	window time.Duration
+
+	// Values in this request, including those of rows which haven't been
+	// written yet
+	batch map[string]bool
+}
+
+// newRowDeduper returns a rowDeduper for the dedup_field query parameter, or nil
+// if it isn't set
+func (a *ScratchDataAPIStruct) newRowDeduper(r *http.Request, databaseID int64, table string) *rowDeduper {
+	field := r.URL.Query().Get("dedup_field")
+	c := a.cache()
+	if field == "" || c == nil {
+		return nil
+	}
+
+	return &rowDeduper{
+		cache:  c,
+		prefix: fmt.Sprintf("dedup/%d/%s/%s/", databaseID, table, field),
+		field:  field,
+		window: a.idempotencyWindow(),
+		batch:  map[string]bool{},
+	}
+}
+
+// key returns the cache key for a row, or "" if the row has no dedup value
+func (d *rowDeduper) key(row string) string {
+	if d == nil {
+		return ""
+	}
+
+	value := gjson.Get(row, d.field)
+	if !value.Exists() || value.Type == gjson.Null {
+		return ""
+	}
+	return d.prefix + value.String()
+}
+
+// seen returns whether a row with this key has been inserted, or is about to be.
+// Otherwise the key is reserved so that later rows in the request are skipped.
+func (d *rowDeduper) seen(key string) bool {
+	if d == nil || key == "" {
+		return false
+	}
+
+	if d.batch[key] {
+		return true
+	}
+	if _, ok := d.cache.Get(key); ok {
+		return true
+	}
+
+	d.batch[key] = true
+	return false
+}
+
+// remember records that the row with this key has been written
+func (d *rowDeduper) remember(key string) {
+	if d == nil || key == "" {
+		return
+	}
+
+	err := d.cache.Set(key, []byte{1}, &d.window)
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Unable to remember dedup value")
+	}
+}
+
+// forget releases a key reserved by seen, after its row failed to be written
+func (d *rowDeduper) forget(key string) {
+	if d == nil || key == "" {
+		return
+	}
+	delete(d.batch, key)
+}
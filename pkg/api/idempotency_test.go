@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scratchdata/scratchdata/pkg/storage"
+	"github.com/scratchdata/scratchdata/pkg/storage/cache/memory"
+)
+
+func newTestIdempotentAPI() (*ScratchDataAPIStruct, *testSink) {
+	a, sink := newTestInsertAPI()
+	c, _ := memory.NewCache(nil)
+	a.storageServices = &storage.Services{Cache: c}
+	return a, sink
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	a, sink := newTestIdempotentAPI()
+	handler := a.Idempotent(http.HandlerFunc(a.Insert))
+
+	send := func(body string, key string) *httptest.ResponseRecorder {
+		r := newInsertRequest(body, "application/json")
+		r.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	first := send(`[{"user": "alice"}, {"user": "bob"}]`, "batch-1")
+	retry := send(`[{"user": "alice"}, {"user": "bob"}]`, "batch-1")
+
+	if len(sink.rows) != 2 {
+		t.Fatalf("Expected the retry not to be written; Got %d rows", len(sink.rows))
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("Expected the first response to be replayed; Got %d %s", retry.Code, retry.Body.String())
+	}
+
+	send(`[{"user": "carol"}]`, "batch-2")
+	if len(sink.rows) != 3 {
+		t.Fatalf("Expected a new key to be written; Got %d rows", len(sink.rows))
+	}
+
+	// Failed requests aren't remembered, so they can be fixed and sent again
+	if w := send(`[{"user": "dave"`, "batch-3"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected invalid JSON to be rejected; Got %d", w.Code)
+	}
+	if w := send(`[{"user": "dave"}]`, "batch-3"); w.Code != http.StatusOK || len(sink.rows) != 4 {
+		t.Fatalf("Expected a fixed request to be written; Got %d with %d rows", w.Code, len(sink.rows))
+	}
+
+	// Nor are inserts where some rows were rejected, so that those rows can be
+	// sent again
+	if w := send(`[{"user": "frank"}, {"fail": true}]`, "batch-5"); w.Code != http.StatusOK || len(sink.rows) != 5 {
+		t.Fatalf("Expected one row to be written; Got %d with %d rows", w.Code, len(sink.rows))
+	}
+	if w := send(`[{"user": "grace"}]`, "batch-5"); w.Header().Get("Idempotent-Replayed") != "" || len(sink.rows) != 6 {
+		t.Fatalf("Expected the rejected row to be sent again; Got %d with %d rows", w.Code, len(sink.rows))
+	}
+
+	a.idempotencyInFlight.Store("idempotency/1/api/data/insert/events/batch-4", true)
+	if w := send(`[{"user": "erin"}]`, "batch-4"); w.Code != http.StatusConflict {
+		t.Fatalf("Expected a request still in progress to conflict; Got %d", w.Code)
+	}
+}
+
+func TestDedupField(t *testing.T) {
+	a, sink := newTestIdempotentAPI()
+
+	insert := func(body string, contentType string, query string) InsertResult {
+		r := newInsertRequest(body, contentType)
+		r.URL.RawQuery = query
+		_, result := doInsert(t, a, r)
+		return result
+	}
+
+	result := insert(`[{"id": 1}, {"id": 2}, {"id": 1}, {"name": "no id"}]`, "application/json", "dedup_field=id")
+	if result.Accepted != 3 || result.Duplicates != 1 || len(sink.rows) != 3 {
+		t.Fatalf("Expected a duplicate in the same batch to be skipped; Got %+v", result)
+	}
+
+	result = insert("{\"id\": 2}\n{\"id\": 3}\n", "application/x-ndjson", "dedup_field=id")
+	if result.Accepted != 1 || result.Duplicates != 1 || len(sink.rows) != 4 {
+		t.Fatalf("Expected a row from an earlier batch to be skipped; Got %+v", result)
+	}
+
+	result = insert(`[{"id": 3}, {"id": 4}, {"id": 4}]`, "application/json", "dedup_field=id&strict=true")
+	if result.Accepted != 1 || result.Rejected != 0 || result.Duplicates != 2 || len(sink.rows) != 5 {
+		t.Fatalf("Expected duplicates to be skipped by a strict insert; Got %+v", result)
+	}
+
+	result = insert("{\"id\": 5}\n{\"id\": 5}\n", "application/x-ndjson", "dedup_field=id&strict=true")
+	if result.Accepted != 1 || result.Duplicates != 1 || len(sink.rows) != 6 {
+		t.Fatalf("Expected duplicates to be skipped by a strict stream; Got %+v", result)
+	}
+
+	// Rows which fail to be written can be sent again
+	result = insert(`[{"id": 6, "fail": true}]`, "application/json", "dedup_field=id")
+	if result.Rejected != 1 {
+		t.Fatalf("Expected the row to be rejected; Got %+v", result)
+	}
+	result = insert(`[{"id": 6}]`, "application/json", "dedup_field=id")
+	if result.Accepted != 1 || result.Duplicates != 0 {
+		t.Fatalf("Expected a failed row not to be remembered; Got %+v", result)
+	}
+}
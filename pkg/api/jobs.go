@@ -0,0 +1,231 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	"gorm.io/gorm"
+)
+
+type Job struct {
+	ID               uint            `json:"job_id"`
+	Type             string          `json:"type"`
+	Status           string          `json:"status"`
+	DestinationID    uint            `json:"destination_id"`
+	DestinationTable string          `json:"destination_table"`
+	Attempts         int             `json:"attempts"`
+	Error            string          `json:"error,omitempty"`
+	RowsProcessed    int64           `json:"rows_processed"`
+	BytesProcessed   int64           `json:"bytes_processed"`
+	ChunksTotal      int             `json:"chunks_total,omitempty"`
+	ChunksFailed     int             `json:"chunks_failed,omitempty"`
+	Partial          bool            `json:"partial,omitempty"`
+	ClaimedBy        string          `json:"claimed_by,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+	ClaimedAt        *time.Time      `json:"claimed_at,omitempty"`
+	StartedAt        *time.Time      `json:"started_at,omitempty"`
+	CompletedAt      *time.Time      `json:"completed_at,omitempty"`
+	Message          json.RawMessage `json:"message"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func messageToJob(m models.Message) Job {
+	return Job{
+		ID:               m.ID,
+		Type:             strings.ToLower(string(m.MessageType)),
+		Status:           strings.ToLower(string(m.Status)),
+		DestinationID:    m.DestinationID,
+		DestinationTable: m.DestinationTable,
+		Attempts:         m.Attempts,
+		Error:            m.Error,
+		RowsProcessed:    m.RowsProcessed,
+		BytesProcessed:   m.BytesProcessed,
+		ChunksTotal:      m.ChunksTotal,
+		ChunksFailed:     m.ChunksFailed,
+		Partial:          m.ChunksFailed > 0 && m.ChunksFailed < m.ChunksTotal,
+		ClaimedBy:        m.ClaimedBy,
+		CreatedAt:        m.CreatedAt,
+		UpdatedAt:        m.UpdatedAt,
+		ClaimedAt:        optionalTime(m.ClaimedAt),
+		StartedAt:        optionalTime(m.StartedAt),
+		CompletedAt:      optionalTime(m.CompletedAt),
+		Message:          json.RawMessage(m.Message),
+	}
+}
+
+type JobChunk struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (a *ScratchDataAPIStruct) GetJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	teamId := a.AuthGetTeamID(r.Context())
+	message, err := a.storageServices.Database.GetMessage(r.Context(), teamId, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, messageToJob(message))
+}
+
+// GetJobChunks lists how each chunk of a copy was loaded
+func (a *ScratchDataAPIStruct) GetJobChunks(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	teamId := a.AuthGetTeamID(r.Context())
+	_, err = a.storageServices.Database.GetMessage(r.Context(), teamId, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	chunks, err := a.storageServices.Database.GetCopyChunks(uint(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rc := make([]JobChunk, len(chunks))
+	for i, c := range chunks {
+		rc[i] = JobChunk{
+			Name:   c.Name,
+			Status: strings.ToLower(string(c.Status)),
+			Rows:   c.Rows,
+			Bytes:  c.Bytes,
+			Error:  c.Error,
+		}
+	}
+
+	render.JSON(w, r, rc)
+}
+
+// RequeueJob moves a dead lettered job back into the queue
+func (a *ScratchDataAPIStruct) RequeueJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	teamId := a.AuthGetTeamID(r.Context())
+	message, err := a.storageServices.Database.RequeueMessage(r.Context(), teamId, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrNotDeadLettered) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, messageToJob(message))
+}
+
+func parseMessageFilter(r *http.Request) (models.MessageFilter, error) {
+	q := r.URL.Query()
+	filter := models.MessageFilter{
+		MessageType:      models.MessageType(strings.ToUpper(q.Get("type"))),
+		Status:           models.MessageStatus(strings.ToUpper(q.Get("status"))),
+		DestinationTable: q.Get("table"),
+	}
+
+	if v := q.Get("destination_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return filter, errors.New("invalid destination_id")
+		}
+		filter.DestinationID = uint(id)
+	}
+
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, errors.New("since must be an RFC3339 timestamp")
+		}
+		filter.CreatedAfter = t
+	}
+
+	if v := q.Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, errors.New("until must be an RFC3339 timestamp")
+		}
+		filter.CreatedBefore = t
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, errors.New("invalid limit")
+		}
+		filter.Limit = limit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, errors.New("invalid offset")
+		}
+		filter.Offset = offset
+	}
+
+	return filter, nil
+}
+
+func (a *ScratchDataAPIStruct) GetJobs(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseMessageFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	teamId := a.AuthGetTeamID(r.Context())
+	messages, err := a.storageServices.Database.GetMessages(r.Context(), teamId, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jobs := make([]Job, len(messages))
+	for i, message := range messages {
+		jobs[i] = messageToJob(message)
+	}
+
+	render.JSON(w, r, jobs)
+}
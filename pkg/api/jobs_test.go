@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+)
+
+func TestParseMessageFilter(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/jobs?type=copy_data&status=dead_letter&destination_id=3&table=events&since=2024-01-02T03:04:05Z&limit=10&offset=20", nil)
+
+	filter, err := parseMessageFilter(r)
+	if err != nil {
+		t.Fatalf("Unable to parse filter: %s", err)
+	}
+
+	expected := models.MessageFilter{
+		MessageType:      models.CopyData,
+		Status:           models.DeadLetter,
+		DestinationID:    3,
+		DestinationTable: "events",
+		CreatedAfter:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Limit:            10,
+		Offset:           20,
+	}
+	if filter != expected {
+		t.Fatalf("Expected %+v; Got %+v", expected, filter)
+	}
+
+	invalid := []string{
+		"destination_id=abc",
+		"since=yesterday",
+		"until=2024-01-02",
+		"limit=ten",
+		"offset=-1",
+	}
+	for _, query := range invalid {
+		r := httptest.NewRequest(http.MethodGet, "/api/jobs?"+query, nil)
+		if _, err := parseMessageFilter(r); err == nil {
+			t.Fatalf("Expected an error for %s", query)
+		}
+	}
+}
+
+func TestGetJobTeamScoping(t *testing.T) {
+	a, db, _ := newTestUploadAPI(t)
+	ctx := context.Background()
+
+	ours, _ := db.CreateDestination(ctx, 1, "ours", "duckdb", map[string]any{})
+	theirs, _ := db.CreateDestination(ctx, 2, "theirs", "duckdb", map[string]any{})
+	mine, _ := db.Enqueue(models.InsertData, map[string]int{}, ours.ID, "events")
+	other, _ := db.Enqueue(models.InsertData, map[string]int{}, theirs.ID, "events")
+
+	getJob := func(id uint) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/jobs/"+strconv.Itoa(int(id)), nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(int(id)))
+		reqCtx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+		reqCtx = context.WithValue(reqCtx, "teamId", uint(1))
+
+		w := httptest.NewRecorder()
+		a.GetJob(w, r.WithContext(reqCtx))
+		return w.Code
+	}
+
+	if code := getJob(mine.ID); code != http.StatusOK {
+		t.Fatalf("Expected 200 for the team's own job; Got %d", code)
+	}
+	if code := getJob(other.ID); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for another team's job; Got %d", code)
+	}
+}
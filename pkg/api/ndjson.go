@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+)
+
+// Longest single line accepted by a streaming insert. Longer lines are rejected
+// without being held in memory.
+const maxNDJSONLineBytes = 50 * 1000000
+
+var errLineTooLong = errors.New("line too long")
+
+// requestMediaType returns the request's Content-Type without any parameters
+func requestMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+func isNDJSON(r *http.Request) bool {
+	mediaType := requestMediaType(r)
+	return mediaType == "application/x-ndjson" || mediaType == "application/ndjson"
+}
+
+// readNDJSONLine reads the next line into buf, without the trailing newline. If the
+// line is longer than max, the rest of it is discarded and errLineTooLong is returned.
+func readNDJSONLine(reader *bufio.Reader, buf []byte, max int) ([]byte, error) {
+	buf = buf[:0]
+	tooLong := false
+
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return buf, err
+		}
+
+		if !tooLong {
+			if len(buf)+len(chunk) > max {
+				tooLong = true
+				buf = buf[:0]
+			} else {
+				buf = append(buf, chunk...)
+			}
+		}
+
+		if !isPrefix {
+			break
+		}
+	}
+
+	if tooLong {
+		return buf, errLineTooLong
+	}
+	return buf, nil
+}
+
+// ndjsonRows reads one row per line, skipping blank lines. The index of a row is
+// its line number in the body.
+func ndjsonRows(body io.Reader) rowReader {
+	reader := bufio.NewReaderSize(body, 64*1024)
+	index := -1
+
+	var buf []byte
+	return func() ([]byte, int, error) {
+		for {
+			line, err := readNDJSONLine(reader, buf, maxNDJSONLineBytes)
+			buf = line
+			if err == io.EOF {
+				return nil, 0, err
+			}
+
+			index++
+
+			if err == errLineTooLong {
+				return nil, index, rejectedRowError{reason: rejectInvalidJSON, err: err}
+			}
+			if err != nil {
+				return nil, index, err
+			}
+
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+
+			if !gjson.ValidBytes(line) {
+				return nil, index, rejectedRowError{reason: rejectInvalidJSON, err: errors.New("invalid JSON")}
+			}
+
+			return line, index, nil
+		}
+	}
+}
+
+func (a *ScratchDataAPIStruct) insertNDJSON(w http.ResponseWriter, r *http.Request, databaseID int64, table string, flattener Flattener, system []systemColumn, strict bool) {
+	body := &countingReader{r: r.Body}
+	a.insertStream(w, r, body, ndjsonRows(body), databaseID, table, flattener, system, strict)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestInsertNDJSON(t *testing.T) {
+	a, sink := newTestInsertAPI()
+
+	body := `{"user": "alice"}
+
+{"user": 
+{"user": "bob", "fail": true}
+{"user": "carol"}`
+
+	code, result := doInsert(t, a, newInsertRequest(body, "application/x-ndjson; charset=utf-8"))
+
+	if code != http.StatusOK || result.Accepted != 2 || result.Rejected != 2 {
+		t.Fatalf("Expected 2 of 4 lines to be accepted; Got %d %+v", code, result)
+	}
+	if rows := rejectedRows(result); rows != "2:invalid_json,3:write_error" {
+		t.Fatalf("Expected lines 2 and 3 to be rejected; Got %s", rows)
+	}
+
+	if len(sink.rows) != 2 || !gjson.Get(sink.rows[0], "__row_id").Exists() || gjson.Get(sink.rows[1], "user").String() != "carol" {
+		t.Fatalf("Expected rows for alice and carol with a __row_id; Got %v", sink.rows)
+	}
+}
+
+func TestInsertNDJSONStrict(t *testing.T) {
+	a, sink := newTestInsertAPI()
+	a.config.DataDirectory = filepath.Join(t.TempDir(), "api")
+
+	r := newInsertRequest("{\"user\": \"alice\"}\n{\"user\": \n", "application/x-ndjson")
+	r.URL.RawQuery = "strict=true"
+	code, result := doInsert(t, a, r)
+
+	if code != http.StatusBadRequest || result.Accepted != 0 || result.Rejected != 2 || len(sink.rows) != 0 {
+		t.Fatalf("Expected nothing to be inserted; Got %d %+v with %d rows", code, result, len(sink.rows))
+	}
+
+	r = newInsertRequest("{\"user\": \"alice\"}\n{\"user\": \"bob\"}\n", "application/x-ndjson")
+	r.URL.RawQuery = "strict=true"
+	code, result = doInsert(t, a, r)
+
+	if code != http.StatusOK || result.Accepted != 2 || len(sink.rows) != 2 {
+		t.Fatalf("Expected both lines to be inserted; Got %d %+v with %d rows", code, result, len(sink.rows))
+	}
+
+	// Lines are spooled in the data directory, and removed afterwards
+	if spooled, err := os.ReadDir(a.config.DataDirectory); err != nil || len(spooled) != 0 {
+		t.Fatalf("Expected an empty spool directory; Got %v %v", spooled, err)
+	}
+
+	// The sink failing part way through is an error, which reports the lines
+	// which were written
+	r = newInsertRequest("{\"user\": \"carol\"}\n{\"fail\": true}\n{\"user\": \"dave\"}\n", "application/x-ndjson")
+	r.URL.RawQuery = "strict=true"
+	code, result = doInsert(t, a, r)
+
+	if code != http.StatusInternalServerError || result.Accepted != 1 || result.Rejected != 2 || rejectedRows(result) != "1:write_error" || result.Error == "" {
+		t.Fatalf("Expected 1 line to be inserted before the sink failed; Got %d %+v", code, result)
+	}
+}
+
+func TestReadNDJSONLine(t *testing.T) {
+	reader := bufio.NewReaderSize(strings.NewReader("short\n"+strings.Repeat("x", 100)+"\nlast"), 16)
+
+	var lines []string
+	var buf []byte
+	for {
+		line, err := readNDJSONLine(reader, buf, 20)
+		buf = line
+		if err == errLineTooLong {
+			lines = append(lines, "<too long>")
+			continue
+		}
+		if err != nil {
+			break
+		}
+		lines = append(lines, string(line))
+	}
+
+	expected := "short,<too long>,last"
+	if strings.Join(lines, ",") != expected {
+		t.Fatalf("Expected %s; Got %s", expected, strings.Join(lines, ","))
+	}
+}
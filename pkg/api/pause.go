@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	"gorm.io/gorm"
+)
+
+type Pause struct {
+	DestinationID uint      `json:"destination_id"`
+	Table         string    `json:"table,omitempty"`
+	PausedAt      time.Time `json:"paused_at"`
+}
+
+func pauseToResponse(p models.Pause) Pause {
+	return Pause{
+		DestinationID: p.DestinationID,
+		Table:         p.DestinationTable,
+		PausedAt:      p.CreatedAt,
+	}
+}
+
+// pauseTarget returns the destination and (optional) table from the URL, making
+// sure the destination belongs to the caller's team
+func (a *ScratchDataAPIStruct) pauseTarget(w http.ResponseWriter, r *http.Request) (uint, string, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid destination id", http.StatusBadRequest)
+		return 0, "", false
+	}
+
+	teamId := a.AuthGetTeamID(r.Context())
+	_, err = a.storageServices.Database.GetDestination(r.Context(), teamId, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "destination not found", http.StatusNotFound)
+		return 0, "", false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, "", false
+	}
+
+	return uint(id), chi.URLParam(r, "table"), true
+}
+
+// PauseIngestion stops workers from delivering data to a destination or table.
+// Incoming data keeps being queued until ingestion is resumed.
+func (a *ScratchDataAPIStruct) PauseIngestion(w http.ResponseWriter, r *http.Request) {
+	destId, table, ok := a.pauseTarget(w, r)
+	if !ok {
+		return
+	}
+
+	pause, err := a.storageServices.Database.PauseIngestion(r.Context(), destId, table)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, pauseToResponse(pause))
+}
+
+func (a *ScratchDataAPIStruct) ResumeIngestion(w http.ResponseWriter, r *http.Request) {
+	destId, table, ok := a.pauseTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.storageServices.Database.ResumeIngestion(r.Context(), destId, table)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, render.M{"destination_id": destId, "table": table, "paused": false})
+}
+
+func (a *ScratchDataAPIStruct) GetPauses(w http.ResponseWriter, r *http.Request) {
+	teamId := a.AuthGetTeamID(r.Context())
+	pauses, err := a.storageServices.Database.GetPauses(r.Context(), teamId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rc := make([]Pause, len(pauses))
+	for i, p := range pauses {
+		rc[i] = pauseToResponse(p)
+	}
+
+	render.JSON(w, r, rc)
+}
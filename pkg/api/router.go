@@ -36,6 +36,8 @@ func CreateMux(
 	r := chi.NewRouter()
 	r.Use(PrometheusMiddleware)
 	r.Get("/healthcheck", apiFunctions.Healthcheck)
+	r.Get("/healthz", apiFunctions.Healthz)
+	r.Get("/readyz", apiFunctions.Readyz)
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/dashboard/", http.StatusMovedPermanently)
 	})
@@ -44,16 +46,32 @@ func CreateMux(
 
 	api := chi.NewRouter()
 	api.Use(apiFunctions.AuthMiddleware)
-	api.Post("/data/insert/{table}", apiFunctions.Insert)
+	api.With(apiFunctions.Idempotent, apiFunctions.Decompress).Post("/data/insert/{table}", apiFunctions.Insert)
+	api.With(apiFunctions.Idempotent, apiFunctions.Decompress).Post("/data/upload/{table}", apiFunctions.Upload)
 	api.Get("/data/query", apiFunctions.Select)
-	api.Post("/data/query", apiFunctions.Select)
+	api.With(apiFunctions.Decompress).Post("/data/query", apiFunctions.Select)
 	api.Post("/data/copy", apiFunctions.Copy)
+	api.Get("/jobs", apiFunctions.GetJobs)
+	api.Get("/jobs/{id}", apiFunctions.GetJob)
+	api.Get("/jobs/{id}/chunks", apiFunctions.GetJobChunks)
+	api.Post("/jobs/{id}/requeue", apiFunctions.RequeueJob)
+	api.Get("/schedules", apiFunctions.GetSchedules)
+	api.Post("/schedules", apiFunctions.CreateSchedule)
+	api.Get("/schedules/{id}", apiFunctions.GetSchedule)
+	api.Put("/schedules/{id}", apiFunctions.UpdateSchedule)
+	api.Delete("/schedules/{id}", apiFunctions.DeleteSchedule)
+	api.Get("/schedules/{id}/runs", apiFunctions.GetScheduleRuns)
 	api.Get("/tables", apiFunctions.Tables)
 	api.Get("/tables/{table}/columns", apiFunctions.Columns)
 
 	api.Get("/destinations", apiFunctions.GetDestinations)
 	api.Post("/destinations", apiFunctions.CreateDestination)
 	api.Post("/destinations/{id}/keys", apiFunctions.AddAPIKey)
+	api.Get("/pauses", apiFunctions.GetPauses)
+	api.Post("/destinations/{id}/pause", apiFunctions.PauseIngestion)
+	api.Post("/destinations/{id}/resume", apiFunctions.ResumeIngestion)
+	api.Post("/destinations/{id}/tables/{table}/pause", apiFunctions.PauseIngestion)
+	api.Post("/destinations/{id}/tables/{table}/resume", apiFunctions.ResumeIngestion)
 	api.Post("/data/query/share", apiFunctions.CreateQuery)
 
 	r.Mount("/api", api)
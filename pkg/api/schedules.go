@@ -0,0 +1,305 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
+	"gorm.io/gorm"
+)
+
+// ScheduleRequest is the body used to create or update a schedule
+type ScheduleRequest struct {
+	Cron              string `json:"cron"`
+	Query             string `json:"query"`
+	DestinationID     uint   `json:"destination_id"`
+	DestinationTable  string `json:"destination_table"`
+	IncrementalColumn string `json:"incremental_column"`
+	Mode              string `json:"mode"`
+	KeyColumn         string `json:"key_column"`
+	Enabled           *bool  `json:"enabled"`
+}
+
+type Schedule struct {
+	ID                uint       `json:"schedule_id"`
+	Cron              string     `json:"cron"`
+	Query             string     `json:"query"`
+	SourceID          uint       `json:"source_id"`
+	DestinationID     uint       `json:"destination_id"`
+	DestinationTable  string     `json:"destination_table"`
+	IncrementalColumn string     `json:"incremental_column,omitempty"`
+	Mode              string     `json:"mode,omitempty"`
+	KeyColumn         string     `json:"key_column,omitempty"`
+	Enabled           bool       `json:"enabled"`
+	NextRunAt         *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt         *time.Time `json:"last_run_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+type ScheduleRun struct {
+	ID           uint      `json:"run_id"`
+	JobID        uint      `json:"job_id"`
+	Status       string    `json:"status"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func scheduleToResponse(s models.Schedule) Schedule {
+	rc := Schedule{
+		ID:                s.ID,
+		Cron:              s.Cron,
+		Query:             s.Query,
+		SourceID:          s.SourceID,
+		DestinationID:     s.DestinationID,
+		DestinationTable:  s.DestinationTable,
+		IncrementalColumn: s.IncrementalColumn,
+		Mode:              s.Mode,
+		KeyColumn:         s.KeyColumn,
+		Enabled:           s.Enabled,
+		LastRunAt:         optionalTime(s.LastRunAt),
+		CreatedAt:         s.CreatedAt,
+		UpdatedAt:         s.UpdatedAt,
+	}
+	if s.Enabled {
+		rc.NextRunAt = optionalTime(s.NextRunAt)
+	}
+	return rc
+}
+
+func scheduleRunToResponse(r models.ScheduleRun) ScheduleRun {
+	return ScheduleRun{
+		ID:           r.ID,
+		JobID:        r.MessageID,
+		Status:       strings.ToLower(string(r.JobStatus)),
+		ScheduledFor: r.ScheduledFor,
+		CreatedAt:    r.CreatedAt,
+	}
+}
+
+// applyScheduleRequest validates a request and copies it onto the schedule
+func (a *ScratchDataAPIStruct) applyScheduleRequest(r *http.Request, req ScheduleRequest, schedule *models.Schedule) error {
+	if strings.TrimSpace(req.Query) == "" {
+		return errors.New("query cannot be blank")
+	}
+	if req.DestinationTable == "" {
+		return errors.New("destination_table cannot be blank")
+	}
+
+	message := queue_models.CopyDataMessage{
+		IncrementalColumn: req.IncrementalColumn,
+		Mode:              queue_models.CopyMode(req.Mode),
+		KeyColumn:         req.KeyColumn,
+	}
+	if err := message.Validate(); err != nil {
+		return err
+	}
+
+	nextRunAt, err := util.NextCronTime(req.Cron, time.Now())
+	if err != nil {
+		return errors.New("invalid cron expression: " + err.Error())
+	}
+
+	// Make sure the destination db is the same team as the source
+	_, err = a.storageServices.Database.GetDestination(r.Context(), schedule.TeamID, req.DestinationID)
+	if err != nil {
+		return errors.New("invalid destination")
+	}
+
+	schedule.Cron = req.Cron
+	schedule.Query = req.Query
+	schedule.DestinationID = req.DestinationID
+	schedule.DestinationTable = req.DestinationTable
+	schedule.IncrementalColumn = req.IncrementalColumn
+	schedule.Mode = req.Mode
+	schedule.KeyColumn = req.KeyColumn
+	schedule.NextRunAt = nextRunAt
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+
+	return nil
+}
+
+func scheduleID(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid schedule id")
+	}
+	return uint(id), nil
+}
+
+func (a *ScratchDataAPIStruct) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	req := ScheduleRequest{}
+	err := render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule := models.Schedule{
+		TeamID:   a.AuthGetTeamID(r.Context()),
+		SourceID: uint(a.AuthGetDatabaseID(r.Context())),
+		Enabled:  true,
+	}
+
+	err = a.applyScheduleRequest(r, req, &schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.storageServices.Database.CreateSchedule(r.Context(), &schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, scheduleToResponse(schedule))
+}
+
+func (a *ScratchDataAPIStruct) GetSchedules(w http.ResponseWriter, r *http.Request) {
+	teamId := a.AuthGetTeamID(r.Context())
+	schedules, err := a.storageServices.Database.GetSchedules(r.Context(), teamId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rc := make([]Schedule, len(schedules))
+	for i, s := range schedules {
+		rc[i] = scheduleToResponse(s)
+	}
+
+	render.JSON(w, r, rc)
+}
+
+func (a *ScratchDataAPIStruct) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	id, err := scheduleID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	teamId := a.AuthGetTeamID(r.Context())
+	schedule, err := a.storageServices.Database.GetSchedule(r.Context(), teamId, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, scheduleToResponse(schedule))
+}
+
+// UpdateSchedule changes the fields present in the request body. The next run
+// is recalculated from the current time.
+func (a *ScratchDataAPIStruct) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	id, err := scheduleID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	teamId := a.AuthGetTeamID(r.Context())
+	schedule, err := a.storageServices.Database.GetSchedule(r.Context(), teamId, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	req := ScheduleRequest{
+		Cron:              schedule.Cron,
+		Query:             schedule.Query,
+		DestinationID:     schedule.DestinationID,
+		DestinationTable:  schedule.DestinationTable,
+		IncrementalColumn: schedule.IncrementalColumn,
+		Mode:              schedule.Mode,
+		KeyColumn:         schedule.KeyColumn,
+	}
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.applyScheduleRequest(r, req, &schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.storageServices.Database.UpdateSchedule(r.Context(), &schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, scheduleToResponse(schedule))
+}
+
+func (a *ScratchDataAPIStruct) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id, err := scheduleID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	teamId := a.AuthGetTeamID(r.Context())
+	err = a.storageServices.Database.DeleteSchedule(r.Context(), teamId, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, render.M{"schedule_id": id, "deleted": true})
+}
+
+func (a *ScratchDataAPIStruct) GetScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	id, err := scheduleID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	teamId := a.AuthGetTeamID(r.Context())
+	runs, err := a.storageServices.Database.GetScheduleRuns(r.Context(), teamId, id, limit)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rc := make([]ScheduleRun, len(runs))
+	for i, run := range runs {
+		rc[i] = scheduleRunToResponse(run)
+	}
+
+	render.JSON(w, r, rc)
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+)
+
+// countingReader keeps track of how many bytes have been read from a request body
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// rowReader returns the next JSON row of a streaming insert, along with its index
+// in the request body, or io.EOF once there are no more rows. A rejectedRowError
+// is returned for a row which can't be read but doesn't stop the rest of the body
+// from being read.
+type rowReader func() ([]byte, int, error)
+
+type rejectedRowError struct {
+	reason string
+	err    error
+}
+
+func (e rejectedRowError) Error() string {
+	return e.err.Error()
+}
+
+// dataDirectory returns api.data_directory, where request bodies are spooled,
+// creating it if needed. It's empty, meaning the system temp directory, if it
+// isn't set.
+func (a *ScratchDataAPIStruct) dataDirectory() (string, error) {
+	dir := a.config.DataDirectory
+	if dir == "" {
+		return "", nil
+	}
+	return dir, os.MkdirAll(dir, os.ModePerm)
+}
+
+// insertStream writes each row to the data sink as it's read, so that the size of
+// a request isn't limited by memory. Rows which can't be inserted are reported and
+// skipped.
+//
+// When strict, flattened rows are spooled to a temporary file instead, and only
+// written once every row has been read successfully.
+func (a *ScratchDataAPIStruct) insertStream(w http.ResponseWriter, r *http.Request, body *countingReader, next rowReader, databaseID int64, table string, flattener Flattener, system []systemColumn, strict bool) {
+	var spool *os.File
+	var encoder *json.Encoder
+	if strict {
+		dir, err := a.dataDirectory()
+		if err == nil {
+			spool, err = os.CreateTemp(dir, "insert-*.ndjson")
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to create insert spool file")
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, InsertResult{Error: "Unable to insert data"})
+			return
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		encoder = json.NewEncoder(spool)
+	}
+
+	dedup := a.newRowDeduper(r, databaseID, table)
+
+	result := InsertResult{}
+
+	var readErr error
+	for {
+		line, index, err := next()
+		if err == io.EOF {
+			break
+		}
+
+		var rejected rejectedRowError
+		if errors.As(err, &rejected) {
+			result.reject(index, rejected.reason, rejected.err)
+			continue
+		}
+
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		if !strict {
+			a.insertUnlessDuplicate(&result, dedup, index, databaseID, table, flattener, system, string(line))
+			continue
+		}
+
+		key := dedup.key(string(line))
+		if dedup.seen(key) {
+			result.Duplicates++
+			continue
+		}
+
+		rows, err := a.flattenRow(table, flattener, system, string(line))
+		if err != nil {
+			result.reject(index, rejectFlatten, err)
+			continue
+		}
+
+		for _, row := range rows {
+			err := encoder.Encode(spooledRow{Line: result.Accepted, Index: index, Table: row.Table, JSON: row.JSON, Key: key})
+			if err != nil {
+				log.Error().Err(err).Msg("Unable to write to insert spool file")
+				render.Status(r, http.StatusInternalServerError)
+				render.JSON(w, r, InsertResult{Error: "Unable to insert data"})
+				return
+			}
+		}
+		result.Accepted++
+	}
+
+	observeInsertSize(r, body.n)
+	insertArraySize.Observe(float64(result.Accepted + result.Rejected))
+
+	if strict {
+		if readErr == nil && result.Rejected == 0 {
+			written, index, err := a.writeSpool(&result, databaseID, spool, dedup)
+			if err != nil {
+				result.reject(index, rejectWrite, err)
+				result.Rejected += result.Accepted - written - 1
+				result.Accepted = written
+				result.strictFailed()
+			}
+		} else {
+			// Nothing is written unless every row can be
+			result.Rejected += result.Accepted
+			result.Accepted = 0
+		}
+	}
+
+	a.flushSink(databaseID, &result)
+
+	// Rejected rows need to be sent again, so a retry can't get this response
+	if result.Rejected > 0 {
+		dontRemember(r)
+	}
+
+	if errors.Is(readErr, errBodyTooLarge) {
+		result.Error = readErr.Error()
+		render.Status(r, http.StatusRequestEntityTooLarge)
+	} else if readErr != nil {
+		log.Error().Err(readErr).Int64("database_id", databaseID).Str("table", table).Msg("Unable to read insert body")
+		result.Error = readErr.Error()
+		render.Status(r, http.StatusBadRequest)
+	} else {
+		setRetryAfter(w, result)
+		render.Status(r, result.status())
+	}
+
+	render.JSON(w, r, result)
+}
+
+// spooledRow is a flattened row saved by a strict insert. Line is the number of
+// rows spooled before this one, and Index is the row's position in the request body.
+// Key is the row's dedup key, if it has one.
+type spooledRow struct {
+	Line  int    `json:"line"`
+	Index int    `json:"index"`
+	Table string `json:"table"`
+	JSON  string `json:"json"`
+	Key   string `json:"key,omitempty"`
+}
+
+// writeSpool writes the rows saved by a strict insert to the data sink. If the sink
+// fails, it returns how many rows were written in full and the index of the row
+// which failed.
+func (a *ScratchDataAPIStruct) writeSpool(result *InsertResult, databaseID int64, spool *os.File, dedup *rowDeduper) (int, int, error) {
+	_, err := spool.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var row spooledRow
+	decoder := json.NewDecoder(bufio.NewReader(spool))
+	for {
+		// Decode into a new row, as fields missing from the JSON aren't reset
+		var next spooledRow
+		err := decoder.Decode(&next)
+		if err == io.EOF {
+			return 0, 0, nil
+		}
+		if err != nil {
+			return row.Line, row.Index, err
+		}
+		row = next
+
+		result.wrote(row.Table)
+		err = a.dataSink.WriteData(databaseID, row.Table, []byte(row.JSON))
+		if err != nil {
+			return row.Line, row.Index, err
+		}
+		dedup.remember(row.Key)
+	}
+}
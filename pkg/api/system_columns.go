@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/destinations"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
+	"github.com/tidwall/sjson"
+)
+
+// System columns which can be added to every inserted row, named without the
+// leading __
+var systemColumnNames = []string{"ingested_at", "api_key_id", "source_ip", "batch_id"}
+
+// systemColumn is a column added to every row of an insert. It replaces any
+// value sent with the row, so that clients can't forge where a row came from.
+type systemColumn struct {
+	name       string
+	value      any
+	columnType string
+}
+
+// newSystemColumn returns a system column with the type from
+// util.SystemColumnTypes, which is also used when the column is read back from
+// a JSON insert
+func newSystemColumn(name string, value any) systemColumn {
+	return systemColumn{name: name, value: value, columnType: util.SystemColumnTypes[name]}
+}
+
+// systemColumns returns the columns to add to the rows of an insert. They are
+// enabled with the system_columns setting of the destination, and with the
+// system_columns query parameter. Both are a list of names from systemColumnNames,
+// or "all".
+func (a *ScratchDataAPIStruct) systemColumns(r *http.Request, databaseID int64) ([]systemColumn, error) {
+	enabled := a.destinationOptions(r.Context(), databaseID).SystemColumns
+	if param := r.URL.Query().Get("system_columns"); param != "" {
+		enabled = append(enabled, strings.Split(param, ",")...)
+	}
+
+	names := map[string]bool{}
+	for _, name := range enabled {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "__")
+		if name == "all" {
+			for _, name := range systemColumnNames {
+				names[name] = true
+			}
+		} else if slices.Contains(systemColumnNames, name) {
+			names[name] = true
+		} else if name != "" {
+			return nil, fmt.Errorf("Unknown system column %q, must be one of %s or all", name, strings.Join(systemColumnNames, ", "))
+		}
+	}
+
+	var columns []systemColumn
+	if names["ingested_at"] {
+		columns = append(columns, newSystemColumn("__ingested_at", time.Now().UTC().Format(time.RFC3339Nano)))
+	}
+	if names["api_key_id"] {
+		// Admin keys aren't stored, so have no ID
+		if key, ok := r.Context().Value("apiKeyDetails").(models.APIKey); ok {
+			columns = append(columns, newSystemColumn("__api_key_id", key.ID))
+		}
+	}
+	if names["source_ip"] {
+		columns = append(columns, newSystemColumn("__source_ip", a.clientIP(r)))
+	}
+	if names["batch_id"] {
+		columns = append(columns, newSystemColumn("__batch_id", uuid.New().String()))
+	}
+
+	return columns, nil
+}
+
+// destinationOptions returns the settings of a destination which apply to every
+// destination type. For a destination's own API key, they come with the key
+// details so that the database isn't queried on every insert.
+func (a *ScratchDataAPIStruct) destinationOptions(ctx context.Context, databaseID int64) destinations.Options {
+	if key, ok := ctx.Value("apiKeyDetails").(models.APIKey); ok {
+		return *util.ConfigToStruct[destinations.Options](key.Destination.Settings.Data())
+	}
+
+	if a.destinationManager == nil {
+		return destinations.Options{}
+	}
+
+	options, err := a.destinationManager.Options(ctx, databaseID)
+	if err != nil {
+		log.Error().Err(err).Int64("database_id", databaseID).Msg("Unable to get destination options")
+	}
+	return options
+}
+
+// clientIP returns the address of the client which sent a request. Behind a
+// proxy listed in api.trusted_proxies, it's the last address in X-Forwarded-For
+// which wasn't added by a trusted proxy.
+func (a *ScratchDataAPIStruct) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !a.trustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+
+		ip = hop
+		if !a.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (a *ScratchDataAPIStruct) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range a.trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses api.trusted_proxies, a list of addresses and CIDR
+// ranges
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	var rc []netip.Prefix
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid trusted proxy %q: %w", proxy, err)
+			}
+			rc = append(rc, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy %q: %w", proxy, err)
+		}
+		rc = append(rc, prefix.Masked())
+	}
+	return rc, nil
+}
+
+// addSystemColumns sets each system column on a flattened row, replacing any
+// value the row already has
+func addSystemColumns(row string, columns []systemColumn) (string, error) {
+	var err error
+	for _, column := range columns {
+		row, err = sjson.Set(row, column.name, column.value)
+		if err != nil {
+			return "", err
+		}
+	}
+	return row, nil
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	"github.com/tidwall/gjson"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+func TestSystemColumns(t *testing.T) {
+	a, sink := newTestInsertAPI()
+
+	r := newInsertRequest(`[{"user": "alice"}, {"user": "bob", "__source_ip": "10.0.0.1"}]`, "application/json")
+	r.URL.RawQuery = "system_columns=all"
+	r.RemoteAddr = "192.0.2.1:54321"
+	r = r.WithContext(context.WithValue(r.Context(), "apiKeyDetails", models.APIKey{Model: gorm.Model{ID: 7}}))
+
+	code, result := doInsert(t, a, r)
+	if code != http.StatusOK || result.Accepted != 2 {
+		t.Fatalf("Expected rows to be inserted; Got %d %+v", code, result)
+	}
+
+	first := gjson.Parse(sink.rows[0])
+	if first.Get("__api_key_id").Int() != 7 || first.Get("__source_ip").String() != "192.0.2.1" {
+		t.Fatalf("Expected the API key and source IP; Got %s", sink.rows[0])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, first.Get("__ingested_at").String()); err != nil {
+		t.Fatalf("Expected an ingestion time; Got %s", sink.rows[0])
+	}
+
+	second := gjson.Parse(sink.rows[1])
+	if second.Get("__batch_id").String() == "" || second.Get("__batch_id").String() != first.Get("__batch_id").String() {
+		t.Fatalf("Expected rows in a request to share a batch ID; Got %s and %s", sink.rows[0], sink.rows[1])
+	}
+	if second.Get("__source_ip").String() != "192.0.2.1" {
+		t.Fatalf("Expected a value sent with the row to be replaced; Got %s", sink.rows[1])
+	}
+}
+
+func TestSystemColumnsFromDestination(t *testing.T) {
+	a, sink := newTestInsertAPI()
+
+	key := models.APIKey{Destination: models.Destination{
+		Settings: datatypes.NewJSONType(map[string]any{"system_columns": []any{"ingested_at"}}),
+	}}
+
+	r := newInsertRequest("{\"user\": \"alice\"}\n", "application/x-ndjson")
+	r.URL.RawQuery = "system_columns=__batch_id"
+	r = r.WithContext(context.WithValue(r.Context(), "apiKeyDetails", key))
+
+	doInsert(t, a, r)
+	row := gjson.Parse(sink.rows[0])
+	if !row.Get("__ingested_at").Exists() || !row.Get("__batch_id").Exists() || row.Get("__source_ip").Exists() {
+		t.Fatalf("Expected columns enabled by the destination and the request; Got %s", sink.rows[0])
+	}
+
+	r = newInsertRequest(`[{"user": "bob"}]`, "application/json")
+	r.URL.RawQuery = "system_columns=hostname"
+	if code, _ := doInsert(t, a, r); code != http.StatusBadRequest || len(sink.rows) != 1 {
+		t.Fatalf("Expected an unknown system column to be rejected; Got %d", code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	a, _ := newTestInsertAPI()
+
+	var err error
+	a.trustedProxies, err = parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"198.51.100.7:1234", nil, "198.51.100.7"},
+		{"198.51.100.7:1234", []string{"203.0.113.9"}, "198.51.100.7"},
+		{"10.1.2.3:1234", nil, "10.1.2.3"},
+		{"10.1.2.3:1234", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"10.1.2.3:1234", []string{"1.1.1.1, 203.0.113.9, 192.0.2.1"}, "203.0.113.9"},
+		{"10.1.2.3:1234", []string{"1.1.1.1", "203.0.113.9"}, "203.0.113.9"},
+		{"10.1.2.3:1234", []string{"10.0.0.5"}, "10.0.0.5"},
+		{"10.1.2.3:1234", []string{"1.1.1.1, junk"}, "10.1.2.3"},
+	}
+
+	for _, test := range tests {
+		r := newInsertRequest("{}", "application/json")
+		r.RemoteAddr = test.remoteAddr
+		for _, header := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+
+		if ip := a.clientIP(r); ip != test.expected {
+			t.Fatalf("Expected %s from %s %v; Got %s", test.expected, test.remoteAddr, test.forwarded, ip)
+		}
+	}
+
+	if _, err := parseTrustedProxies([]string{"not an ip"}); err == nil {
+		t.Fatalf("Expected an invalid proxy to be rejected")
+	}
+}
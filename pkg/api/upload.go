@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet/file"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
+)
+
+// Uploaded files are converted to NDJSON and queued in chunks of about this size
+const uploadChunkBytes = 100 * 1000 * 1000
+
+// Rows read from a Parquet file at a time
+const parquetBatchSize = 64 * 1024
+
+const (
+	formatParquet = "parquet"
+	formatArrow   = "arrow"
+)
+
+// uploadFormat returns the format of an upload from the format query parameter,
+// or else from the Content-Type
+func uploadFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+
+	switch requestMediaType(r) {
+	case "application/vnd.apache.parquet", "application/x-parquet":
+		return formatParquet
+	case "application/vnd.apache.arrow.stream":
+		return formatArrow
+	}
+	return ""
+}
+
+// parquetRecords reads a Parquet file. The footer is at the end of the file, so
+// the body is first written to spool.
+func parquetRecords(body io.Reader, spool *os.File) (array.RecordReader, error) {
+	_, err := io.Copy(spool, body)
+	if err != nil {
+		return nil, err
+	}
+
+	pf, err := file.NewParquetReader(spool)
+	if err != nil {
+		return nil, err
+	}
+
+	props := pqarrow.ArrowReadProperties{BatchSize: parquetBatchSize}
+	fr, err := pqarrow.NewFileReader(pf, props, memory.DefaultAllocator)
+	if err != nil {
+		return nil, err
+	}
+
+	return fr.GetRecordReader(context.Background(), nil, nil)
+}
+
+// uploadError responds with an error in the same JSON form as inserts
+func uploadError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	render.Status(r, status)
+	render.JSON(w, r, render.M{"error": message})
+}
+
+// Upload inserts a Parquet file or an Arrow IPC stream. Column types are taken
+// from the file's schema rather than inferred from the data.
+func (a *ScratchDataAPIStruct) Upload(w http.ResponseWriter, r *http.Request) {
+	databaseID := a.AuthGetDatabaseID(r.Context())
+	table := chi.URLParam(r, "table")
+
+	system, err := a.systemColumns(r, databaseID)
+	if err != nil {
+		uploadError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dir, err := a.dataDirectory()
+	if err != nil {
+		log.Error().Err(err).Msg("Upload: unable to create data directory")
+		uploadError(w, r, http.StatusInternalServerError, "Unable to read data")
+		return
+	}
+
+	folder, err := os.MkdirTemp(dir, "upload-*")
+	if err != nil {
+		log.Error().Err(err).Msg("Upload: unable to create temp folder")
+		uploadError(w, r, http.StatusInternalServerError, "Unable to read data")
+		return
+	}
+	defer os.RemoveAll(folder)
+
+	body := &countingReader{r: r.Body}
+
+	var reader array.RecordReader
+	switch uploadFormat(r) {
+	case formatParquet:
+		var spool *os.File
+		spool, err = os.Create(filepath.Join(folder, "upload.parquet"))
+		if err != nil {
+			break
+		}
+		defer spool.Close()
+		reader, err = parquetRecords(body, spool)
+	case formatArrow:
+		reader, err = ipc.NewReader(body)
+	default:
+		uploadError(w, r, http.StatusUnsupportedMediaType, "format must be parquet or arrow")
+		return
+	}
+
+	if errors.Is(err, errBodyTooLarge) {
+		uploadError(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	} else if err != nil {
+		uploadError(w, r, http.StatusBadRequest, fmt.Sprintf("Unable to read file: %s", err))
+		return
+	}
+	defer reader.Release()
+
+	columns := util.ArrowColumnTypes(reader.Schema())
+	if _, ok := columns["__row_id"]; !ok {
+		columns["__row_id"] = "int"
+	}
+
+	extra := make([]util.JSONField, len(system))
+	for i, column := range system {
+		extra[i] = util.JSONField{Name: column.name, Value: column.value}
+		columns[column.name] = column.columnType
+	}
+
+	rowID := func() int64 { return a.snow.Generate().Int64() }
+
+	chunks := filepath.Join(folder, "chunks")
+	err = os.Mkdir(chunks, 0700)
+	if err != nil {
+		log.Error().Err(err).Msg("Upload: unable to create temp folder")
+		uploadError(w, r, http.StatusInternalServerError, "Unable to read data")
+		return
+	}
+
+	writer := util.NewChunkedWriter(math.MaxInt, uploadChunkBytes, chunks)
+	for reader.Next() {
+		err = util.WriteArrowNDJSON(reader.Record(), writer, rowID, extra...)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && reader.Err() != nil && !errors.Is(reader.Err(), io.EOF) {
+		err = reader.Err()
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	observeInsertSize(r, body.n)
+
+	if errors.Is(err, errBodyTooLarge) {
+		uploadError(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	} else if err != nil {
+		uploadError(w, r, http.StatusBadRequest, fmt.Sprintf("Unable to read file: %s", err))
+		return
+	}
+
+	files, err := os.ReadDir(chunks)
+	if err != nil {
+		uploadError(w, r, http.StatusInternalServerError, "Unable to read data")
+		return
+	}
+
+	jobIDs := []uint{}
+	for _, f := range files {
+		jobID, err := a.queueUpload(databaseID, table, filepath.Join(chunks, f.Name()), columns)
+		if err != nil {
+			log.Error().Err(err).Int64("database_id", databaseID).Str("table", table).Msg("Upload: unable to queue chunk")
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, render.M{"error": "Unable to queue data", "job_ids": jobIDs})
+			return
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+
+	render.JSON(w, r, render.M{"rows": writer.Rows(), "job_ids": jobIDs})
+}
+
+// queueUpload uploads a chunk of NDJSON to the blob store and queues it to be
+// inserted with the given column types
+func (a *ScratchDataAPIStruct) queueUpload(databaseID int64, table string, path string, columns map[string]string) (uint, error) {
+	chunk, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer chunk.Close()
+
+	key := fmt.Sprintf("%d/%s/%d.ndjson", databaseID, table, a.snow.Generate().Int64())
+	err = a.storageServices.BlobStore.Upload(key, chunk)
+	if err != nil {
+		return 0, err
+	}
+
+	message := queue_models.FileUploadMessage{
+		DatabaseID: databaseID,
+		Table:      table,
+		Key:        key,
+		Columns:    columns,
+	}
+
+	msg, err := a.storageServices.Database.Enqueue(models.InsertData, message, uint(databaseID), table)
+	if err != nil {
+		return 0, err
+	}
+	return msg.ID, nil
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/decimal128"
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"github.com/go-chi/chi/v5"
+	"github.com/scratchdata/scratchdata/pkg/config"
+	"github.com/scratchdata/scratchdata/pkg/storage"
+	blob_memory "github.com/scratchdata/scratchdata/pkg/storage/blobstore/memory"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/gorm"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
+	"github.com/tidwall/gjson"
+)
+
+func newUploadRequest(body []byte, contentType string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/data/upload/events", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("table", "events")
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+	ctx = context.WithValue(ctx, "databaseId", uint(1))
+
+	return r.WithContext(ctx)
+}
+
+func newTestUploadAPI(t *testing.T) (*ScratchDataAPIStruct, *gorm.Gorm, *blob_memory.Storage) {
+	db, err := gorm.NewGorm(config.Database{
+		Type:     "sqlite",
+		Settings: map[string]any{"dsn": filepath.Join(t.TempDir(), "upload.db")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ := blob_memory.NewStorage(nil)
+	snow, _ := util.NewSnowflakeGenerator()
+
+	a := &ScratchDataAPIStruct{
+		storageServices: &storage.Services{Database: db, BlobStore: blobs},
+		snow:            snow,
+	}
+	return a, db, blobs
+}
+
+func newUploadRecord() arrow.Record {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "user", Type: arrow.BinaryTypes.String},
+		{Name: "at", Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}},
+		{Name: "price", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}},
+	}, nil)
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	builder.Field(0).(*array.StringBuilder).AppendValues([]string{"alice", "bob"}, nil)
+	builder.Field(1).(*array.TimestampBuilder).AppendValues([]arrow.Timestamp{arrow.Timestamp(at.UnixMicro()), arrow.Timestamp(at.UnixMicro())}, nil)
+	builder.Field(2).(*array.Decimal128Builder).AppendValues([]decimal128.Num{decimal128.FromI64(1999), decimal128.FromI64(5)}, nil)
+
+	return builder.NewRecord()
+}
+
+func doUpload(t *testing.T, a *ScratchDataAPIStruct, r *http.Request) (int, []uint) {
+	w := httptest.NewRecorder()
+	a.Upload(w, r)
+
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+
+	var result struct {
+		Rows   int    `json:"rows"`
+		JobIDs []uint `json:"job_ids"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Unable to parse response %q: %s", w.Body.String(), err)
+	}
+	if result.Rows != 2 {
+		t.Fatalf("Expected 2 rows; Got %d", result.Rows)
+	}
+	return w.Code, result.JobIDs
+}
+
+func checkUploadJob(t *testing.T, db *gorm.Gorm, blobs *blob_memory.Storage) (queue_models.FileUploadMessage, []string) {
+	msg, ok := db.Dequeue(models.InsertData, "test", models.DequeueOptions{})
+	if !ok {
+		t.Fatalf("Expected an insert to be queued")
+	}
+
+	var message queue_models.FileUploadMessage
+	if err := json.Unmarshal([]byte(msg.Message), &message); err != nil {
+		t.Fatal(err)
+	}
+
+	if message.Table != "events" || message.Columns["at"] != "timestamp" ||
+		message.Columns["price"] != "decimal(10,2)" || message.Columns["__row_id"] != "int" {
+		t.Fatalf("Expected column types from the schema; Got %+v", message)
+	}
+
+	path := filepath.Join(t.TempDir(), "chunk.ndjson")
+	chunk, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = blobs.Download(message.Key, chunk)
+	chunk.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], `{"user":"alice","at":"2024-01-02T03:04:05Z","price":"19.99","__row_id":`) {
+		t.Fatalf("Expected rows to be converted to NDJSON; Got %s", data)
+	}
+	return message, lines
+}
+
+func TestUploadParquet(t *testing.T) {
+	a, db, blobs := newTestUploadAPI(t)
+	a.config.DataDirectory = filepath.Join(t.TempDir(), "api")
+
+	record := newUploadRecord()
+	defer record.Release()
+
+	table := array.NewTableFromRecords(record.Schema(), []arrow.Record{record})
+	defer table.Release()
+
+	var body bytes.Buffer
+	err := pqarrow.WriteTable(table, &body, 1024, nil, pqarrow.DefaultWriterProps())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, jobs := doUpload(t, a, newUploadRequest(body.Bytes(), "application/vnd.apache.parquet"))
+	if code != http.StatusOK || len(jobs) != 1 {
+		t.Fatalf("Expected one insert job; Got %d %v", code, jobs)
+	}
+	checkUploadJob(t, db, blobs)
+
+	w := httptest.NewRecorder()
+	a.Upload(w, newUploadRequest([]byte("not parquet"), "application/vnd.apache.parquet"))
+	if w.Code != http.StatusBadRequest || !gjson.Get(w.Body.String(), "error").Exists() {
+		t.Fatalf("Expected an invalid file to be rejected with a JSON error; Got %d %s", w.Code, w.Body.String())
+	}
+
+	// Files are spooled in the data directory, and removed afterwards
+	if spooled, err := os.ReadDir(a.config.DataDirectory); err != nil || len(spooled) != 0 {
+		t.Fatalf("Expected an empty spool directory; Got %v %v", spooled, err)
+	}
+}
+
+func TestUploadArrow(t *testing.T) {
+	a, db, blobs := newTestUploadAPI(t)
+
+	record := newUploadRecord()
+	defer record.Release()
+
+	var body bytes.Buffer
+	writer := ipc.NewWriter(&body, ipc.WithSchema(record.Schema()))
+	if err := writer.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	r := newUploadRequest(body.Bytes(), "application/octet-stream")
+	r.URL.RawQuery = "format=arrow"
+
+	code, jobs := doUpload(t, a, r)
+	if code != http.StatusOK || len(jobs) != 1 {
+		t.Fatalf("Expected one insert job; Got %d %v", code, jobs)
+	}
+	checkUploadJob(t, db, blobs)
+
+	code, _ = doUpload(t, a, newUploadRequest(body.Bytes(), "application/json"))
+	if code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Expected an unknown format to be rejected; Got %d", code)
+	}
+}
+
+func TestUploadSystemColumns(t *testing.T) {
+	a, db, blobs := newTestUploadAPI(t)
+
+	record := newUploadRecord()
+	defer record.Release()
+
+	var body bytes.Buffer
+	writer := ipc.NewWriter(&body, ipc.WithSchema(record.Schema()))
+	if err := writer.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	r := newUploadRequest(body.Bytes(), "application/octet-stream")
+	r.URL.RawQuery = "format=arrow&system_columns=ingested_at,source_ip"
+	r.RemoteAddr = "192.0.2.1:54321"
+
+	code, jobs := doUpload(t, a, r)
+	if code != http.StatusOK || len(jobs) != 1 {
+		t.Fatalf("Expected one insert job; Got %d %v", code, jobs)
+	}
+
+	message, lines := checkUploadJob(t, db, blobs)
+	if message.Columns["__ingested_at"] != "timestamp" || message.Columns["__source_ip"] != "string" {
+		t.Fatalf("Expected system column types; Got %+v", message.Columns)
+	}
+	for _, line := range lines {
+		row := gjson.Parse(line)
+		if !row.Get("__ingested_at").Exists() || row.Get("__source_ip").String() != "192.0.2.1" {
+			t.Fatalf("Expected system columns on every row; Got %s", line)
+		}
+	}
+
+	r = newUploadRequest(body.Bytes(), "application/octet-stream")
+	r.URL.RawQuery = "format=arrow&system_columns=hostname"
+	if code, _ := doUpload(t, a, r); code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown system column to be rejected; Got %d", code)
+	}
+}
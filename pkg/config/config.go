@@ -17,6 +17,26 @@ type API struct {
 	Port                int    `yaml:"port"`
 	HealthCheckFailFile string `yaml:"healthcheck_fail_file"`
 	APIKeyCacheTTL      int    `yaml:"api_key_cache_ttl"`
+
+	// Compressed request bodies are rejected once they decompress to more than
+	// this many bytes
+	MaxDecompressedBytes int64 `yaml:"max_decompressed_bytes"`
+
+	// How long Idempotency-Key headers and dedup_field values are remembered
+	IdempotencyWindowSeconds int `yaml:"idempotency_window_seconds"`
+
+	// /readyz fails while more messages than this are waiting in the queue. 0
+	// means no limit.
+	ReadyMaxQueueDepth int64 `yaml:"ready_max_queue_depth"`
+
+	// Addresses and CIDR ranges of load balancers and proxies in front of the
+	// API. The client IP is read from X-Forwarded-For when they send a request.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// Where request bodies are spooled to disk, such as for strict inserts. It
+	// should be on the same disk as the data sink so that its free space checks
+	// cover it. The system temp directory is used if it isn't set.
+	DataDirectory string `yaml:"data_directory"`
 }
 
 type Workers struct {
@@ -27,6 +47,24 @@ type Workers struct {
 
 	MaxBulkQuerySizeBytes int `yaml:"max_bulk_query_size_bytes"`
 	BulkChunkSizeBytes    int `yaml:"bulk_chunk_size_bytes"`
+
+	// Failed messages are retried with exponential backoff until they
+	// have been attempted MaxAttempts times, then moved to the dead letter state
+	MaxAttempts           int `yaml:"max_attempts"`
+	RetryBaseDelaySeconds int `yaml:"retry_base_delay_seconds"`
+	RetryMaxDelaySeconds  int `yaml:"retry_max_delay_seconds"`
+
+	// Claimed messages whose worker hasn't sent a heartbeat within this
+	// many seconds are returned to the queue
+	VisibilityTimeoutSeconds int `yaml:"visibility_timeout_seconds"`
+
+	// Limit on messages in flight at once for destinations which don't set
+	// max_in_flight. 0 means no limit.
+	DefaultMaxInFlight int `yaml:"default_max_in_flight"`
+
+	// Succeeded and failed messages are deleted this many days after they
+	// complete. 0 keeps them forever.
+	MessageRetentionDays int `yaml:"message_retention_days"`
 }
 
 type Queue struct {
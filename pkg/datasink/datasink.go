@@ -3,9 +3,12 @@ package datasink
 import (
 	"context"
 	"errors"
+	"time"
+
 	"github.com/scratchdata/scratchdata/pkg/config"
 	"github.com/scratchdata/scratchdata/pkg/datasink/filesystem"
 	"github.com/scratchdata/scratchdata/pkg/datasink/memory"
+	"github.com/scratchdata/scratchdata/pkg/datasink/models"
 	"github.com/scratchdata/scratchdata/pkg/storage"
 )
 
@@ -14,10 +17,30 @@ type DataSink interface {
 	WriteData(databaseID int64, table string, data []byte) error
 }
 
+// Flusher is implemented by data sinks which can make sure written data is saved.
+// Flush is called with the tables an insert wrote to once its rows are written,
+// before the insert is acknowledged.
+type Flusher interface {
+	Flush(databaseID int64, tables []string) error
+}
+
+// Busy is returned by a data sink which can't accept more data for now, such as
+// when its disk is full. The write should be tried again after RetryAfter.
+type Busy interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// HealthReporter is implemented by data sinks which can report their health. An
+// error is returned along with it if the sink can't accept data.
+type HealthReporter interface {
+	Health() (models.Health, error)
+}
+
 func NewDataSink(conf config.DataSink, storage *storage.Services) (DataSink, error) {
 	switch conf.Type {
 	case "memory":
-		return memory.NewMemoryDataSink(storage)
+		return memory.NewMemoryDataSink(conf.Settings, storage)
 	case "filesystem":
 		return filesystem.NewFilesystemDataSink(conf.Settings, storage)
 	}
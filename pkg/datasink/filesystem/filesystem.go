@@ -4,18 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	sink_models "github.com/scratchdata/scratchdata/pkg/datasink/models"
 	"github.com/scratchdata/scratchdata/pkg/storage"
 	"github.com/scratchdata/scratchdata/pkg/util"
 
-	"github.com/EagleChen/mapmutex"
 	"github.com/bwmarrin/snowflake"
 	"github.com/rs/zerolog/log"
 	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
@@ -31,17 +33,43 @@ type DataSink struct {
 	MaxRows           int64  `mapstructure:"max_rows"`
 	MaxFileAgeSeconds int    `mapstructure:"max_age_seconds"`
 
+	// Sync files to disk when Flush is called, so that inserts which have been
+	// acknowledged survive a crash
+	Fsync bool `mapstructure:"fsync"`
+
+	// Staging format files are uploaded in, such as gzip or parquet
+	Format string `mapstructure:"format"`
+
+	// How long a write waits for another write to the same table. 0 uses
+	// defaultLockTimeout.
+	LockTimeoutMs int `mapstructure:"lock_timeout_ms"`
+
+	// Writes are rejected as busy while there is less free disk space than this,
+	// or more data waiting to be uploaded. 0 means no limit.
+	MinFreeDiskBytes uint64 `mapstructure:"min_free_disk_bytes"`
+	MaxBacklogBytes  int64  `mapstructure:"max_backlog_bytes"`
+
 	storage *storage.Services
 	snow    *snowflake.Node
 	enabled bool
 	wg      sync.WaitGroup
 
-	fileMutex *mapmutex.Mutex
+	fileLocks *fileLocks
+	filesLock sync.Mutex
 	files     map[string]*FileDetails
 
+	// Bytes in closed files which haven't been uploaded
+	backlogBytes atomic.Int64
+
 	uploadMutex *sync.Mutex
 }
 
+const defaultLockTimeout = 5 * time.Second
+
+// How long a client should wait before retrying a write which was rejected
+// because the disk or upload backlog is full. Uploads are attempted this often.
+const backlogRetryAfter = 10 * time.Second
+
 type FileDetails struct {
 	fd        *os.File
 	path      string
@@ -49,6 +77,9 @@ type FileDetails struct {
 	byteCount int64
 	created   time.Time
 
+	// Whether data has been written since the file was last synced
+	unsynced bool
+
 	databaseId int64
 	table      string
 }
@@ -75,9 +106,9 @@ func (m *DataSink) Start(ctx context.Context) error {
 }
 
 func (m *DataSink) RotateAllFiles(forceRotation bool, createNew bool) {
-	for key := range m.files {
-		if m.fileMutex.TryLock(key) {
-			fileDetails, ok := m.files[key]
+	for _, key := range m.fileKeys() {
+		if m.fileLocks.Lock(key, m.lockTimeout()) {
+			fileDetails, ok := m.getFile(key)
 			if fileDetails != nil && ok {
 				if m.NeedsRotation(fileDetails) || forceRotation {
 					log.Trace().Str("file", fileDetails.path).Msg("Rotating")
@@ -87,12 +118,55 @@ func (m *DataSink) RotateAllFiles(forceRotation bool, createNew bool) {
 					}
 				}
 			}
-			m.fileMutex.Unlock(key)
+			m.fileLocks.Unlock(key)
 		}
 	}
 }
 
+// fileKeys returns the keys of the files which are open
+func (m *DataSink) fileKeys() []string {
+	m.filesLock.Lock()
+	defer m.filesLock.Unlock()
+
+	keys := make([]string, 0, len(m.files))
+	for key := range m.files {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *DataSink) getFile(key string) (*FileDetails, bool) {
+	m.filesLock.Lock()
+	defer m.filesLock.Unlock()
+
+	details, ok := m.files[key]
+	return details, ok
+}
+
+func (m *DataSink) setFile(key string, details *FileDetails) {
+	m.filesLock.Lock()
+	defer m.filesLock.Unlock()
+
+	if details == nil {
+		delete(m.files, key)
+	} else {
+		m.files[key] = details
+	}
+}
+
+func (m *DataSink) lockTimeout() time.Duration {
+	if m.LockTimeoutMs > 0 {
+		return time.Duration(m.LockTimeoutMs) * time.Millisecond
+	}
+	return defaultLockTimeout
+}
+
+// visit uploads and queues a closed file. A file which can't be uploaded is
+// logged and left for the next attempt, without stopping the rest.
 func (m *DataSink) visit(path string, di fs.DirEntry, e error) error {
+	if e != nil {
+		return e
+	}
 	if di.IsDir() {
 		return nil
 	}
@@ -104,24 +178,23 @@ func (m *DataSink) visit(path string, di fs.DirEntry, e error) error {
 
 	dbIdInt64, err := strconv.ParseInt(dbId, 10, 64)
 	if err != nil {
-		return err
+		log.Error().Err(err).Str("path", path).Msg("Unable to get database from file path")
+		return nil
 	}
 
-	key := fmt.Sprintf("data/%s/%s/%s", dbId, table, file)
-	fd, err := os.Open(path)
-	if err != nil {
-		return err
-	}
+	keyPrefix := fmt.Sprintf("data/%s/%s/%s", dbId, table, strings.TrimSuffix(file, filepath.Ext(file)))
 
-	uploadErr := m.storage.BlobStore.Upload(key, fd)
+	key, format, uploadErr := m.uploadStaged(keyPrefix, path)
 	if uploadErr != nil {
-		return uploadErr
+		log.Error().Err(uploadErr).Str("path", path).Msg("Unable to upload file")
+		return nil
 	}
 
 	uploadMessage := queuemodels.FileUploadMessage{
 		DatabaseID: dbIdInt64,
 		Table:      table,
 		Key:        key,
+		Format:     format,
 	}
 
 	// We delete the file locally before queuing. That way if the delete fails
@@ -132,7 +205,7 @@ func (m *DataSink) visit(path string, di fs.DirEntry, e error) error {
 		// Don't return an error because we want the walk to continue
 	}
 
-	_, err = m.storage.Database.Enqueue(models.InsertData, uploadMessage)
+	_, err = m.storage.Database.Enqueue(models.InsertData, uploadMessage, uint(dbIdInt64), table)
 	if err != nil {
 		log.Error().Err(err).Str("path", path).Interface("message", uploadMessage).Msg("Did not enqueue file. Needs to be queued.")
 		// Don't return an error because we want the walk to continue
@@ -141,6 +214,55 @@ func (m *DataSink) visit(path string, di fs.DirEntry, e error) error {
 	return nil
 }
 
+// uploadStaged uploads a closed file, converting it to the staging format first
+// if one is set. A file which can't be converted is uploaded as NDJSON. The key
+// and format it was uploaded with are returned.
+func (m *DataSink) uploadStaged(keyPrefix string, path string) (string, string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer fd.Close()
+
+	if m.Format != "" && m.Format != util.StagingNDJSON {
+		key := keyPrefix + "." + util.StagingExtension(m.Format)
+		staged, err := m.stage(fd)
+		if err == nil {
+			defer os.Remove(staged.Name())
+			defer staged.Close()
+			return key, m.Format, m.storage.BlobStore.Upload(key, staged)
+		}
+
+		log.Error().Err(err).Str("path", path).Str("format", m.Format).Msg("Unable to convert file to staging format, uploading it as NDJSON")
+		_, err = fd.Seek(0, io.SeekStart)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	key := keyPrefix + "." + util.StagingExtension(util.StagingNDJSON)
+	return key, util.StagingNDJSON, m.storage.BlobStore.Upload(key, fd)
+}
+
+// stage converts a file to the staging format in a temporary file
+func (m *DataSink) stage(fd *os.File) (*os.File, error) {
+	staged, err := os.CreateTemp(m.DataDir, "staging-*")
+	if err != nil {
+		return nil, err
+	}
+
+	err = util.EncodeStaging(m.Format, fd, staged)
+	if err == nil {
+		_, err = staged.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		staged.Close()
+		os.Remove(staged.Name())
+		return nil, err
+	}
+	return staged, nil
+}
+
 func (m *DataSink) UploadFiles() {
 	m.uploadMutex.Lock()
 	defer m.uploadMutex.Unlock()
@@ -150,6 +272,11 @@ func (m *DataSink) UploadFiles() {
 	if err != nil {
 		log.Error().Err(err).Msg("Problem uploading file")
 	}
+
+	err = m.measureBacklog()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to measure upload backlog")
+	}
 }
 
 func (m *DataSink) MonitorUploads(ctx context.Context) {
@@ -207,12 +334,19 @@ func (m *DataSink) NeedsRotation(details *FileDetails) bool {
 func (m *DataSink) RotateFile(details *FileDetails, createNew bool) (*FileDetails, error) {
 	key := m.key(details.databaseId, details.table)
 
+	if m.Fsync && details.unsynced {
+		err := details.fd.Sync()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err := details.fd.Close()
 	if err != nil {
 		return nil, err
 	}
 
-	delete(m.files, key)
+	m.setFile(key, nil)
 
 	if details.byteCount > 0 {
 		closedFolderPath := filepath.Join(m.DataDir, ClosedFolder, fmt.Sprintf("%d", details.databaseId), details.table)
@@ -226,6 +360,14 @@ func (m *DataSink) RotateFile(details *FileDetails, createNew bool) (*FileDetail
 		if err != nil {
 			return nil, err
 		}
+
+		if m.Fsync {
+			err = syncDirs(filepath.Join(m.DataDir, ClosedFolder), closedFolderPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		m.backlogBytes.Add(details.byteCount)
 	}
 
 	err = os.Remove(details.path)
@@ -239,7 +381,7 @@ func (m *DataSink) RotateFile(details *FileDetails, createNew bool) (*FileDetail
 			return nil, err
 		}
 
-		m.files[key] = newFile
+		m.setFile(key, newFile)
 		return newFile, nil
 	}
 
@@ -247,7 +389,75 @@ func (m *DataSink) RotateFile(details *FileDetails, createNew bool) (*FileDetail
 }
 
 func (m *DataSink) IsDiskFull() (bool, error) {
-	return false, nil
+	if m.MinFreeDiskBytes == 0 {
+		return false, nil
+	}
+	return util.FreeDiskSpace(m.DataDir) < m.MinFreeDiskBytes, nil
+}
+
+// checkCapacity returns a BusyError if the disk or upload backlog is full
+func (m *DataSink) checkCapacity() error {
+	isFull, err := m.IsDiskFull()
+	if err != nil {
+		return err
+	}
+	if isFull {
+		return sink_models.BusyError{Reason: "Disk is full", Wait: backlogRetryAfter}
+	}
+
+	if m.MaxBacklogBytes > 0 && m.backlogBytes.Load() >= m.MaxBacklogBytes {
+		return sink_models.BusyError{Reason: "Too much data is waiting to be uploaded", Wait: backlogRetryAfter}
+	}
+
+	return nil
+}
+
+func (m *DataSink) Health() (sink_models.Health, error) {
+	health := sink_models.Health{
+		FreeDiskBytes: util.FreeDiskSpace(m.DataDir),
+		BacklogBytes:  m.backlogBytes.Load(),
+	}
+	return health, m.checkCapacity()
+}
+
+// measureBacklog counts the bytes in closed files which haven't been uploaded
+func (m *DataSink) measureBacklog() error {
+	var total int64
+	closedFiles := filepath.Join(m.DataDir, ClosedFolder)
+	err := filepath.WalkDir(closedFiles, func(path string, di fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if di.IsDir() {
+			return nil
+		}
+
+		info, err := di.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.backlogBytes.Store(total)
+	return nil
+}
+
+// discardPartialRow removes whatever part of a row was written before a write
+// failed, such as when the disk is full, so that the next row doesn't get
+// appended to it
+func (d *FileDetails) discardPartialRow(writeErr error) {
+	err := d.fd.Truncate(d.byteCount)
+	if err == nil {
+		_, err = d.fd.Seek(d.byteCount, io.SeekStart)
+	}
+	if err != nil {
+		log.Error().Err(err).AnErr("write_error", writeErr).Str("file", d.path).Msg("Unable to remove partially written row")
+	}
 }
 
 func (m *DataSink) CreateFile(databaseID int64, table string) (*FileDetails, error) {
@@ -269,6 +479,14 @@ func (m *DataSink) CreateFile(databaseID int64, table string) (*FileDetails, err
 		return nil, err
 	}
 
+	if m.Fsync {
+		err = syncDirs(filepath.Join(m.DataDir, OpenFolder), tableDir)
+		if err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+
 	fileDetails := &FileDetails{
 		fd:      fd,
 		path:    filePath,
@@ -281,6 +499,27 @@ func (m *DataSink) CreateFile(databaseID int64, table string) (*FileDetails, err
 	return fileDetails, nil
 }
 
+// syncDirs syncs dir and each of its parents up to and including root, so that
+// newly created files and folders in them survive a crash
+func syncDirs(root string, dir string) error {
+	for {
+		d, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		err = d.Sync()
+		d.Close()
+		if err != nil {
+			return err
+		}
+
+		if dir == root || filepath.Dir(dir) == dir {
+			return nil
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
 func (m *DataSink) EnsureFile(databaseID int64, table string) (*FileDetails, error) {
 	key := m.key(databaseID, table)
 
@@ -288,14 +527,14 @@ func (m *DataSink) EnsureFile(databaseID int64, table string) (*FileDetails, err
 	var err error
 
 	// If the file doesn't exist, then create it
-	fileDetails, ok := m.files[key]
+	fileDetails, ok := m.getFile(key)
 	if !ok {
 		fileDetails, err = m.CreateFile(databaseID, table)
 		if err != nil {
 			return nil, err
 		}
 
-		m.files[key] = fileDetails
+		m.setFile(key, fileDetails)
 		return fileDetails, nil
 	}
 
@@ -318,44 +557,72 @@ func (m *DataSink) WriteData(databaseID int64, table string, data []byte) error
 	m.wg.Add(1)
 	defer m.wg.Done()
 
-	// TODO: Is the disk full?
-	isFull, err := m.IsDiskFull()
+	err := m.checkCapacity()
 	if err != nil {
 		return err
 	}
-	if isFull {
-		return errors.New("Disk is full")
-	}
 
 	mutexKey := m.key(databaseID, table)
-	if m.fileMutex.TryLock(mutexKey) {
-		defer m.fileMutex.Unlock(mutexKey)
+	if m.fileLocks.Lock(mutexKey, m.lockTimeout()) {
+		defer m.fileLocks.Unlock(mutexKey)
 
 		fileDetails, err := m.EnsureFile(databaseID, table)
 		if err != nil {
 			return err
 		}
 
-		bytesWritten, err := fileDetails.fd.Write(data)
-		if err != nil {
-			return err
-		}
-		fileDetails.byteCount += int64(bytesWritten)
+		line := make([]byte, 0, len(data)+1)
+		line = append(line, data...)
+		line = append(line, '\n')
 
-		bytesWritten, err = fileDetails.fd.Write([]byte("\n"))
+		bytesWritten, err := fileDetails.fd.Write(line)
 		if err != nil {
+			fileDetails.discardPartialRow(err)
 			return err
 		}
 		fileDetails.byteCount += int64(bytesWritten)
 
 		fileDetails.rowCount += 1
+		fileDetails.unsynced = true
 	} else {
-		return errors.New("Could not acquire lock")
+		return sink_models.BusyError{Reason: "Timed out waiting to write to table", Wait: time.Second}
 	}
 
 	return nil
 }
 
+// Flush syncs the open files of the given tables, if fsync is enabled and they
+// have been written to since they were last synced. Files which have been
+// rotated since were synced when they were closed.
+func (m *DataSink) Flush(databaseID int64, tables []string) error {
+	if !m.Fsync {
+		return nil
+	}
+
+	var rc error
+	for _, table := range tables {
+		key := m.key(databaseID, table)
+		if !m.fileLocks.Lock(key, m.lockTimeout()) {
+			rc = sink_models.BusyError{Reason: "Timed out waiting to sync table", Wait: time.Second}
+			continue
+		}
+
+		fileDetails, ok := m.getFile(key)
+		if ok && fileDetails.unsynced {
+			err := fileDetails.fd.Sync()
+			if err != nil {
+				log.Error().Err(err).Str("file", fileDetails.path).Msg("Unable to sync file")
+				rc = err
+			} else {
+				fileDetails.unsynced = false
+			}
+		}
+		m.fileLocks.Unlock(key)
+	}
+
+	return rc
+}
+
 func (m *DataSink) Shutdown() error {
 	m.enabled = false
 	m.wg.Wait()
@@ -382,14 +649,28 @@ func NewFilesystemDataSink(settings map[string]any, storage *storage.Services) (
 		return nil, err
 	}
 
+	if !util.IsStagingFormat(rc.Format) {
+		return nil, fmt.Errorf("Unsupported staging format %q", rc.Format)
+	}
+
 	snow, err := util.NewSnowflakeGenerator()
 	if err != nil {
 		return nil, err
 	}
 
+	err = rc.RecoverOpenFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	err = rc.measureBacklog()
+	if err != nil {
+		return nil, err
+	}
+
 	rc.storage = storage
 	rc.snow = snow
-	rc.fileMutex = mapmutex.NewMapMutex()
+	rc.fileLocks = newFileLocks()
 	rc.files = map[string]*FileDetails{}
 	rc.uploadMutex = &sync.Mutex{}
 
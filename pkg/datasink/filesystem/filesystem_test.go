@@ -0,0 +1,113 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	sink_models "github.com/scratchdata/scratchdata/pkg/datasink/models"
+)
+
+func newTestDataSink(t *testing.T, settings map[string]any) *DataSink {
+	settings["data"] = t.TempDir()
+	settings["max_rows"] = 1000000
+	settings["max_size_bytes"] = 1000000000
+	settings["max_age_seconds"] = 60
+
+	sink, err := NewFilesystemDataSink(settings, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.enabled = true
+	return sink
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	sink := newTestDataSink(t, map[string]any{})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			table := "events"
+			if i%2 == 0 {
+				table = "clicks"
+			}
+			errs <- sink.WriteData(1, table, []byte(`{"user":"alice"}`))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Expected concurrent writes to wait for each other; Got %s", err)
+		}
+	}
+
+	details, _ := sink.getFile(sink.key(1, "events"))
+	if details.rowCount != 50 {
+		t.Fatalf("Expected 50 rows; Got %d", details.rowCount)
+	}
+}
+
+func TestWriteBackpressure(t *testing.T) {
+	sink := newTestDataSink(t, map[string]any{"lock_timeout_ms": 10, "max_backlog_bytes": 100})
+
+	key := sink.key(1, "events")
+	sink.fileLocks.Lock(key, sink.lockTimeout())
+	err := sink.WriteData(1, "events", []byte(`{"user":"alice"}`))
+	sink.fileLocks.Unlock(key)
+
+	var busy sink_models.BusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("Expected a busy error while the table is locked; Got %v", err)
+	}
+
+	sink.backlogBytes.Store(100)
+	err = sink.WriteData(1, "events", []byte(`{"user":"alice"}`))
+	if !errors.As(err, &busy) || busy.RetryAfter() != backlogRetryAfter {
+		t.Fatalf("Expected a busy error while the upload backlog is full; Got %v", err)
+	}
+
+	sink.backlogBytes.Store(0)
+	if err := sink.WriteData(1, "events", []byte(`{"user":"alice"}`)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscardPartialRow(t *testing.T) {
+	sink := newTestDataSink(t, map[string]any{"fsync": true})
+
+	if err := sink.WriteData(1, "events", []byte(`{"user":"alice"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write which fails part way through, such as when the disk fills up
+	details, _ := sink.getFile(sink.key(1, "events"))
+	if _, err := details.fd.Write([]byte(`{"user":`)); err != nil {
+		t.Fatal(err)
+	}
+	details.discardPartialRow(errors.New("no space left on device"))
+
+	if err := sink.WriteData(1, "events", []byte(`{"user":"bob"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	name := details.Name()
+	if _, err := sink.RotateFile(details, false); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(sink.DataDir, ClosedFolder, "1", "events", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{\"user\":\"alice\"}\n{\"user\":\"bob\"}\n"; string(data) != expected {
+		t.Fatalf("Expected %q; Got %q", expected, string(data))
+	}
+}
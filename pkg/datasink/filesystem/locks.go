@@ -0,0 +1,47 @@
+package filesystem
+
+import (
+	"sync"
+	"time"
+)
+
+// fileLocks are per-table locks. Writers to the same table wait for each other
+// in turn, rather than failing when the table is busy.
+type fileLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func newFileLocks() *fileLocks {
+	return &fileLocks{locks: map[string]chan struct{}{}}
+}
+
+func (l *fileLocks) get(key string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		l.locks[key] = lock
+	}
+	return lock
+}
+
+// Lock waits up to timeout for the lock, and returns whether it was acquired
+func (l *fileLocks) Lock(key string, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case l.get(key) <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Unlock releases a lock acquired with Lock
+func (l *fileLocks) Unlock(key string) {
+	<-l.get(key)
+}
@@ -0,0 +1,103 @@
+package filesystem
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RecoverOpenFiles closes files which were left open when the process stopped
+// without rotating them, such as after a crash, so that they are uploaded.
+// Only one process may use a data directory, as its open files would be closed
+// too.
+//
+// A row which was only partly written is removed, since the insert which wrote
+// it can't have been acknowledged.
+func (m *DataSink) RecoverOpenFiles() error {
+	openDir := filepath.Join(m.DataDir, OpenFolder)
+	closedDir := filepath.Join(m.DataDir, ClosedFolder)
+
+	return filepath.WalkDir(openDir, func(path string, di fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if di.IsDir() {
+			return nil
+		}
+
+		size, err := completeLength(path)
+		if err != nil {
+			return err
+		}
+
+		if size == 0 {
+			log.Info().Str("path", path).Msg("Removing empty open file")
+			return os.Remove(path)
+		}
+
+		err = os.Truncate(path, size)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(openDir, path)
+		if err != nil {
+			return err
+		}
+		closedPath := filepath.Join(closedDir, rel)
+
+		err = os.MkdirAll(filepath.Dir(closedPath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		// The file may already have been linked if the process stopped part way
+		// through a rotation
+		err = os.Link(path, closedPath)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+
+		log.Info().Str("path", path).Int64("bytes", size).Msg("Recovered open file")
+		return os.Remove(path)
+	})
+}
+
+// completeLength returns the length of a file up to the end of its last
+// complete line
+func completeLength(path string) (int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	info, err := fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 64*1024)
+	end := info.Size()
+	for end > 0 {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+
+		_, err := fd.ReadAt(chunk, start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,79 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecoverOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	tableDir := filepath.Join(dir, OpenFolder, "1", "events")
+	if err := os.MkdirAll(tableDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"1.ndjson": "{\"user\":\"alice\"}\n{\"user\":\"bob\"}\n{\"user\":",
+		"2.ndjson": "{\"user\":\"carol\"}\n",
+		"3.ndjson": "",
+		"4.ndjson": "{\"user\":",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tableDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := NewFilesystemDataSink(map[string]any{"data": dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, _ := os.ReadDir(tableDir); len(entries) != 0 {
+		t.Fatalf("Expected no open files to be left; Got %d", len(entries))
+	}
+
+	closedDir := filepath.Join(dir, ClosedFolder, "1", "events")
+	expected := map[string]string{
+		"1.ndjson": "{\"user\":\"alice\"}\n{\"user\":\"bob\"}\n",
+		"2.ndjson": "{\"user\":\"carol\"}\n",
+	}
+	entries, _ := os.ReadDir(closedDir)
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d closed files; Got %d", len(expected), len(entries))
+	}
+	for name, data := range expected {
+		got, err := os.ReadFile(filepath.Join(closedDir, name))
+		if err != nil || string(got) != data {
+			t.Fatalf("Expected %s to contain %q; Got %q %v", name, data, got, err)
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	sink := newTestDataSink(t, map[string]any{"fsync": true, "lock_timeout_ms": 10})
+
+	for _, table := range []string{"events", "clicks"} {
+		if err := sink.WriteData(1, table, []byte(`{"user":"alice"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	details, _ := sink.getFile(sink.key(1, "events"))
+	if !details.unsynced {
+		t.Fatalf("Expected the file to need syncing")
+	}
+
+	// A busy table which wasn't written to doesn't hold up the flush
+	busyKey := sink.key(1, "clicks")
+	sink.fileLocks.Lock(busyKey, sink.lockTimeout())
+	defer sink.fileLocks.Unlock(busyKey)
+
+	if err := sink.Flush(1, []string{"events"}); err != nil {
+		t.Fatal(err)
+	}
+	if details.unsynced {
+		t.Fatalf("Expected the file to be synced")
+	}
+}
@@ -0,0 +1,76 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scratchdata/scratchdata/pkg/config"
+	"github.com/scratchdata/scratchdata/pkg/storage"
+	blob_memory "github.com/scratchdata/scratchdata/pkg/storage/blobstore/memory"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/gorm"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	queuemodels "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+)
+
+// tableBlobStore fails to upload files for one table
+type tableBlobStore struct {
+	*blob_memory.Storage
+	failTable string
+}
+
+func (b *tableBlobStore) Upload(path string, r io.ReadSeeker) error {
+	if strings.Contains(path, "/"+b.failTable+"/") {
+		return errors.New("bucket unavailable")
+	}
+	return b.Storage.Upload(path, r)
+}
+
+func TestUploadFilesSkipsFailures(t *testing.T) {
+	sink := newTestDataSink(t, map[string]any{})
+
+	db, err := gorm.NewGorm(config.Database{
+		Type:     "sqlite",
+		Settings: map[string]any{"dsn": filepath.Join(t.TempDir(), "sink.db")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ := blob_memory.NewStorage(nil)
+	sink.storage = &storage.Services{Database: db, BlobStore: &tableBlobStore{Storage: blobs, failTable: "clicks"}}
+
+	closed := filepath.Join(sink.DataDir, ClosedFolder)
+	files := []string{"1/clicks/a.ndjson", "1/events/b.ndjson", "bad/events/c.ndjson"}
+	for _, name := range files {
+		path := filepath.Join(closed, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("{\"n\":1}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink.UploadFiles()
+
+	msg, ok := db.Dequeue(models.InsertData, "test", models.DequeueOptions{})
+	if !ok {
+		t.Fatalf("Expected files after a failed one to be uploaded")
+	}
+	var message queuemodels.FileUploadMessage
+	json.Unmarshal([]byte(msg.Message), &message)
+	if message.Table != "events" {
+		t.Fatalf("Expected the events file to be queued; Got %+v", message)
+	}
+
+	for name, kept := range map[string]bool{files[0]: true, files[1]: false, files[2]: true} {
+		_, err := os.Stat(filepath.Join(closed, name))
+		if kept != (err == nil) {
+			t.Fatalf("Expected %s to be kept: %v; Got %v", name, kept, err)
+		}
+	}
+}
@@ -3,42 +3,229 @@ package memory
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bwmarrin/snowflake"
+	"github.com/rs/zerolog/log"
+	sink_models "github.com/scratchdata/scratchdata/pkg/datasink/models"
 	"github.com/scratchdata/scratchdata/pkg/storage"
 	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
 	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
 	"github.com/scratchdata/scratchdata/pkg/util"
 )
 
+// Limits for a batch when they aren't set
+const (
+	defaultMaxSize       = 10_000_000
+	defaultMaxRows       = 10_000
+	defaultMaxAgeSeconds = 5
+
+	defaultMaxBacklogBytes = 1_000_000_000
+)
+
+// How long a client should wait before retrying a write which was rejected
+// because too much data is waiting to be uploaded
+const backlogRetryAfter = 10 * time.Second
+
+// DataSink collects rows in memory, in a batch for each destination and table.
+// A batch is uploaded as one file once it reaches a size, number of rows or age.
+// Rows which haven't been uploaded are lost if the process crashes.
 type DataSink struct {
+	MaxSize       int64 `mapstructure:"max_size_bytes"`
+	MaxRows       int64 `mapstructure:"max_rows"`
+	MaxAgeSeconds int   `mapstructure:"max_age_seconds"`
+
+	// Staging format batches are uploaded in, such as gzip or parquet
+	Format string `mapstructure:"format"`
+
+	// Writes are rejected as busy while more than this many bytes are held in
+	// memory waiting to be uploaded
+	MaxBacklogBytes int64 `mapstructure:"max_backlog_bytes"`
+
 	storage *storage.Services
 	snow    *snowflake.Node
+	enabled bool
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	batches map[string]*batch
+
+	// Bytes in batches which haven't been uploaded, including batches which are
+	// being uploaded
+	backlogBytes atomic.Int64
+}
+
+type batch struct {
+	databaseID int64
+	table      string
+
+	data    bytes.Buffer
+	rows    int64
+	created time.Time
+}
+
+func (m *DataSink) Start(ctx context.Context) error {
+	m.enabled = true
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.UploadBatches(false)
+		case <-ctx.Done():
+			return m.Shutdown()
+		}
+	}
+}
+
+func (m *DataSink) Shutdown() error {
+	m.enabled = false
+	m.wg.Wait()
+
+	return m.UploadBatches(true)
+}
+
+func (m *DataSink) key(databaseID int64, table string) string {
+	return fmt.Sprintf("%d_%s", databaseID, table)
+}
+
+func (m *DataSink) full(b *batch) bool {
+	return int64(b.data.Len()) >= m.MaxSize || b.rows >= m.MaxRows
+}
+
+func (m *DataSink) expired(b *batch) bool {
+	return b.rows > 0 && time.Since(b.created) >= time.Duration(m.MaxAgeSeconds)*time.Second
+}
+
+func (m *DataSink) WriteData(databaseID int64, table string, data []byte) error {
+	if !m.enabled {
+		return errors.New("writer is disabled")
+	}
+
+	m.wg.Add(1)
+	defer m.wg.Done()
+
+	err := m.checkCapacity()
+	if err != nil {
+		return err
+	}
+
+	key := m.key(databaseID, table)
+
+	m.mu.Lock()
+	b, ok := m.batches[key]
+	if !ok {
+		b = &batch{databaseID: databaseID, table: table, created: time.Now()}
+		m.batches[key] = b
+	}
+
+	b.data.Write(data)
+	b.data.WriteByte('\n')
+	b.rows++
+	m.backlogBytes.Add(int64(len(data) + 1))
+
+	if !m.full(b) {
+		m.mu.Unlock()
+		return nil
+	}
+
+	delete(m.batches, key)
+	m.mu.Unlock()
+
+	// If the upload fails the batch is kept and tried again, so this row has
+	// still been written
+	m.upload(b)
+	return nil
 }
 
-func (m DataSink) Start(ctx context.Context) error {
+// checkCapacity returns a BusyError if the upload backlog is full
+func (m *DataSink) checkCapacity() error {
+	if m.backlogBytes.Load() > m.MaxBacklogBytes {
+		return sink_models.BusyError{Reason: "Too much data is waiting to be uploaded", Wait: backlogRetryAfter}
+	}
+	return nil
+}
+
+// Health reports the bytes in batches which haven't been uploaded yet
+func (m *DataSink) Health() (sink_models.Health, error) {
+	health := sink_models.Health{BacklogBytes: m.backlogBytes.Load()}
+	return health, m.checkCapacity()
+}
+
+// UploadBatches uploads each batch which is old enough, or every batch if force
+// is set
+func (m *DataSink) UploadBatches(force bool) error {
+	var ready []*batch
+
+	m.mu.Lock()
+	for key, b := range m.batches {
+		if force || m.expired(b) {
+			ready = append(ready, b)
+			delete(m.batches, key)
+		}
+	}
+	m.mu.Unlock()
+
+	var rc error
+	for _, b := range ready {
+		err := m.upload(b)
+		if err != nil {
+			rc = err
+		}
+	}
+	return rc
+}
+
+// upload saves a batch to the blob store and queues it to be inserted. If that
+// fails, its rows are put back so that they are tried again with the next batch.
+func (m *DataSink) upload(b *batch) error {
+	err := m.uploadBatch(b)
+	if err != nil {
+		log.Error().Err(err).Int64("database_id", b.databaseID).Str("table", b.table).Int64("rows", b.rows).Msg("Unable to upload batch")
+		m.restore(b)
+		return err
+	}
+
+	m.backlogBytes.Add(-int64(b.data.Len()))
 	return nil
 }
 
-func (m DataSink) WriteData(databaseID int64, table string, data []byte) error {
+func (m *DataSink) uploadBatch(b *batch) error {
+	// A batch which can't be converted to the staging format, such as one with
+	// values Parquet can't hold, is uploaded as NDJSON rather than tried forever
+	format := m.Format
+	var staged bytes.Buffer
+	err := util.EncodeStaging(format, bytes.NewReader(b.data.Bytes()), &staged)
+	if err != nil {
+		log.Error().Err(err).Int64("database_id", b.databaseID).Str("table", b.table).Str("format", format).Msg("Unable to convert batch to staging format, uploading it as NDJSON")
+		format = util.StagingNDJSON
+		staged.Reset()
+		staged.Write(b.data.Bytes())
+	}
+
 	fileId := m.snow.Generate()
-	key := fmt.Sprintf("%d/%s/%d.ndjson", databaseID, table, fileId.Int64())
-	reader := bytes.NewReader(data)
+	key := fmt.Sprintf("%d/%s/%d.%s", b.databaseID, b.table, fileId.Int64(), util.StagingExtension(format))
 
-	uploadErr := m.storage.BlobStore.Upload(key, reader)
+	uploadErr := m.storage.BlobStore.Upload(key, bytes.NewReader(staged.Bytes()))
 	if uploadErr != nil {
 		return uploadErr
 	}
 
 	uploadMessage := queue_models.FileUploadMessage{
-		DatabaseID: databaseID,
-		Table:      table,
+		DatabaseID: b.databaseID,
+		Table:      b.table,
 		Key:        key,
+		Format:     format,
 	}
 
 	// TODO: log payload for replay
-	_, err := m.storage.Database.Enqueue(models.InsertData, uploadMessage)
+	_, err = m.storage.Database.Enqueue(models.InsertData, uploadMessage, uint(b.databaseID), b.table)
 	if err != nil {
 		return err
 	}
@@ -46,15 +233,48 @@ func (m DataSink) WriteData(databaseID int64, table string, data []byte) error {
 	return nil
 }
 
-func NewMemoryDataSink(storage *storage.Services) (*DataSink, error) {
+// restore puts the rows of a batch which failed to upload back in front of any
+// rows written since
+func (m *DataSink) restore(b *batch) {
+	key := m.key(b.databaseID, b.table)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.batches[key]; ok {
+		b.data.Write(current.data.Bytes())
+		b.rows += current.rows
+	}
+	m.batches[key] = b
+}
+
+func NewMemoryDataSink(settings map[string]any, storage *storage.Services) (*DataSink, error) {
+	rc := util.ConfigToStruct[DataSink](settings)
+
+	if rc.MaxSize <= 0 {
+		rc.MaxSize = defaultMaxSize
+	}
+	if rc.MaxRows <= 0 {
+		rc.MaxRows = defaultMaxRows
+	}
+	if rc.MaxAgeSeconds <= 0 {
+		rc.MaxAgeSeconds = defaultMaxAgeSeconds
+	}
+	if rc.MaxBacklogBytes <= 0 {
+		rc.MaxBacklogBytes = defaultMaxBacklogBytes
+	}
+	if !util.IsStagingFormat(rc.Format) {
+		return nil, fmt.Errorf("Unsupported staging format %q", rc.Format)
+	}
+
 	snow, err := util.NewSnowflakeGenerator()
 	if err != nil {
 		return nil, err
 	}
 
-	rc := &DataSink{
-		storage: storage,
-		snow:    snow,
-	}
+	rc.storage = storage
+	rc.snow = snow
+	rc.batches = map[string]*batch{}
+
 	return rc, nil
 }
@@ -0,0 +1,175 @@
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scratchdata/scratchdata/pkg/config"
+	sink_models "github.com/scratchdata/scratchdata/pkg/datasink/models"
+	"github.com/scratchdata/scratchdata/pkg/storage"
+	blob_memory "github.com/scratchdata/scratchdata/pkg/storage/blobstore/memory"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/gorm"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+)
+
+func newTestDataSink(t *testing.T, settings map[string]any) (*DataSink, *gorm.Gorm, *blob_memory.Storage) {
+	db, err := gorm.NewGorm(config.Database{
+		Type:     "sqlite",
+		Settings: map[string]any{"dsn": filepath.Join(t.TempDir(), "sink.db")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ := blob_memory.NewStorage(nil)
+
+	sink, err := NewMemoryDataSink(settings, &storage.Services{Database: db, BlobStore: blobs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.enabled = true
+	return sink, db, blobs
+}
+
+func TestBatching(t *testing.T) {
+	sink, db, blobs := newTestDataSink(t, map[string]any{"max_rows": 3})
+
+	for _, row := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`} {
+		if err := sink.WriteData(1, "events", []byte(row)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.WriteData(1, "clicks", []byte(`{"n":5}`))
+
+	// Batches which aren't full wait until they're old enough
+	sink.UploadBatches(false)
+	expectBatch(t, db, blobs, "events", "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n")
+	if _, ok := db.Dequeue(models.InsertData, "test", models.DequeueOptions{}); ok {
+		t.Fatalf("Expected only the full batch to be uploaded")
+	}
+
+	if err := sink.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteData(1, "events", []byte(`{"n":6}`)); err == nil {
+		t.Fatalf("Expected writes to be rejected after shutdown")
+	}
+
+	remaining := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		msg, ok := db.Dequeue(models.InsertData, "test", models.DequeueOptions{})
+		if !ok {
+			t.Fatalf("Expected every batch to be uploaded on shutdown")
+		}
+		var message queue_models.FileUploadMessage
+		json.Unmarshal([]byte(msg.Message), &message)
+		remaining[message.Table] = true
+	}
+	if !remaining["events"] || !remaining["clicks"] {
+		t.Fatalf("Expected a batch for each table; Got %v", remaining)
+	}
+}
+
+func expectBatch(t *testing.T, db *gorm.Gorm, blobs *blob_memory.Storage, table string, expected string) {
+	msg, ok := db.Dequeue(models.InsertData, "test", models.DequeueOptions{})
+	if !ok {
+		t.Fatalf("Expected a batch to be queued")
+	}
+
+	var message queue_models.FileUploadMessage
+	if err := json.Unmarshal([]byte(msg.Message), &message); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "batch.ndjson")
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = blobs.Download(message.Key, fd)
+	fd.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if message.Table != table || string(data) != expected {
+		t.Fatalf("Expected %q in %s; Got %q in %s", expected, table, data, message.Table)
+	}
+}
+
+func TestStagingFormat(t *testing.T) {
+	sink, db, _ := newTestDataSink(t, map[string]any{"format": "gzip"})
+
+	sink.WriteData(1, "events", []byte(`{"n":1}`))
+	if err := sink.UploadBatches(true); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, ok := db.Dequeue(models.InsertData, "test", models.DequeueOptions{})
+	if !ok {
+		t.Fatalf("Expected a batch to be queued")
+	}
+
+	var message queue_models.FileUploadMessage
+	json.Unmarshal([]byte(msg.Message), &message)
+	if message.Format != "gzip" || !strings.HasSuffix(message.Key, ".ndjson.gz") {
+		t.Fatalf("Expected a gzip staging file; Got %+v", message)
+	}
+
+	if _, err := NewMemoryDataSink(map[string]any{"format": "lz4"}, nil); err == nil {
+		t.Fatalf("Expected an unknown format to be rejected")
+	}
+}
+
+// unavailableBlobStore fails every upload while down is set
+type unavailableBlobStore struct {
+	*blob_memory.Storage
+	down bool
+}
+
+func (b *unavailableBlobStore) Upload(path string, r io.ReadSeeker) error {
+	if b.down {
+		return errors.New("bucket unavailable")
+	}
+	return b.Storage.Upload(path, r)
+}
+
+func TestBacklogLimit(t *testing.T) {
+	sink, db, blobs := newTestDataSink(t, map[string]any{"max_rows": 1, "max_backlog_bytes": 10})
+	unavailable := &unavailableBlobStore{Storage: blobs, down: true}
+	sink.storage.BlobStore = unavailable
+
+	// Batches which fail to upload are kept, until there's too much data to keep
+	for _, row := range []string{`{"n":1}`, `{"n":2}`} {
+		if err := sink.WriteData(1, "events", []byte(row)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var busy sink_models.BusyError
+	if err := sink.WriteData(1, "events", []byte(`{"n":3}`)); !errors.As(err, &busy) {
+		t.Fatalf("Expected a full backlog to be busy; Got %v", err)
+	}
+	if health, err := sink.Health(); err == nil || health.BacklogBytes != 16 {
+		t.Fatalf("Expected a full backlog to be unhealthy; Got %+v %v", health, err)
+	}
+
+	unavailable.down = false
+	if err := sink.UploadBatches(true); err != nil {
+		t.Fatal(err)
+	}
+	expectBatch(t, db, blobs, "events", "{\"n\":1}\n{\"n\":2}\n")
+
+	if health, err := sink.Health(); err != nil || health.BacklogBytes != 0 {
+		t.Fatalf("Expected the backlog to be empty; Got %+v %v", health, err)
+	}
+	if err := sink.WriteData(1, "events", []byte(`{"n":3}`)); err != nil {
+		t.Fatal(err)
+	}
+}
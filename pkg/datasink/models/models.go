@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Health is how much data a sink is holding, and how much room it has for more
+type Health struct {
+	FreeDiskBytes uint64 `json:"free_disk_bytes,omitempty"`
+	BacklogBytes  int64  `json:"backlog_bytes"`
+}
+
+// BusyError is returned when the sink can't accept more data for now. The write
+// should be tried again after RetryAfter.
+type BusyError struct {
+	Reason string
+	Wait   time.Duration
+}
+
+func (e BusyError) Error() string {
+	return e.Reason
+}
+
+func (e BusyError) RetryAfter() time.Duration {
+	return e.Wait
+}
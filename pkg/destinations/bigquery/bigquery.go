@@ -7,6 +7,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/scratchdata/scratchdata/pkg/util"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -60,3 +61,13 @@ func OpenServer(settings map[string]interface{}) (*BigQueryServer, error) {
 func (s *BigQueryServer) Close() error {
 	return s.conn.Close()
 }
+
+// Ping lists a dataset, which checks that BigQuery can be reached with these
+// credentials without running a query
+func (s *BigQueryServer) Ping(ctx context.Context) error {
+	_, err := s.conn.Datasets(ctx).Next()
+	if err == iterator.Done {
+		return nil
+	}
+	return err
+}
@@ -24,11 +24,23 @@ func (s *BigQueryServer) jsonTypeToBQType(jsonType string) bigquery.FieldType {
 		return bigquery.BooleanFieldType
 	case "float":
 		return bigquery.FloatFieldType
+	case "timestamp":
+		return bigquery.TimestampFieldType
+	case "date":
+		return bigquery.DateFieldType
 	case "string":
 		return bigquery.StringFieldType
-	default:
-		return bigquery.StringFieldType
 	}
+
+	// NUMERIC holds 29 digits before the point and 9 after
+	if precision, scale, ok := util.ParseDecimalType(jsonType); ok {
+		if scale <= 9 && precision-scale <= 29 {
+			return bigquery.FieldType(fmt.Sprintf("NUMERIC(%d, %d)", precision, scale))
+		}
+		return bigquery.FieldType(fmt.Sprintf("BIGNUMERIC(%d, %d)", precision, scale))
+	}
+
+	return bigquery.StringFieldType
 }
 
 func (s *BigQueryServer) CreateEmptyTable(name string) error {
@@ -79,6 +91,10 @@ func (s *BigQueryServer) createColumns(table string, jsonTypes map[string]string
 	return nil
 }
 
+func (s *BigQueryServer) CreateColumnsWithTypes(table string, jsonTypes map[string]string) error {
+	return s.createColumns(table, jsonTypes)
+}
+
 func (s *BigQueryServer) CreateColumns(table string, fileName string) error {
 	input, err := os.Open(fileName)
 	if err != nil {
@@ -106,7 +122,37 @@ func (s *BigQueryServer) CreateColumns(table string, fileName string) error {
 	return nil
 }
 
-func (s *BigQueryServer) UploadAndStream(table string, filePath string) error {
+// UploadAndStream loads a file through GCS. Columns are loaded with their types
+// from knownTypes, or with types inferred from the file.
+func (s *BigQueryServer) UploadAndStream(table string, filePath string, knownTypes map[string]string) error {
+	return s.uploadAndLoad(table, filePath, func(gcsFilePath string) error {
+		input, err := os.Open(filePath)
+		if err != nil {
+			log.Error().Err(err).Str("filename", filePath).Msg("Upload And Stream: Unable to open file")
+			return err
+		}
+		// Infer JSON types for the input
+		jsonTypes, err := util.GetJSONTypes(input)
+		input.Close()
+		if err != nil {
+			log.Error().Err(err).Str("filename", filePath).Msg("Upload And Stream: Unable to infer JSON types")
+			return err
+		}
+
+		// Typed columns, such as timestamps from a Parquet upload, look like strings
+		// in NDJSON. Loading them as strings would conflict with the table.
+		for name := range jsonTypes {
+			if jsonType, ok := knownTypes[name]; ok {
+				jsonTypes[name] = jsonType
+			}
+		}
+
+		return s.streamDataToBigQuery(table, gcsFilePath, jsonTypes, "format = 'JSON'")
+	})
+}
+
+// uploadAndLoad uploads a file to GCS, and calls load with its path there
+func (s *BigQueryServer) uploadAndLoad(table string, filePath string, load func(gcsFilePath string) error) error {
 	client, err := gcs.NewStorage(map[string]any{
 		"bucket":           s.GCSBucketName,
 		"credentials_json": s.CredentialsJsonString,
@@ -130,20 +176,8 @@ func (s *BigQueryServer) UploadAndStream(table string, filePath string) error {
 	}
 	log.Info().Str("gcs_file", gcsFilePath).Msg("Uploaded file to GCS")
 
-	input, err := os.Open(filePath)
-	if err != nil {
-		log.Error().Err(err).Str("filename", filePath).Msg("Upload And Stream: Unable to open file")
-		return err
-	}
-	// Infer JSON types for the input
-	jsonTypes, err := util.GetJSONTypes(input)
-	if err != nil {
-		log.Error().Err(err).Str("filename", filePath).Msg("Upload And Stream: Unable to infer JSON types")
-		return err
-	}
-
 	log.Info().Msg("Streaming data to BigQuery")
-	err = s.streamDataToBigQuery(table, gcsFilePath, jsonTypes)
+	err = load(gcsFilePath)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to stream data to BigQuery")
 		return err
@@ -178,7 +212,10 @@ func (s *BigQueryServer) uploadFileToGCS(filePath string, gcsFilePath string, cl
 	return nil
 }
 
-func (s *BigQueryServer) streamDataToBigQuery(table string, gcsFilePath string, jsonTypes map[string]string) error {
+// streamDataToBigQuery runs LOAD DATA for a file in GCS with the given FROM
+// FILES options. Columns are listed with their types unless jsonTypes is empty,
+// such as for a parquet file which has its own schema.
+func (s *BigQueryServer) streamDataToBigQuery(table string, gcsFilePath string, jsonTypes map[string]string, fileOptions string) error {
 
 	location := fmt.Sprintf("gs://%s/%s", s.GCSBucketName, gcsFilePath)
 
@@ -197,8 +234,11 @@ func (s *BigQueryServer) streamDataToBigQuery(table string, gcsFilePath string,
 	}
 
 	columns += ")"
+	if len(jsonTypes) == 0 {
+		columns = ""
+	}
 
-	query := fmt.Sprintf("LOAD DATA INTO %s %s FROM FILES ( format = 'JSON', uris = ['%s'] )", table, columns, location)
+	query := fmt.Sprintf("LOAD DATA INTO %s %s FROM FILES ( %s, uris = ['%s'] )", table, columns, fileOptions, location)
 	_, err := s.conn.Query(query).Read(ctx)
 	if err != nil {
 		log.Error().Err(err).Str("query", query).Msg("StreamDataToBigQuery: failed to stream data to BigQuery")
@@ -210,11 +250,39 @@ func (s *BigQueryServer) streamDataToBigQuery(table string, gcsFilePath string,
 	return nil
 }
 
-func (s *BigQueryServer) InsertFromNDJsonFile(table string, filePath string) error {
-	err := s.UploadAndStream(table, filePath)
+func (s *BigQueryServer) InsertFromNDJsonFile(table string, filePath string, jsonTypes map[string]string) error {
+	err := s.UploadAndStream(table, filePath, jsonTypes)
 	if err != nil {
 		log.Error().Err(err).Str("table", table).Str("file", filePath).Msg("Failed to upload and stream data to BigQuery")
 		return err
 	}
 	return nil
 }
+
+// InsertsStagingFormat is true for gzip and parquet, which LOAD DATA reads
+// directly. It doesn't support zstd.
+func (s *BigQueryServer) InsertsStagingFormat(format string) bool {
+	return format == util.StagingGzip || format == util.StagingParquet
+}
+
+func (s *BigQueryServer) InsertFromStagedFile(table string, filePath string, format string, jsonTypes map[string]string) error {
+	var fileOptions string
+	switch format {
+	case util.StagingGzip:
+		fileOptions = "format = 'JSON', compression = 'GZIP'"
+	case util.StagingParquet:
+		// Parquet files have their own schema
+		fileOptions = "format = 'PARQUET'"
+		jsonTypes = nil
+	default:
+		return fmt.Errorf("%w %q", util.ErrUnsupportedFormat, format)
+	}
+
+	err := s.uploadAndLoad(table, filePath, func(gcsFilePath string) error {
+		return s.streamDataToBigQuery(table, gcsFilePath, jsonTypes, fileOptions)
+	})
+	if err != nil {
+		log.Error().Err(err).Str("table", table).Str("file", filePath).Msg("Failed to load staged file into BigQuery")
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/googleapi"
+)
+
+func (s *BigQueryServer) DropTable(table string) error {
+	query := fmt.Sprintf("DROP TABLE IF EXISTS `%s`", table)
+	_, err := s.conn.Query(query).Read(context.Background())
+	return err
+}
+
+// ReplaceTable atomically replaces the contents of table with staging, then
+// drops staging. CREATE OR REPLACE would drop the table's partitioning and
+// clustering, so a table which has either keeps its definition and only has its
+// rows replaced.
+func (s *BigQueryServer) ReplaceTable(table string, staging string) error {
+	ctx := context.Background()
+
+	target, err := s.tableRef(table)
+	if err != nil {
+		return err
+	}
+
+	meta, err := target.Metadata(ctx)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		meta = &bigquery.TableMetadata{}
+	} else if err != nil {
+		return err
+	}
+
+	if meta.TimePartitioning != nil || meta.RangePartitioning != nil || meta.Clustering != nil {
+		err = s.replaceRows(ctx, target, meta, table, staging)
+		if err != nil {
+			return err
+		}
+		return s.DropTable(staging)
+	}
+
+	query := fmt.Sprintf("CREATE OR REPLACE TABLE `%s` AS SELECT * FROM `%s`", table, staging)
+	_, err = s.conn.Query(query).Read(ctx)
+	if err != nil {
+		log.Error().Err(err).Str("query", query).Msg("ReplaceTable: failed to replace table")
+		return err
+	}
+
+	return s.DropTable(staging)
+}
+
+// replaceRows deletes the rows of table and inserts those of staging in one
+// transaction. Columns which are only in staging are added to table first.
+func (s *BigQueryServer) replaceRows(ctx context.Context, target *bigquery.Table, meta *bigquery.TableMetadata, table string, staging string) error {
+	source, err := s.tableRef(staging)
+	if err != nil {
+		return err
+	}
+
+	stagingMeta, err := source.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, field := range meta.Schema {
+		existing[field.Name] = true
+	}
+
+	schema := meta.Schema
+	quoted := make([]string, len(stagingMeta.Schema))
+	for i, field := range stagingMeta.Schema {
+		quoted[i] = fmt.Sprintf("`%s`", field.Name)
+		if !existing[field.Name] {
+			added := *field
+			added.Required = false
+			schema = append(schema, &added)
+		}
+	}
+
+	if len(schema) > len(meta.Schema) {
+		_, err = target.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema}, meta.ETag)
+		if err != nil {
+			log.Error().Err(err).Str("table", table).Msg("ReplaceTable: failed to add columns")
+			return err
+		}
+	}
+
+	columnList := strings.Join(quoted, ", ")
+	query := fmt.Sprintf(
+		"BEGIN TRANSACTION; "+
+			"DELETE FROM `%s` WHERE TRUE; "+
+			"INSERT INTO `%s` (%s) SELECT %s FROM `%s`; "+
+			"COMMIT TRANSACTION;",
+		table, table, columnList, columnList, staging,
+	)
+	_, err = s.conn.Query(query).Read(ctx)
+	if err != nil {
+		log.Error().Err(err).Str("query", query).Msg("ReplaceTable: failed to replace rows")
+		return err
+	}
+
+	return nil
+}
+
+// tableRef returns the table for a name in the format dataset.table
+func (s *BigQueryServer) tableRef(table string) (*bigquery.Table, error) {
+	tokens := strings.Split(table, ".")
+	if len(tokens) != 2 {
+		return nil, errors.New("Table should be in the format dataset.table")
+	}
+	return s.conn.Dataset(tokens[0]).Table(tokens[1]), nil
+}
+
+// MergeTable updates rows in table which have the same key as a row in staging
+// and inserts the rest, then drops staging
+func (s *BigQueryServer) MergeTable(table string, staging string, keyColumn string) error {
+	columns, err := s.Columns(staging)
+	if err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(columns))
+	updates := make([]string, len(columns))
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = fmt.Sprintf("`%s`", c.Name)
+		updates[i] = fmt.Sprintf("`%s` = S.`%s`", c.Name, c.Name)
+		values[i] = fmt.Sprintf("S.`%s`", c.Name)
+	}
+
+	query := fmt.Sprintf(
+		"MERGE `%s` T USING `%s` S ON T.`%s` = S.`%s` "+
+			"WHEN MATCHED THEN UPDATE SET %s "+
+			"WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)",
+		table, staging, keyColumn, keyColumn,
+		strings.Join(updates, ", "),
+		strings.Join(quoted, ", "),
+		strings.Join(values, ", "),
+	)
+	_, err = s.conn.Query(query).Read(context.Background())
+	if err != nil {
+		log.Error().Err(err).Str("query", query).Msg("MergeTable: failed to merge table")
+		return err
+	}
+
+	return s.DropTable(staging)
+}
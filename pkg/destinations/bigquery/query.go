@@ -9,6 +9,7 @@ import (
 
 	"cloud.google.com/go/bigquery"
 	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/util"
 	"google.golang.org/api/iterator"
 )
 
@@ -178,3 +179,76 @@ func (b *BigQueryServer) QueryCSV(query string, writer io.Writer) error {
 
 	return nil
 }
+
+// bigQueryColumnType maps a BigQuery field onto the column types used when
+// creating columns
+func bigQueryColumnType(fieldType bigquery.FieldType) string {
+	switch fieldType {
+	case bigquery.IntegerFieldType:
+		return "int"
+	case bigquery.FloatFieldType:
+		return "float"
+	case bigquery.BooleanFieldType:
+		return "bool"
+	case bigquery.TimestampFieldType:
+		return "timestamp"
+	case bigquery.DateFieldType:
+		return "date"
+	case bigquery.NumericFieldType:
+		return util.DecimalType(38, 9)
+	case bigquery.BigNumericFieldType:
+		return util.DecimalType(76, 38)
+	}
+	return "string"
+}
+
+func (b *BigQueryServer) QueryFormat(query string, format string, writer io.Writer) error {
+	// NOTE: Query should be with dataset as prefix. Example: SELECT * FROM `dataset.table`
+
+	ctx := context.TODO()
+	itr, err := b.conn.Query(query).Read(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("error getting query iterator")
+		return err
+	}
+
+	// The schema is only known once the first row has been read
+	var row []bigquery.Value
+	err = itr.Next(&row)
+	if err != nil && err != iterator.Done {
+		return err
+	}
+
+	columns := make([]util.ResultColumn, len(itr.Schema))
+	for i, field := range itr.Schema {
+		columns[i] = util.ResultColumn{Name: field.Name, Type: bigQueryColumnType(field.Type)}
+		if field.Repeated {
+			columns[i].Type = "string"
+		}
+	}
+
+	results, err := util.NewResultWriter(format, columns, writer)
+	if err != nil {
+		return err
+	}
+	defer results.Discard()
+
+	for err != iterator.Done {
+		values := make([]any, len(row))
+		for i, value := range row {
+			values[i] = value
+		}
+
+		if err := results.WriteRow(values); err != nil {
+			return err
+		}
+
+		row = nil
+		err = itr.Next(&row)
+		if err != nil && err != iterator.Done {
+			return err
+		}
+	}
+
+	return results.Close()
+}
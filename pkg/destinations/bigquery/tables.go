@@ -6,11 +6,27 @@ import (
 	"fmt"
 	"strings"
 
+	"cloud.google.com/go/bigquery"
 	"github.com/rs/zerolog/log"
 	"github.com/scratchdata/scratchdata/models"
 	"google.golang.org/api/iterator"
 )
 
+// bqToJSONType normalizes a BigQuery field type to one of the JSON types we use
+// when creating columns: int, float, bool or string
+func bqToJSONType(fieldType bigquery.FieldType) string {
+	switch fieldType {
+	case bigquery.IntegerFieldType:
+		return "int"
+	case bigquery.FloatFieldType, bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		return "float"
+	case bigquery.BooleanFieldType:
+		return "bool"
+	}
+
+	return "string"
+}
+
 func (b *BigQueryServer) Columns(table string) ([]models.Column, error) {
 	rc := []models.Column{}
 
@@ -31,8 +47,9 @@ func (b *BigQueryServer) Columns(table string) ([]models.Column, error) {
 
 	for _, field := range meta.Schema {
 		rc = append(rc, models.Column{
-			Name: field.Name,
-			Type: string(field.Type),
+			Name:     field.Name,
+			Type:     string(field.Type),
+			JSONType: bqToJSONType(field.Type),
 		})
 	}
 	return rc, nil
@@ -0,0 +1,28 @@
+package bigquery
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestBqToJSONType(t *testing.T) {
+	tests := []struct {
+		fieldType bigquery.FieldType
+		expected  string
+	}{
+		{bigquery.IntegerFieldType, "int"},
+		{bigquery.FloatFieldType, "float"},
+		{bigquery.NumericFieldType, "float"},
+		{bigquery.BigNumericFieldType, "float"},
+		{bigquery.BooleanFieldType, "bool"},
+		{bigquery.StringFieldType, "string"},
+		{bigquery.TimestampFieldType, "string"},
+	}
+
+	for _, test := range tests {
+		if got := bqToJSONType(test.fieldType); got != test.expected {
+			t.Fatalf("Expected %s for %s; Got %s", test.expected, test.fieldType, got)
+		}
+	}
+}
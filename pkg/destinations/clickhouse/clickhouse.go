@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/scratchdata/scratchdata/pkg/util"
@@ -88,6 +89,10 @@ func (s *ClickhouseServer) Close() error {
 	return s.conn.Close()
 }
 
+func (s *ClickhouseServer) Ping(ctx context.Context) error {
+	return s.conn.Ping(ctx)
+}
+
 func (s *ClickhouseServer) httpQuery(query string) (io.ReadCloser, error) {
 	url := fmt.Sprintf("%s://%s:%d", s.HTTPProtocol, s.Host, s.HTTPPort)
 
@@ -123,6 +128,40 @@ func (s *ClickhouseServer) httpQuery(query string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+// httpInsert runs an INSERT query over HTTP with body as its data. Set
+// contentEncoding if the body is compressed.
+func (s *ClickhouseServer) httpInsert(query string, body io.Reader, contentEncoding string) error {
+	insertURL := fmt.Sprintf("%s://%s:%d/?query=%s", s.HTTPProtocol, s.Host, s.HTTPPort, url.QueryEscape(query))
+
+	req, err := http.NewRequest("POST", insertURL, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Clickhouse-User", s.Username)
+	req.Header.Set("X-Clickhouse-Key", s.Password)
+	req.Header.Set("X-Clickhouse-Database", s.Database)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		errMsg, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(errMsg))
+	}
+
+	return nil
+}
+
 func OpenServer(settings map[string]any) (*ClickhouseServer, error) {
 	srv := util.ConfigToStruct[ClickhouseServer](settings)
 	conn, err := openConn(srv)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/util"
 	"os"
 )
 
@@ -42,7 +43,11 @@ func (s *ClickhouseServer) CreateColumns(table string, filePath string) error {
 	return nil
 }
 
-func (s *ClickhouseServer) InsertFromNDJsonFile(table string, filePath string) error {
+func (s *ClickhouseServer) CreateColumnsWithTypes(table string, jsonTypes map[string]string) error {
+	return s.createColumnsWithTypes(table, jsonTypes)
+}
+
+func (s *ClickhouseServer) InsertFromNDJsonFile(table string, filePath string, jsonTypes map[string]string) error {
 	input, err := os.Open(filePath)
 	if err != nil {
 		return err
@@ -63,3 +68,39 @@ func (s *ClickhouseServer) InsertFromNDJsonFile(table string, filePath string) e
 
 	return nil
 }
+
+func (s *ClickhouseServer) InsertsStagingFormat(format string) bool {
+	switch format {
+	case util.StagingGzip, util.StagingZstd, util.StagingParquet:
+		return true
+	}
+	return false
+}
+
+// InsertFromStagedFile sends a gzip or zstd NDJSON file, or a parquet file, to
+// ClickHouse as it is over HTTP
+func (s *ClickhouseServer) InsertFromStagedFile(table string, filePath string, format string, jsonTypes map[string]string) error {
+	input, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	query := fmt.Sprintf(`INSERT INTO "%s"."%s" FORMAT JSONEachRow`, s.Database, table)
+
+	switch format {
+	case util.StagingGzip:
+		err = s.httpInsert(query, input, "gzip")
+	case util.StagingZstd:
+		err = s.httpInsert(query, input, "zstd")
+	case util.StagingParquet:
+		query = fmt.Sprintf(`INSERT INTO "%s"."%s" FORMAT Parquet`, s.Database, table)
+		err = s.httpInsert(query, input, "")
+	default:
+		err = fmt.Errorf("%w %q", util.ErrUnsupportedFormat, format)
+	}
+	if err != nil {
+		log.Err(err).Str("format", format).Msg("Failed to insert staged file")
+	}
+	return err
+}
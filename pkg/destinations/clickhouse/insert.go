@@ -6,91 +6,17 @@ import (
 	"fmt"
 	"io"
 	"math/big"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/util"
 	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 )
 
 func (s *ClickhouseServer) inferColumnTypes(file io.ReadSeeker) (map[string]string, error) {
-	rc := map[string]string{}
-
-	typeCounts := map[string]map[string]int{}
-
-	_, err := file.Seek(0, io.SeekStart)
-	if err != nil {
-		return rc, err
-	}
-
-	scanner := bufio.NewScanner(file)
-	maxCapacity := 100_000_000
-	buf := make([]byte, maxCapacity)
-	scanner.Buffer(buf, maxCapacity)
-
-	for scanner.Scan() {
-		parsed := gjson.ParseBytes(scanner.Bytes())
-
-		parsed.ForEach(func(key, value gjson.Result) bool {
-			k := key.String()
-			_, ok := typeCounts[k]
-			if !ok {
-				typeCounts[k] = map[string]int{}
-			}
-			switch value.Type {
-			case gjson.String:
-				typeCounts[k]["string"] += 1
-			case gjson.Null:
-				typeCounts[k]["null"] += 1
-			case gjson.False:
-				typeCounts[k]["bool"] += 1
-			case gjson.True:
-				typeCounts[k]["bool"] += 1
-			case gjson.Number:
-				_, intErr := strconv.Atoi(value.Raw)
-				if intErr != nil {
-					typeCounts[k]["float"] += 1
-				} else {
-					typeCounts[k]["int"] += 1
-				}
-			default:
-				typeCounts[k]["undefined"] += 1
-			}
-			return true
-		})
-	}
-
-	log.Trace().Interface("column_type_counts", typeCounts).Send()
-
-	if err := scanner.Err(); err != nil {
-		return rc, err
-	}
-
-	for colName, colTypeCounts := range typeCounts {
-		if colTypeCounts["string"] > 0 {
-			rc[colName] = "string"
-			continue
-		} else if colTypeCounts["undefined"] > 0 {
-			rc[colName] = "string"
-			continue
-		} else if colTypeCounts["float"] > 0 {
-			rc[colName] = "float"
-			continue
-		} else if colTypeCounts["int"] > 0 {
-			rc[colName] = "int"
-			continue
-		} else if colTypeCounts["bool"] > 0 {
-			rc[colName] = "bool"
-		} else {
-			rc[colName] = "string"
-		}
-
-	}
-
-	log.Trace().Interface("column_types", rc).Send()
-
-	return rc, nil
+	return util.GetJSONTypes(file)
 }
 
 func (s *ClickhouseServer) createColumnsWithTypes(table string, columns map[string]string) error {
@@ -105,10 +31,17 @@ func (s *ClickhouseServer) createColumnsWithTypes(table string, columns map[stri
 			colType = "Boolean"
 		case "float":
 			colType = "Float64"
+		case "timestamp":
+			colType = "DateTime64(6, 'UTC')"
+		case "date":
+			colType = "Date32"
 		case "string":
 			colType = "String"
 		default:
 			colType = "String"
+			if precision, scale, ok := util.ParseDecimalType(jsonType); ok {
+				colType = fmt.Sprintf("Decimal(%d, %d)", precision, scale)
+			}
 		}
 		columnSql = append(columnSql, fmt.Sprintf(`ADD COLUMN IF NOT EXISTS "%s" %s`, colName, colType))
 	}
@@ -146,10 +79,19 @@ func (s *ClickhouseServer) getClickhouseTypes(table string) (map[string]string,
 }
 
 func (s *ClickhouseServer) jsonToGoType(clickhouseType string, data gjson.Result) any {
+	// Match on the type name without parameters, such as Decimal(10, 2)
+	if i := strings.Index(clickhouseType, "("); i >= 0 {
+		clickhouseType = clickhouseType[:i]
+	}
+
 	switch clickhouseType {
 	case "String", "FixedString":
 		return data.String()
 	case "Decimal":
+		// Decimals may be sent as strings so that they keep their precision
+		if d, err := decimal.NewFromString(data.String()); err == nil {
+			return d
+		}
 		return decimal.NewFromFloat(data.Float())
 	case "Bool":
 		return data.Bool()
@@ -184,10 +126,15 @@ func (s *ClickhouseServer) jsonToGoType(clickhouseType string, data gjson.Result
 	case "UUID":
 		return data.String()
 	case "Date", "Date32":
+		if t, err := time.Parse(time.DateOnly, data.String()); err == nil {
+			return t
+		}
 		return data.String()
 	case "DateTime", "DateTime64":
 		if data.Type == gjson.Number {
 			return data.Int()
+		} else if t, err := time.Parse(time.RFC3339Nano, data.String()); err == nil {
+			return t
 		} else {
 			return data.String()
 		}
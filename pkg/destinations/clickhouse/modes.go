@@ -0,0 +1,70 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func (s *ClickhouseServer) DropTable(table string) error {
+	sql := fmt.Sprintf(`DROP TABLE IF EXISTS "%s"."%s"`, s.Database, table)
+	return s.conn.Exec(context.TODO(), sql)
+}
+
+// ReplaceTable atomically exchanges staging with table, then drops the old data
+func (s *ClickhouseServer) ReplaceTable(table string, staging string) error {
+	ctx := context.TODO()
+
+	// EXCHANGE needs both tables to exist
+	err := s.CreateEmptyTable(table)
+	if err != nil {
+		return err
+	}
+
+	sql := fmt.Sprintf(`EXCHANGE TABLES "%s"."%s" AND "%s"."%s"`, s.Database, table, s.Database, staging)
+	err = s.conn.Exec(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	return s.DropTable(staging)
+}
+
+// MergeTable deletes rows in table which have the same key as a row in staging,
+// then inserts everything from staging. ClickHouse has no transactions, so readers
+// may briefly see the table with the old rows deleted and the new ones not yet inserted.
+func (s *ClickhouseServer) MergeTable(table string, staging string, keyColumn string) error {
+	ctx := context.TODO()
+
+	columns, err := s.Columns(staging)
+	if err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = fmt.Sprintf(`"%s"`, c.Name)
+	}
+	columnList := strings.Join(quoted, ", ")
+
+	// Wait for the mutation to finish so that the insert isn't deleted along with the old rows
+	sql := fmt.Sprintf(
+		`ALTER TABLE "%s"."%s" DELETE WHERE "%s" IN (SELECT "%s" FROM "%s"."%s") SETTINGS mutations_sync = 2`,
+		s.Database, table, keyColumn, keyColumn, s.Database, staging,
+	)
+	err = s.conn.Exec(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	sql = fmt.Sprintf(
+		`INSERT INTO "%s"."%s" (%s) SELECT %s FROM "%s"."%s"`,
+		s.Database, table, columnList, columnList, s.Database, staging,
+	)
+	err = s.conn.Exec(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	return s.DropTable(staging)
+}
@@ -2,8 +2,10 @@ package clickhouse
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 
+	"github.com/apache/arrow/go/v14/arrow/ipc"
 	"github.com/scratchdata/scratchdata/pkg/util"
 )
 
@@ -83,3 +85,38 @@ func (s *ClickhouseServer) QueryCSV(query string, writer io.Writer) error {
 
 	return err
 }
+
+func (s *ClickhouseServer) QueryFormat(query string, format string, writer io.Writer) error {
+	var clickhouseFormat string
+	switch format {
+	case util.FormatParquet:
+		clickhouseFormat = "Parquet"
+	case util.FormatArrow, util.FormatXLSX:
+		clickhouseFormat = "ArrowStream"
+	default:
+		return fmt.Errorf("%w %q", util.ErrUnsupportedFormat, format)
+	}
+
+	sanitized := util.TrimQuery(query)
+	sql := "SELECT * FROM (" + sanitized + ") FORMAT " + clickhouseFormat
+
+	resp, err := s.httpQuery(sql)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	if format != util.FormatXLSX {
+		_, err = io.Copy(writer, resp)
+		return err
+	}
+
+	// ClickHouse can't write spreadsheets, so convert its Arrow output
+	reader, err := ipc.NewReader(resp)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+
+	return util.WriteArrowRecords(reader, format, writer)
+}
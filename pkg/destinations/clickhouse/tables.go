@@ -1,15 +1,109 @@
 package clickhouse
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"strings"
 
 	"github.com/scratchdata/scratchdata/models"
 )
 
-func (b *ClickhouseServer) Columns(table string) ([]models.Column, error) {
-	return []models.Column{}, errors.New("not implemented")
+// clickhouseToJSONType normalizes a ClickHouse column type to one of the JSON
+// types we use when creating columns: int, float, bool or string
+func clickhouseToJSONType(clickhouseType string) string {
+	t := unwrapClickhouseType(clickhouseType)
+
+	switch {
+	case isSizedType(t, "Int"), isSizedType(t, "UInt"):
+		return "int"
+	case isSizedType(t, "Float"), strings.HasPrefix(t, "Decimal"):
+		return "float"
+	case t == "Bool", t == "Boolean":
+		return "bool"
+	}
+
+	return "string"
+}
+
+// unwrapClickhouseType removes Nullable and LowCardinality wrappers, which can
+// be nested in either order
+func unwrapClickhouseType(t string) string {
+	for {
+		unwrapped := false
+		for _, wrapper := range []string{"Nullable(", "LowCardinality("} {
+			if strings.HasPrefix(t, wrapper) && strings.HasSuffix(t, ")") {
+				t = t[len(wrapper) : len(t)-1]
+				unwrapped = true
+			}
+		}
+		if !unwrapped {
+			return t
+		}
+	}
+}
+
+// isSizedType returns whether t is a type name followed by its size in bits,
+// such as Int64. This doesn't match IntervalDay.
+func isSizedType(t string, name string) bool {
+	size, ok := strings.CutPrefix(t, name)
+	return ok && size != "" && strings.Trim(size, "0123456789") == ""
+}
+
+func (s *ClickhouseServer) Columns(table string) ([]models.Column, error) {
+	rc := []models.Column{}
+
+	sql := "SELECT name, type FROM system.columns WHERE database = ? AND table = ? ORDER BY position"
+	rows, err := s.conn.Query(context.TODO(), sql, s.Database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, colType string
+		if err := rows.Scan(&name, &colType); err != nil {
+			return nil, err
+		}
+
+		rc = append(rc, models.Column{
+			Name:     name,
+			Type:     colType,
+			JSONType: clickhouseToJSONType(colType),
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(rc) == 0 {
+		return nil, fmt.Errorf("table not found: %s", table)
+	}
+
+	return rc, nil
 }
 
-func (b *ClickhouseServer) Tables() ([]string, error) {
-	return []string{}, errors.New("not implemented")
+func (s *ClickhouseServer) Tables() ([]string, error) {
+	rc := []string{}
+
+	sql := "SELECT name FROM system.tables WHERE database = ? ORDER BY name"
+	rows, err := s.conn.Query(context.TODO(), sql, s.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		rc = append(rc, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rc, nil
 }
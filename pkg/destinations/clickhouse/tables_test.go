@@ -0,0 +1,29 @@
+package clickhouse
+
+import "testing"
+
+func TestClickhouseToJSONType(t *testing.T) {
+	tests := []struct {
+		clickhouseType string
+		expected       string
+	}{
+		{"Int64", "int"},
+		{"UInt8", "int"},
+		{"Nullable(Int32)", "int"},
+		{"LowCardinality(Nullable(Int64))", "int"},
+		{"Nullable(LowCardinality(String))", "string"},
+		{"Float64", "float"},
+		{"Decimal(10, 2)", "float"},
+		{"Bool", "bool"},
+		{"IntervalDay", "string"},
+		{"Int", "string"},
+		{"DateTime64(3)", "string"},
+		{"String", "string"},
+	}
+
+	for _, test := range tests {
+		if got := clickhouseToJSONType(test.clickhouseType); got != test.expected {
+			t.Fatalf("Expected %s for %s; Got %s", test.expected, test.clickhouseType, got)
+		}
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"io"
+	"sync"
 
 	"github.com/scratchdata/scratchdata/models"
 	"github.com/scratchdata/scratchdata/pkg/config"
 	"github.com/scratchdata/scratchdata/pkg/storage"
+	"github.com/scratchdata/scratchdata/pkg/util"
 
 	"github.com/EagleChen/mapmutex"
 	"github.com/rs/zerolog/log"
@@ -22,6 +24,10 @@ type DestinationManager struct {
 	storage *storage.Services
 	pool    map[int64]Destination
 	mux     *mapmutex.Mutex
+
+	// poolLock guards pool, which is read by PingAll while destinations are
+	// being opened
+	poolLock sync.RWMutex
 }
 
 type Destination interface {
@@ -29,16 +35,63 @@ type Destination interface {
 	QueryJSON(query string, writer io.Writer) error
 	QueryCSV(query string, writer io.Writer) error
 
+	// QueryFormat writes query results as parquet, arrow or xlsx, keeping the
+	// types of the result columns
+	QueryFormat(query string, format string, writer io.Writer) error
+
 	Tables() ([]string, error)
 	Columns(table string) ([]models.Column, error)
 
 	CreateEmptyTable(name string) error
 	CreateColumns(table string, filePath string) error
-	InsertFromNDJsonFile(table string, filePath string) error
+
+	// CreateColumnsWithTypes adds any missing columns, given JSON types as
+	// returned by util.GetJSONTypes
+	CreateColumnsWithTypes(table string, jsonTypes map[string]string) error
+
+	// InsertFromNDJsonFile loads a file of rows into table. jsonTypes are the
+	// types of the file's columns when they are known, such as for Parquet
+	// uploads, and nil otherwise.
+	InsertFromNDJsonFile(table string, filePath string, jsonTypes map[string]string) error
+
+	// ReplaceTable atomically swaps the contents of table for staging. Staging no
+	// longer exists afterwards.
+	ReplaceTable(table string, staging string) error
+
+	// MergeTable upserts the rows in staging into table, matching rows on keyColumn.
+	// Staging is dropped afterwards.
+	MergeTable(table string, staging string, keyColumn string) error
+
+	DropTable(table string) error
+
+	// Ping checks that the destination can be reached
+	Ping(ctx context.Context) error
 
 	Close() error
 }
 
+// StagedFileInserter is implemented by destinations which can load some staging
+// formats, such as gzip or parquet, without the file being converted to NDJSON
+// first. Columns are created before InsertFromStagedFile is called.
+type StagedFileInserter interface {
+	InsertsStagingFormat(format string) bool
+	InsertFromStagedFile(table string, filePath string, format string, jsonTypes map[string]string) error
+}
+
+// Options are settings which apply to every destination type. They are read
+// from the same settings map as the connection details.
+type Options struct {
+	// Maximum number of messages for this destination which may be processed at
+	// once, across all workers. 0 means no limit.
+	MaxInFlight int `mapstructure:"max_in_flight"`
+
+	// Number of chunks of a copy which are loaded at once. 0 uses the default.
+	LoadParallelism int `mapstructure:"load_parallelism"`
+
+	// System columns, such as ingested_at, added to every inserted row
+	SystemColumns []string `mapstructure:"system_columns"`
+}
+
 func NewDestinationManager(storage *storage.Services) *DestinationManager {
 	mux := mapmutex.NewMapMutex()
 	rc := DestinationManager{
@@ -60,6 +113,67 @@ func (m *DestinationManager) CloseAll() {
 	}
 }
 
+// Maximum number of destinations PingAll checks at once
+const pingConcurrency = 10
+
+// PingAll checks that every configured destination can be reached, opening
+// destinations this process hasn't connected to yet. It returns the result for
+// each destination ID.
+func (m *DestinationManager) PingAll(ctx context.Context) (map[int64]error, error) {
+	ids, err := m.storage.Database.DestinationIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the pool so that Destination() isn't blocked while pinging
+	m.poolLock.RLock()
+	pool := make(map[int64]Destination, len(m.pool))
+	for id, dest := range m.pool {
+		pool[id] = dest
+	}
+	m.poolLock.RUnlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := map[int64]error{}
+	sem := make(chan struct{}, pingConcurrency)
+
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+
+			var err error
+			select {
+			case sem <- struct{}{}:
+				err = m.ping(ctx, id, pool[id])
+				<-sem
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			mu.Lock()
+			results[id] = err
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// ping checks a single destination, opening it first if it isn't pooled
+func (m *DestinationManager) ping(ctx context.Context, id int64, dest Destination) error {
+	if dest == nil {
+		var err error
+		dest, err = m.Destination(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+	return dest.Ping(ctx)
+}
+
 func (m *DestinationManager) TestCredentials(creds config.Destination) error {
 	var dest Destination
 	var err error
@@ -86,6 +200,15 @@ func (m *DestinationManager) TestCredentials(creds config.Destination) error {
 	return nil
 }
 
+func (m *DestinationManager) Options(ctx context.Context, databaseID int64) (Options, error) {
+	creds, err := m.storage.Database.GetDestinationCredentials(ctx, databaseID)
+	if err != nil {
+		return Options{}, err
+	}
+
+	return *util.ConfigToStruct[Options](creds.Settings.Data()), nil
+}
+
 func (m *DestinationManager) Destination(ctx context.Context, databaseID int64) (Destination, error) {
 
 	if m.mux.TryLock(databaseID) {
@@ -93,7 +216,9 @@ func (m *DestinationManager) Destination(ctx context.Context, databaseID int64)
 
 		var dest Destination
 
+		m.poolLock.RLock()
 		dest, ok := m.pool[databaseID]
+		m.poolLock.RUnlock()
 		if ok {
 			return dest, nil
 		}
@@ -122,7 +247,9 @@ func (m *DestinationManager) Destination(ctx context.Context, databaseID int64)
 		}
 
 		if dest != nil {
+			m.poolLock.Lock()
 			m.pool[databaseID] = dest
+			m.poolLock.Unlock()
 			return dest, nil
 		} else {
 			return nil, errors.New("Unrecognized destination type " + creds.Type)
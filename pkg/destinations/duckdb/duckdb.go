@@ -36,11 +36,32 @@ func (s *DuckDBServer) Close() error {
 	return s.db.Close()
 }
 
+func (s *DuckDBServer) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 var jsonToDuck = map[string]string{
-	"string": "STRING",
-	"int":    "BIGINT",
-	"float":  "DOUBLE",
-	"bool":   "BOOLEAN",
+	"string":    "STRING",
+	"int":       "BIGINT",
+	"float":     "DOUBLE",
+	"bool":      "BOOLEAN",
+	"timestamp": "TIMESTAMP",
+	"date":      "DATE",
+}
+
+func duckType(jsonType string) string {
+	// DuckDB decimals are limited to 38 digits
+	if precision, scale, ok := util.ParseDecimalType(jsonType); ok {
+		if precision > 38 {
+			return "VARCHAR"
+		}
+		return fmt.Sprintf("DECIMAL(%d,%d)", precision, scale)
+	}
+
+	if colType, ok := jsonToDuck[jsonType]; ok {
+		return colType
+	}
+	return "VARCHAR"
 }
 
 func openDB(s *DuckDBServer) (*sql.DB, error) {
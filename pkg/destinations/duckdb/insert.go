@@ -13,7 +13,7 @@ func (s *DuckDBServer) createColumns(table string, jsonTypes map[string]string)
 	for colName, jsonType := range jsonTypes {
 
 		// TODO: Should we specify defaults, or use null as default?
-		sql := fmt.Sprintf("ALTER TABLE \"%s\" ADD COLUMN IF NOT EXISTS \"%s\" %s", table, colName, jsonToDuck[jsonType])
+		sql := fmt.Sprintf("ALTER TABLE \"%s\" ADD COLUMN IF NOT EXISTS \"%s\" %s", table, colName, duckType(jsonType))
 		_, err := s.db.Exec(sql)
 		if err != nil {
 			return err
@@ -54,13 +54,18 @@ func (s *DuckDBServer) describeTable(table string) ([]string, map[string]string,
 }
 
 func (s *DuckDBServer) insertFromLocal(table string, localPath string) error {
+	return s.insertFrom(table, fmt.Sprintf("read_ndjson_auto('%s')", localPath))
+}
+
+// insertFrom inserts the rows from a table function, such as read_parquet
+func (s *DuckDBServer) insertFrom(table string, source string) error {
 	sql := fmt.Sprintf(`
 		INSERT INTO "%s" 
 		BY NAME
 		SELECT * FROM
-		read_ndjson_auto('%s')
+		%s
 		`,
-		table, localPath,
+		table, source,
 	)
 
 	log.Trace().Str("sql", sql).Msg("Insert data SQL")
@@ -75,6 +80,10 @@ func (s *DuckDBServer) CreateEmptyTable(table string) error {
 	return err
 }
 
+func (s *DuckDBServer) CreateColumnsWithTypes(table string, jsonTypes map[string]string) error {
+	return s.createColumns(table, jsonTypes)
+}
+
 func (s *DuckDBServer) CreateColumns(table string, fileName string) error {
 	input, err := os.Open(fileName)
 	if err != nil {
@@ -99,7 +108,7 @@ func (s *DuckDBServer) CreateColumns(table string, fileName string) error {
 	return nil
 }
 
-func (s *DuckDBServer) InsertFromNDJsonFile(table string, fileName string) error {
+func (s *DuckDBServer) InsertFromNDJsonFile(table string, fileName string, jsonTypes map[string]string) error {
 	absoluteFile, err := filepath.Abs(fileName)
 	if err != nil {
 		return err
@@ -108,3 +117,30 @@ func (s *DuckDBServer) InsertFromNDJsonFile(table string, fileName string) error
 	err = s.insertFromLocal(table, absoluteFile)
 	return err
 }
+
+func (s *DuckDBServer) InsertsStagingFormat(format string) bool {
+	switch format {
+	case util.StagingGzip, util.StagingZstd, util.StagingParquet:
+		return true
+	}
+	return false
+}
+
+// InsertFromStagedFile loads a gzip or zstd NDJSON file, or a parquet file,
+// without converting it first
+func (s *DuckDBServer) InsertFromStagedFile(table string, fileName string, format string, jsonTypes map[string]string) error {
+	absoluteFile, err := filepath.Abs(fileName)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case util.StagingGzip:
+		return s.insertFrom(table, fmt.Sprintf("read_ndjson_auto('%s', compression='gzip')", absoluteFile))
+	case util.StagingZstd:
+		return s.insertFrom(table, fmt.Sprintf("read_ndjson_auto('%s', compression='zstd')", absoluteFile))
+	case util.StagingParquet:
+		return s.insertFrom(table, fmt.Sprintf("read_parquet('%s')", absoluteFile))
+	}
+	return fmt.Errorf("%w %q", util.ErrUnsupportedFormat, format)
+}
@@ -0,0 +1,46 @@
+package duckdb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scratchdata/scratchdata/pkg/util"
+)
+
+func TestInsertFromStagedFile(t *testing.T) {
+	s := newTestServer(t, `CREATE TABLE events (__row_id BIGINT)`)
+
+	rows := "{\"__row_id\":1,\"user\":\"alice\",\"age\":30}\n{\"__row_id\":2,\"user\":\"bob\",\"age\":null}\n"
+	path := filepath.Join(t.TempDir(), "staged.parquet")
+	staged, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = util.EncodeStaging(util.StagingParquet, strings.NewReader(rows), staged)
+	staged.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns, _, err := util.InspectStaging(util.StagingParquet, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateColumnsWithTypes("events", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.InsertsStagingFormat(util.StagingParquet) || s.InsertsStagingFormat(util.StagingNDJSON) {
+		t.Fatalf("Expected parquet files to be loaded natively")
+	}
+	if err := s.InsertFromStagedFile("events", path, util.StagingParquet, columns); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "1,alice,30\n2,bob,<nil>"
+	if got := queryRows(t, s, `SELECT __row_id, "user", age FROM events ORDER BY __row_id`); got != expected {
+		t.Fatalf("Expected %q; Got %q", expected, got)
+	}
+}
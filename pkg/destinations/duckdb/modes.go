@@ -0,0 +1,43 @@
+package duckdb
+
+import "github.com/scratchdata/scratchdata/pkg/destinations/sqlmodes"
+
+// currentSchema returns the schema which tables are created in. It isn't always
+// main, such as for MotherDuck or attached databases.
+func (s *DuckDBServer) currentSchema() (string, error) {
+	var schema string
+	err := s.db.QueryRow("SELECT current_schema()").Scan(&schema)
+	return schema, err
+}
+
+func (s *DuckDBServer) DropTable(table string) error {
+	schema, err := s.currentSchema()
+	if err != nil {
+		return err
+	}
+
+	return sqlmodes.DropTable(s.db, schema, table)
+}
+
+func (s *DuckDBServer) ReplaceTable(table string, staging string) error {
+	schema, err := s.currentSchema()
+	if err != nil {
+		return err
+	}
+
+	return sqlmodes.ReplaceTable(s.db, schema, table, staging)
+}
+
+func (s *DuckDBServer) MergeTable(table string, staging string, keyColumn string) error {
+	schema, err := s.currentSchema()
+	if err != nil {
+		return err
+	}
+
+	columns, err := s.Columns(staging)
+	if err != nil {
+		return err
+	}
+
+	return sqlmodes.MergeTable(s.db, schema, table, staging, keyColumn, columns)
+}
@@ -0,0 +1,122 @@
+package duckdb
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newTestServer opens an in-memory database without the extensions OpenServer
+// installs, which would need network access
+func newTestServer(t *testing.T, tables ...string) *DuckDBServer {
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		t.Fatalf("Unable to open DuckDB: %s", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	s := &DuckDBServer{InMemory: true, db: db}
+	t.Cleanup(func() { s.Close() })
+
+	for _, sql := range tables {
+		if _, err := s.db.Exec(sql); err != nil {
+			t.Fatalf("Unable to run %q: %s", sql, err)
+		}
+	}
+	return s
+}
+
+// queryRows returns each row of a query with its values separated by commas
+func queryRows(t *testing.T, s *DuckDBServer, query string) string {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		t.Fatalf("Unable to query: %s", err)
+	}
+	defer rows.Close()
+
+	columns, _ := rows.Columns()
+	var rc []string
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			t.Fatalf("Unable to scan: %s", err)
+		}
+
+		row := make([]string, len(values))
+		for i, v := range values {
+			row[i] = fmt.Sprint(v)
+		}
+		rc = append(rc, strings.Join(row, ","))
+	}
+	return strings.Join(rc, "\n")
+}
+
+func TestReplaceTable(t *testing.T) {
+	s := newTestServer(t,
+		`CREATE TABLE users (id BIGINT, name VARCHAR)`,
+		`INSERT INTO users VALUES (1, 'alice'), (2, 'bob')`,
+		`CREATE TABLE users__stage (id BIGINT, email VARCHAR)`,
+		`INSERT INTO users__stage VALUES (3, 'carol@example.com')`,
+	)
+
+	if err := s.ReplaceTable("users", "users__stage"); err != nil {
+		t.Fatalf("Unable to replace table: %s", err)
+	}
+
+	expected := "3,carol@example.com"
+	if rows := queryRows(t, s, "SELECT * FROM users"); rows != expected {
+		t.Fatalf("Expected %s; Got %s", expected, rows)
+	}
+
+	tables, _ := s.Tables()
+	if len(tables) != 1 {
+		t.Fatalf("Expected staging table to be gone; Got %v", tables)
+	}
+}
+
+func TestReplaceTableCurrentSchema(t *testing.T) {
+	s := newTestServer(t,
+		`CREATE SCHEMA other`,
+		`SET schema = 'other'`,
+		`CREATE TABLE users (id BIGINT)`,
+		`CREATE TABLE users__stage (id BIGINT)`,
+		`INSERT INTO users__stage VALUES (3)`,
+	)
+
+	if err := s.ReplaceTable("users", "users__stage"); err != nil {
+		t.Fatalf("Unable to replace table: %s", err)
+	}
+
+	if rows := queryRows(t, s, "SELECT * FROM other.users"); rows != "3" {
+		t.Fatalf("Expected the table in the current schema to be replaced; Got %s", rows)
+	}
+}
+
+func TestMergeTable(t *testing.T) {
+	s := newTestServer(t,
+		`CREATE TABLE users (id BIGINT, name VARCHAR, email VARCHAR)`,
+		`INSERT INTO users VALUES (1, 'alice', 'alice@example.com'), (2, 'bob', 'bob@example.com')`,
+		`CREATE TABLE users__stage (id BIGINT, name VARCHAR)`,
+		`INSERT INTO users__stage VALUES (2, 'robert'), (3, 'carol')`,
+	)
+
+	if err := s.MergeTable("users", "users__stage", "id"); err != nil {
+		t.Fatalf("Unable to merge table: %s", err)
+	}
+
+	// Upserted rows only have the columns which were copied
+	expected := "1,alice,alice@example.com\n2,robert,<nil>\n3,carol,<nil>"
+	if rows := queryRows(t, s, "SELECT * FROM users ORDER BY id"); rows != expected {
+		t.Fatalf("Expected %s; Got %s", expected, rows)
+	}
+
+	tables, _ := s.Tables()
+	if len(tables) != 1 {
+		t.Fatalf("Expected staging table to be dropped; Got %v", tables)
+	}
+}
@@ -2,6 +2,7 @@ package duckdb
 
 import (
 	"io"
+	"math/big"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/scratchdata/scratchdata/pkg/util"
 
+	"github.com/marcboeker/go-duckdb"
 	"github.com/rs/zerolog/log"
 )
 
@@ -214,3 +216,23 @@ func (s *DuckDBServer) QueryJSON(query string, writer io.Writer) error {
 func (s *DuckDBServer) QueryCSV(query string, writer io.Writer) error {
 	return s.QueryPipe(query, "csv", writer)
 }
+
+func (s *DuckDBServer) QueryFormat(query string, format string, writer io.Writer) error {
+	rows, err := s.db.Query(util.TrimQuery(query))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to execute query")
+		return err
+	}
+	defer rows.Close()
+
+	return util.WriteSQLRows(rows, format, writer, duckValue)
+}
+
+// duckValue converts DuckDB's decimals, which util can't read, into rationals
+func duckValue(value any) any {
+	if d, ok := value.(duckdb.Decimal); ok {
+		scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.Scale)), nil)
+		return new(big.Rat).SetFrac(d.Value, scale)
+	}
+	return value
+}
@@ -1,15 +1,71 @@
 package duckdb
 
 import (
-	"errors"
+	"strings"
 
 	"github.com/scratchdata/scratchdata/models"
 )
 
-func (b *DuckDBServer) Columns(table string) ([]models.Column, error) {
-	return []models.Column{}, errors.New("not implemented")
+// duckToJSONType normalizes a DuckDB column type to one of the JSON types
+// we use when creating columns: int, float, bool or string
+func duckToJSONType(duckType string) string {
+	t := strings.ToUpper(strings.TrimSpace(duckType))
+	if i := strings.Index(t, "("); i > -1 {
+		t = t[:i]
+	}
+
+	switch t {
+	case "TINYINT", "SMALLINT", "INTEGER", "BIGINT", "HUGEINT",
+		"UTINYINT", "USMALLINT", "UINTEGER", "UBIGINT", "UHUGEINT":
+		return "int"
+	case "FLOAT", "REAL", "DOUBLE", "DECIMAL", "NUMERIC":
+		return "float"
+	case "BOOLEAN", "BOOL":
+		return "bool"
+	}
+
+	return "string"
 }
 
-func (b *DuckDBServer) Tables() ([]string, error) {
-	return []string{}, errors.New("not implemented")
+func (s *DuckDBServer) Columns(table string) ([]models.Column, error) {
+	rc := []models.Column{}
+
+	names, types, err := s.describeTable(table)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		rc = append(rc, models.Column{
+			Name:     name,
+			Type:     types[name],
+			JSONType: duckToJSONType(types[name]),
+		})
+	}
+
+	return rc, nil
+}
+
+func (s *DuckDBServer) Tables() ([]string, error) {
+	rc := []string{}
+
+	rows, err := s.db.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() ORDER BY table_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		rc = append(rc, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rc, nil
 }
@@ -0,0 +1,26 @@
+package duckdb
+
+import "testing"
+
+func TestDuckToJSONType(t *testing.T) {
+	tests := []struct {
+		duckType string
+		expected string
+	}{
+		{"BIGINT", "int"},
+		{"ubigint", "int"},
+		{"HUGEINT", "int"},
+		{"DOUBLE", "float"},
+		{"DECIMAL(18,3)", "float"},
+		{"BOOLEAN", "bool"},
+		{"VARCHAR", "string"},
+		{"TIMESTAMP WITH TIME ZONE", "string"},
+		{"INTERVAL", "string"},
+	}
+
+	for _, test := range tests {
+		if got := duckToJSONType(test.duckType); got != test.expected {
+			t.Fatalf("Expected %s for %s; Got %s", test.expected, test.duckType, got)
+		}
+	}
+}
@@ -26,10 +26,16 @@ func (s *PostgresServer) createColumns(table string, jsonTypes map[string]string
 			colType = "BOOLEAN"
 		case "float":
 			colType = "DOUBLE PRECISION"
+		case "timestamp":
+			colType = "TIMESTAMPTZ"
+		case "date":
+			colType = "DATE"
 		case "string":
 			colType = "VARCHAR"
 		default:
-			colType = "VARCHAR"
+			if precision, scale, ok := util.ParseDecimalType(jsonType); ok {
+				colType = fmt.Sprintf("NUMERIC(%d,%d)", precision, scale)
+			}
 		}
 
 		sql := fmt.Sprintf("ALTER TABLE %s ADD COLUMN \"%s\" %s", s.Schema+"."+table, colName, colType)
@@ -47,6 +53,10 @@ func (s *PostgresServer) createColumns(table string, jsonTypes map[string]string
 
 	return nil
 }
+func (s *PostgresServer) CreateColumnsWithTypes(table string, jsonTypes map[string]string) error {
+	return s.createColumns(table, jsonTypes)
+}
+
 func (s *PostgresServer) CreateColumns(table string, fileName string) error {
 
 	input, err := os.Open(fileName)
@@ -80,7 +90,7 @@ func (s *PostgresServer) CreateEmptyTable(table string) error {
 	return err
 }
 
-func (s *PostgresServer) InsertFromNDJsonFile(table string, filePath string) error {
+func (s *PostgresServer) InsertFromNDJsonFile(table string, filePath string, jsonTypes map[string]string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
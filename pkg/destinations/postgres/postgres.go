@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -65,3 +66,7 @@ func OpenServer(settings map[string]any) (*PostgresServer, error) {
 func (s *PostgresServer) Close() error {
 	return s.conn.Close()
 }
+
+func (s *PostgresServer) Ping(ctx context.Context) error {
+	return s.conn.PingContext(ctx)
+}
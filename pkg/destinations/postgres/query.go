@@ -7,6 +7,7 @@ import (
 	"io"
 
 	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/util"
 )
 
 func (s *PostgresServer) QueryNDJson(query string, writer io.Writer) error {
@@ -195,3 +196,14 @@ func (s *PostgresServer) QueryCSV(query string, writer io.Writer) error {
 
 	return nil
 }
+
+func (s *PostgresServer) QueryFormat(query string, format string, writer io.Writer) error {
+	rows, err := s.conn.Query(query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to execute query")
+		return err
+	}
+	defer rows.Close()
+
+	return util.WriteSQLRows(rows, format, writer, nil)
+}
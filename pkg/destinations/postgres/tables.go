@@ -1,15 +1,92 @@
 package postgres
 
 import (
-	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/scratchdata/scratchdata/models"
 )
 
-func (b *PostgresServer) Columns(table string) ([]models.Column, error) {
-	return []models.Column{}, errors.New("not implemented - pg columns")
+// pgToJSONType normalizes an information_schema data type to one of the JSON
+// types we use when creating columns: int, float, bool or string
+func pgToJSONType(pgType string) string {
+	switch strings.ToLower(pgType) {
+	case "smallint", "integer", "bigint":
+		return "int"
+	case "real", "double precision", "numeric":
+		return "float"
+	case "boolean":
+		return "bool"
+	}
+
+	return "string"
 }
 
-func (b *PostgresServer) Tables() ([]string, error) {
-	return []string{}, errors.New("not implemented - pg tables")
+func (s *PostgresServer) Columns(table string) ([]models.Column, error) {
+	rc := []models.Column{}
+
+	sql := `
+		SELECT column_name, data_type
+		FROM information_schema.columns
+		WHERE table_schema = $1 AND table_name = $2
+		ORDER BY ordinal_position
+	`
+	rows, err := s.conn.Query(sql, s.Schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return nil, err
+		}
+
+		rc = append(rc, models.Column{
+			Name:     name,
+			Type:     dataType,
+			JSONType: pgToJSONType(dataType),
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(rc) == 0 {
+		return nil, fmt.Errorf("table not found: %s", table)
+	}
+
+	return rc, nil
+}
+
+func (s *PostgresServer) Tables() ([]string, error) {
+	rc := []string{}
+
+	sql := `
+		SELECT table_name
+		FROM information_schema.tables
+		WHERE table_schema = $1
+		ORDER BY table_name
+	`
+	rows, err := s.conn.Query(sql, s.Schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		rc = append(rc, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rc, nil
 }
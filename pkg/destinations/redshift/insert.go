@@ -23,10 +23,17 @@ func (s *RedshiftServer) createColumns(table string, jsonTypes map[string]string
 			colType = "BOOLEAN"
 		case "float":
 			colType = "DOUBLE PRECISION"
+		case "timestamp":
+			colType = "TIMESTAMPTZ"
+		case "date":
+			colType = "DATE"
 		case "string":
 			colType = "VARCHAR"
 		default:
-			colType = "VARCHAR"
+			// Redshift decimals are limited to 38 digits
+			if precision, scale, ok := util.ParseDecimalType(jsonType); ok && precision <= 38 {
+				colType = fmt.Sprintf("DECIMAL(%d,%d)", precision, scale)
+			}
 		}
 
 		sql := fmt.Sprintf("ALTER TABLE %s ADD COLUMN \"%s\" %s", s.Schema+"."+table, colName, colType)
@@ -44,6 +51,10 @@ func (s *RedshiftServer) createColumns(table string, jsonTypes map[string]string
 
 	return nil
 }
+func (s *RedshiftServer) CreateColumnsWithTypes(table string, jsonTypes map[string]string) error {
+	return s.createColumns(table, jsonTypes)
+}
+
 func (s *RedshiftServer) CreateColumns(table string, fileName string) error {
 
 	input, err := os.Open(fileName)
@@ -77,7 +88,7 @@ func (s *RedshiftServer) CreateEmptyTable(table string) error {
 	return err
 }
 
-func (s *RedshiftServer) InsertFromNDJsonFile(table string, filePath string) error {
+func (s *RedshiftServer) InsertFromNDJsonFile(table string, filePath string, jsonTypes map[string]string) error {
 	// Make sure the table exists
 
 	// Recalling createColumns to create columns in the table if missing,  will be created
@@ -87,6 +98,28 @@ func (s *RedshiftServer) InsertFromNDJsonFile(table string, filePath string) err
 
 	}
 
+	return s.copyFromS3(table, filePath, "")
+}
+
+func (s *RedshiftServer) InsertsStagingFormat(format string) bool {
+	return format == util.StagingGzip || format == util.StagingZstd
+}
+
+// InsertFromStagedFile loads a gzip or zstd NDJSON file, which COPY reads
+// directly
+func (s *RedshiftServer) InsertFromStagedFile(table string, filePath string, format string, jsonTypes map[string]string) error {
+	switch format {
+	case util.StagingGzip:
+		return s.copyFromS3(table, filePath, " GZIP")
+	case util.StagingZstd:
+		return s.copyFromS3(table, filePath, " ZSTD")
+	}
+	return fmt.Errorf("%w %q", util.ErrUnsupportedFormat, format)
+}
+
+// copyFromS3 uploads a file to S3 and loads it with COPY. compression is added
+// to the COPY options.
+func (s *RedshiftServer) copyFromS3(table string, filePath string, compression string) error {
 	params := make(map[string]any)
 
 	params["region"] = s.S3Region
@@ -120,7 +153,7 @@ func (s *RedshiftServer) InsertFromNDJsonFile(table string, filePath string) err
 		return err
 	}
 
-	copyCommand := fmt.Sprintf("COPY %s FROM 's3://%s/%s' CREDENTIALS 'aws_access_key_id=%s;aws_secret_access_key=%s' FORMAT AS JSON 'auto'", s.Schema+"."+table, s.S3Bucket, s3FilePath, s.S3AccessKeyId, s.S3SecretAccessKey)
+	copyCommand := fmt.Sprintf("COPY %s FROM 's3://%s/%s' CREDENTIALS 'aws_access_key_id=%s;aws_secret_access_key=%s' FORMAT AS JSON 'auto' TIMEFORMAT 'auto' DATEFORMAT 'auto'%s", s.Schema+"."+table, s.S3Bucket, s3FilePath, s.S3AccessKeyId, s.S3SecretAccessKey, compression)
 
 	_, err = s.conn.Exec(copyCommand)
 	if err != nil {
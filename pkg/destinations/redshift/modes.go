@@ -0,0 +1,20 @@
+package redshift
+
+import "github.com/scratchdata/scratchdata/pkg/destinations/sqlmodes"
+
+func (s *RedshiftServer) DropTable(table string) error {
+	return sqlmodes.DropTable(s.conn, s.Schema, table)
+}
+
+func (s *RedshiftServer) ReplaceTable(table string, staging string) error {
+	return sqlmodes.ReplaceTable(s.conn, s.Schema, table, staging)
+}
+
+func (s *RedshiftServer) MergeTable(table string, staging string, keyColumn string) error {
+	columns, err := s.Columns(staging)
+	if err != nil {
+		return err
+	}
+
+	return sqlmodes.MergeTable(s.conn, s.Schema, table, staging, keyColumn, columns)
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 
 	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/util"
 )
 
 func (s *RedshiftServer) QueryNDJson(query string, writer io.Writer) error {
@@ -145,3 +146,14 @@ func (s *RedshiftServer) QueryCSV(query string, writer io.Writer) error {
 
 	return nil
 }
+
+func (s *RedshiftServer) QueryFormat(query string, format string, writer io.Writer) error {
+	rows, err := s.conn.Query(query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to execute query")
+		return err
+	}
+	defer rows.Close()
+
+	return util.WriteSQLRows(rows, format, writer, nil)
+}
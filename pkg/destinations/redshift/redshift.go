@@ -1,6 +1,7 @@
 package redshift
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -71,3 +72,7 @@ func OpenServer(settings map[string]any) (*RedshiftServer, error) {
 func (s *RedshiftServer) Close() error {
 	return s.conn.Close()
 }
+
+func (s *RedshiftServer) Ping(ctx context.Context) error {
+	return s.conn.PingContext(ctx)
+}
@@ -0,0 +1,25 @@
+package redshift
+
+import "testing"
+
+func TestRedshiftToJSONType(t *testing.T) {
+	tests := []struct {
+		dataType string
+		expected string
+	}{
+		{"bigint", "int"},
+		{"SMALLINT", "int"},
+		{"double precision", "float"},
+		{"numeric", "float"},
+		{"boolean", "bool"},
+		{"character varying", "string"},
+		{"timestamp without time zone", "string"},
+		{"interval", "string"},
+	}
+
+	for _, test := range tests {
+		if got := redshiftToJSONType(test.dataType); got != test.expected {
+			t.Fatalf("Expected %s for %s; Got %s", test.expected, test.dataType, got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package sqlmodes
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/scratchdata/scratchdata/models"
+)
+
+// Write modes for destinations which are reached through database/sql and
+// support transactional DDL, such as Postgres, Redshift and DuckDB
+
+func quote(schema string, table string) string {
+	return fmt.Sprintf("\"%s\".\"%s\"", schema, table)
+}
+
+func DropTable(db *sql.DB, schema string, table string) error {
+	_, err := db.Exec("DROP TABLE IF EXISTS " + quote(schema, table))
+	return err
+}
+
+// ReplaceTable swaps staging in for table in a single transaction
+func ReplaceTable(db *sql.DB, schema string, table string, staging string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DROP TABLE IF EXISTS " + quote(schema, table))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO \"%s\"", quote(schema, staging), table))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MergeTable replaces rows in table which have the same key as a row in staging,
+// and inserts the rest, in a single transaction. Staging is dropped afterwards.
+// columns are the columns of staging.
+func MergeTable(db *sql.DB, schema string, table string, staging string, keyColumn string, columns []models.Column) error {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = fmt.Sprintf("\"%s\"", c.Name)
+	}
+	columnList := strings.Join(quoted, ", ")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sql := fmt.Sprintf(
+		"DELETE FROM %s USING %s WHERE \"%s\".\"%s\" = \"%s\".\"%s\"",
+		quote(schema, table), quote(schema, staging), table, keyColumn, staging, keyColumn,
+	)
+	_, err = tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	sql = fmt.Sprintf(
+		"INSERT INTO %s (%s) SELECT %s FROM %s",
+		quote(schema, table), columnList, columnList, quote(schema, staging),
+	)
+	_, err = tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DROP TABLE " + quote(schema, staging))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
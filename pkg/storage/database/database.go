@@ -13,6 +13,7 @@ import (
 )
 
 type Database interface {
+	Ping(ctx context.Context) error
 	VerifyAdminAPIKey(ctx context.Context, hashedAPIKey string) bool
 
 	GetDestinations(ctx context.Context, teamId uint) ([]models.Destination, error)
@@ -21,6 +22,7 @@ type Database interface {
 	DeleteDestination(ctx context.Context, teamId uint, destId uint) error
 	UpdateDestination(ctx context.Context, dest models.Destination) error
 	GetDestinationCredentials(ctx context.Context, dbID int64) (models.Destination, error)
+	DestinationIDs(ctx context.Context) ([]int64, error)
 
 	CreateConnectionRequest(ctx context.Context, dest models.Destination) (models.ConnectionRequest, error)
 	GetConnectionRequest(ctx context.Context, requestId uuid.UUID) (models.ConnectionRequest, error)
@@ -41,9 +43,42 @@ type Database interface {
 
 	Hash(s string) string
 
-	Enqueue(messageType models.MessageType, message any) (*models.Message, error)
-	Dequeue(messageType models.MessageType, claimedBy string) (*models.Message, bool)
+	Enqueue(messageType models.MessageType, message any, destinationID uint, table string) (*models.Message, error)
+	Dequeue(messageType models.MessageType, claimedBy string, opts models.DequeueOptions) (*models.Message, bool)
+	QueueDepth() (int64, error)
+	InFlightCounts() (map[uint]int64, error)
+	StartMessage(id uint) error
+	Heartbeat(id uint, claimedBy string) error
+	ReclaimMessages(staleBefore time.Time, maxAttempts int) (int64, error)
+	CompleteMessage(id uint, rows int64, bytes int64) error
+	FailMessage(id uint, errMsg string) error
+	RetryMessage(id uint, errMsg string, availableAt time.Time) error
+	DeadLetterMessage(id uint, errMsg string) error
+	RequeueMessage(ctx context.Context, teamId uint, id uint) (models.Message, error)
 	Delete(id uint) error
+	DeleteFinishedMessages(completedBefore time.Time) (int64, error)
+
+	GetMessage(ctx context.Context, teamId uint, id uint) (models.Message, error)
+	GetMessages(ctx context.Context, teamId uint, filter models.MessageFilter) ([]models.Message, error)
+
+	GetCopyChunks(messageId uint) ([]models.CopyChunk, error)
+	SetCopyChunks(messageId uint, chunks []models.CopyChunk) error
+
+	PauseIngestion(ctx context.Context, destId uint, table string) (models.Pause, error)
+	ResumeIngestion(ctx context.Context, destId uint, table string) error
+	GetPauses(ctx context.Context, teamId uint) ([]models.Pause, error)
+
+	CreateSchedule(ctx context.Context, schedule *models.Schedule) error
+	GetSchedule(ctx context.Context, teamId uint, id uint) (models.Schedule, error)
+	GetSchedules(ctx context.Context, teamId uint) ([]models.Schedule, error)
+	UpdateSchedule(ctx context.Context, schedule *models.Schedule) error
+	DeleteSchedule(ctx context.Context, teamId uint, id uint) error
+	GetScheduleRuns(ctx context.Context, teamId uint, scheduleId uint, limit int) ([]models.ScheduleRun, error)
+	DueSchedules(now time.Time) ([]models.Schedule, error)
+	RunSchedule(schedule models.Schedule, nextRunAt time.Time, message any) (models.ScheduleRun, bool, error)
+
+	GetWatermark(key string) (models.Watermark, error)
+	SetWatermark(key string, value string) error
 }
 
 func NewConnection(conf config.Database, destinations []config.Destination, adminKeys []config.APIKey) (Database, error) {
@@ -0,0 +1,46 @@
+package gorm
+
+import (
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	"gorm.io/gorm"
+)
+
+func (db *Gorm) GetCopyChunks(messageId uint) ([]models.CopyChunk, error) {
+	var chunks []models.CopyChunk
+	res := db.db.Where("message_id = ?", messageId).Order("id").Find(&chunks)
+	return chunks, res.Error
+}
+
+// SetCopyChunks replaces the chunks recorded for a message and updates the
+// message's chunk counts to match
+func (db *Gorm) SetCopyChunks(messageId uint, chunks []models.CopyChunk) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("message_id = ?", messageId).Delete(&models.CopyChunk{}).Error
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for i := range chunks {
+			chunks[i].ID = 0
+			chunks[i].MessageID = messageId
+			if chunks[i].Status == models.ChunkFailed {
+				failed++
+			}
+		}
+
+		if len(chunks) > 0 {
+			err = tx.Create(&chunks).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&models.Message{}).
+			Where("id = ?", messageId).
+			Updates(map[string]any{
+				"chunks_total":  len(chunks),
+				"chunks_failed": failed,
+			}).Error
+	})
+}
@@ -58,17 +58,36 @@ func NewGorm(
 		&models.APIKey{},
 		&models.Message{},
 		&models.ConnectionRequest{},
+		&models.Pause{},
+		&models.Schedule{},
+		&models.ScheduleRun{},
+		&models.Watermark{},
+		&models.CopyChunk{},
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	err = backfillMessages(db)
+	if err != nil {
+		return nil, err
+	}
+
 	var teamCount int64
 	db.Model(&models.Team{}).Count(&teamCount)
 
 	return rc, nil
 }
 
+// Ping checks that the database can be reached
+func (s *Gorm) Ping(ctx context.Context) error {
+	conn, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return conn.PingContext(ctx)
+}
+
 func (s *Gorm) VerifyAdminAPIKey(ctx context.Context, apiKey string) bool {
 	return false
 }
@@ -241,6 +260,16 @@ func (s *Gorm) GetDestinations(c context.Context, userId uint) ([]models.Destina
 	return destinations, nil
 }
 
+// DestinationIDs returns the ID of every destination, across all teams
+func (s *Gorm) DestinationIDs(ctx context.Context) ([]int64, error) {
+	var ids []int64
+	res := s.db.Model(&models.Destination{}).Order("id").Pluck("id", &ids)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return ids, nil
+}
+
 func (s *Gorm) GetDestination(c context.Context, teamId, destId uint) (models.Destination, error) {
 	var dest models.Destination
 	res := s.db.First(&dest, "team_id = ? AND id = ?", teamId, destId)
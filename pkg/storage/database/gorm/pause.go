@@ -0,0 +1,41 @@
+package gorm
+
+import (
+	"context"
+
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+)
+
+// PauseIngestion stops delivery of queued messages to a destination, or to a single
+// table in it. Pausing something which is already paused is a no-op.
+func (db *Gorm) PauseIngestion(ctx context.Context, destId uint, table string) (models.Pause, error) {
+	pause := models.Pause{
+		DestinationID:    destId,
+		DestinationTable: table,
+	}
+
+	res := db.db.WithContext(ctx).
+		Where("destination_id = ? AND destination_table = ?", destId, table).
+		FirstOrCreate(&pause)
+	return pause, res.Error
+}
+
+// ResumeIngestion removes a pause created by PauseIngestion. Resuming a destination
+// does not resume tables which were paused individually.
+func (db *Gorm) ResumeIngestion(ctx context.Context, destId uint, table string) error {
+	res := db.db.WithContext(ctx).
+		Unscoped().
+		Where("destination_id = ? AND destination_table = ?", destId, table).
+		Delete(&models.Pause{})
+	return res.Error
+}
+
+func (db *Gorm) GetPauses(ctx context.Context, teamId uint) ([]models.Pause, error) {
+	var pauses []models.Pause
+	res := db.db.WithContext(ctx).
+		Joins("JOIN destinations ON destinations.id = pauses.destination_id").
+		Where("destinations.team_id = ?", teamId).
+		Order("pauses.destination_id, pauses.destination_table").
+		Find(&pauses)
+	return pauses, res.Error
+}
@@ -1,8 +1,9 @@
 package gorm
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -11,65 +12,400 @@ import (
 	"gorm.io/gorm/clause"
 )
 
-func (db *Gorm) Enqueue(messageType models.MessageType, m any) (*models.Message, error) {
+// backfillMessages fills in columns which were added to messages after they
+// were queued, so that queries comparing them don't skip older messages
+func backfillMessages(db *gorm.DB) error {
+	// NULL never matches available_at <= now, so these would never be dequeued
+	res := db.Exec("UPDATE messages SET available_at = created_at WHERE available_at IS NULL")
+	if res.Error != nil {
+		return res.Error
+	}
+
+	// Nor does it match heartbeat_at < staleBefore, so claimed messages would
+	// never be reclaimed
+	res = db.Exec("UPDATE messages SET heartbeat_at = COALESCE(claimed_at, updated_at) WHERE heartbeat_at IS NULL")
+	return res.Error
+}
+
+func (db *Gorm) Enqueue(messageType models.MessageType, m any, destinationID uint, table string) (*models.Message, error) {
 	mStr, err := json.Marshal(m)
 	if err != nil {
 		return nil, err
 	}
 
 	message := &models.Message{
-		MessageType: messageType,
-		Status:      models.New,
-		Message:     string(mStr),
+		MessageType:      messageType,
+		Status:           models.New,
+		Message:          string(mStr),
+		AvailableAt:      time.Now(),
+		DestinationID:    destinationID,
+		DestinationTable: table,
 	}
 
 	res := db.db.Create(message)
 	return message, res.Error
 }
 
-func (db *Gorm) Dequeue(messageType models.MessageType, claimedBy string) (*models.Message, bool) {
+// inFlightSQL counts the claimed or running messages for the destination of
+// the message being considered
+const inFlightSQL = `(
+	SELECT COUNT(*) FROM messages AS in_flight
+	WHERE in_flight.destination_id = messages.destination_id
+	AND in_flight.status IN ('CLAIMED', 'RUNNING')
+)`
+
+// maxInFlightSQL reads max_in_flight from the settings of a message's
+// destination, falling back to defaultMaxInFlight when it isn't set
+func (db *Gorm) maxInFlightSQL(defaultMaxInFlight int) string {
+	setting := "CAST(json_extract(destinations.settings, '$.max_in_flight') AS INTEGER)"
+	if db.db.Dialector.Name() == "postgres" {
+		setting = "(destinations.settings->>'max_in_flight')::int"
+	}
+
+	return fmt.Sprintf(`COALESCE(NULLIF((
+		SELECT %s FROM destinations WHERE destinations.id = messages.destination_id
+	), 0), %d)`, setting, defaultMaxInFlight)
+}
+
+// Dequeue atomically claims the next available message. The subquery picks the
+// message and the UPDATE claims it, so two workers can never claim the same row.
+// Postgres additionally needs SKIP LOCKED so that concurrent workers move on to
+// the next message instead of waiting on each other. SQLite has no row locks,
+// but it only allows one writer at a time, which serializes the statement as a
+// whole. Messages for paused destinations or tables are skipped and stay in the
+// queue.
+//
+// Destinations with max_in_flight set only have a message claimed while fewer
+// than that many are claimed or running. The count is checked by the claiming
+// UPDATE. On Postgres, where claims for the same destination can run at the
+// same time, the destination's row is locked first so that they are counted one
+// after another.
+func (db *Gorm) Dequeue(messageType models.MessageType, claimedBy string, opts models.DequeueOptions) (*models.Message, bool) {
 	var message models.Message
 
-	err := db.db.Transaction(func(tx *gorm.DB) error {
+	now := time.Now()
+	maxInFlight := db.maxInFlightSQL(opts.DefaultMaxInFlight)
+	underLimit := fmt.Sprintf("(%s <= 0 OR %s < %s)", maxInFlight, inFlightSQL, maxInFlight)
 
-		// This locking does not work with SQLite. Should use UPDATE .. WHERE status = new LIMIT 1 RESULT
-		findRes := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
-			Where("status = ? AND message_type = ?", models.New, messageType).
-			Find(&message)
+	next := func(tx *gorm.DB, columns string) *gorm.DB {
+		return tx.Model(&models.Message{}).
+			Select(columns).
+			Where("status = ? AND message_type = ? AND available_at <= ?", models.New, messageType, now).
+			Where(notPausedSQL).
+			Where(underLimit).
+			// Start with the destinations after the previous one, then wrap around
+			Order(fmt.Sprintf("CASE WHEN destination_id > %d THEN 0 ELSE 1 END", opts.AfterDestinationID)).
+			Order("destination_id").
+			Order("id").
+			Limit(1).
+			// The SQLite driver leaves this out, since it doesn't support row locking
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+	}
 
-		if findRes.Error != nil {
-			return findRes.Error
-		}
+	claim := func(tx *gorm.DB, id any) *gorm.DB {
+		return tx.Raw(`
+			UPDATE messages
+			SET status = ?, claimed_at = ?, claimed_by = ?, heartbeat_at = ?, updated_at = ?, attempts = attempts + 1
+			WHERE id = (?) AND status = ? AND `+underLimit+`
+			RETURNING *`,
+			models.Claimed, now, claimedBy, now, now, id, models.New,
+		).Scan(&message)
+	}
 
-		if findRes.RowsAffected == 0 {
-			return gorm.ErrRecordNotFound
-		}
+	var res *gorm.DB
+	if db.db.Dialector.Name() != "postgres" {
+		res = claim(db.db, next(db.db, "id"))
+	} else {
+		err := db.db.Transaction(func(tx *gorm.DB) error {
+			var candidate struct {
+				ID            uint
+				DestinationID uint
+				MaxInFlight   int
+			}
+			res = next(tx, "id, destination_id, "+maxInFlight+" AS max_in_flight").Scan(&candidate)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
 
-		message.Status = models.Claimed
-		message.ClaimedAt = time.Now()
-		message.ClaimedBy = claimedBy
+			if candidate.MaxInFlight > 0 {
+				res = tx.Exec("SELECT id FROM destinations WHERE id = ? FOR UPDATE", candidate.DestinationID)
+				if res.Error != nil {
+					return res.Error
+				}
+			}
 
-		saveRes := tx.Save(&message)
-		if saveRes.Error != nil {
-			return saveRes.Error
+			// Under READ COMMITTED this statement sees claims committed while
+			// waiting for the destination's lock
+			res = claim(tx, candidate.ID)
+			return res.Error
+		})
+		if err != nil {
+			res.Error = err
 		}
-		return nil
-	})
+	}
 
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, false
-		}
+	if res.Error != nil {
 		log.Error().
-			Err(err).
+			Err(res.Error).
 			Any("message_type", messageType).
 			Str("claimed_by", claimedBy).
 			Msg("Unable to query for messages")
 		return nil, false
 	}
 
+	if res.RowsAffected == 0 {
+		return nil, false
+	}
+
 	return &message, true
+}
+
+// notPausedSQL matches messages whose destination and table aren't paused
+const notPausedSQL = `NOT EXISTS (
+	SELECT 1 FROM pauses
+	WHERE pauses.destination_id = messages.destination_id
+	AND pauses.destination_table IN ('', messages.destination_table)
+	AND pauses.deleted_at IS NULL
+)`
+
+// QueueDepth returns the number of messages which could be claimed now. Messages
+// for paused destinations and retries which aren't due yet aren't counted.
+func (db *Gorm) QueueDepth() (int64, error) {
+	var count int64
+	res := db.db.Model(&models.Message{}).
+		Where("status = ? AND available_at <= ?", models.New, time.Now()).
+		Where(notPausedSQL).
+		Count(&count)
+	return count, res.Error
+}
+
+// InFlightCounts returns the number of claimed or running messages for each destination
+func (db *Gorm) InFlightCounts() (map[uint]int64, error) {
+	var rows []struct {
+		DestinationID uint
+		Count         int64
+	}
+
+	res := db.db.Model(&models.Message{}).
+		Select("destination_id, COUNT(*) AS count").
+		Where("status IN ?", []models.MessageStatus{models.Claimed, models.Running}).
+		Group("destination_id").
+		Scan(&rows)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.DestinationID] = row.Count
+	}
+	return counts, nil
+}
+
+func (db *Gorm) StartMessage(id uint) error {
+	res := db.db.Model(&models.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":       models.Running,
+			"started_at":   time.Now(),
+			"heartbeat_at": time.Now(),
+		})
+	return res.Error
+}
+
+// Heartbeat records that the worker which claimed a message is still processing it
+func (db *Gorm) Heartbeat(id uint, claimedBy string) error {
+	res := db.db.Model(&models.Message{}).
+		Where("id = ? AND claimed_by = ? AND status IN ?", id, claimedBy, []models.MessageStatus{models.Claimed, models.Running}).
+		Update("heartbeat_at", time.Now())
+	return res.Error
+}
+
+// ReclaimMessages returns claimed messages whose worker stopped sending heartbeats
+// before staleBefore to the queue. Messages which have already used up their attempts
+// are dead lettered instead, so a message that crashes its worker can't loop forever.
+func (db *Gorm) ReclaimMessages(staleBefore time.Time, maxAttempts int) (int64, error) {
+	var reclaimed int64
+	staleStatuses := []models.MessageStatus{models.Claimed, models.Running}
+	errMsg := "worker stopped responding before the message was processed"
+
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Message{}).
+			Where("status IN ? AND heartbeat_at < ? AND attempts >= ?", staleStatuses, staleBefore, maxAttempts).
+			Updates(map[string]any{
+				"status":       models.DeadLetter,
+				"completed_at": time.Now(),
+				"error":        errMsg,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		reclaimed += res.RowsAffected
+
+		res = tx.Model(&models.Message{}).
+			Where("status IN ? AND heartbeat_at < ?", staleStatuses, staleBefore).
+			Updates(map[string]any{
+				"status":       models.New,
+				"available_at": time.Now(),
+				"error":        errMsg,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		reclaimed += res.RowsAffected
+
+		return nil
+	})
+
+	return reclaimed, err
+}
+
+func (db *Gorm) CompleteMessage(id uint, rows int64, bytes int64) error {
+	res := db.db.Model(&models.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":          models.Succeeded,
+			"completed_at":    time.Now(),
+			"error":           "",
+			"rows_processed":  rows,
+			"bytes_processed": bytes,
+		})
+	return res.Error
+}
+
+func (db *Gorm) FailMessage(id uint, errMsg string) error {
+	res := db.db.Model(&models.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":       models.Failed,
+			"completed_at": time.Now(),
+			"error":        errMsg,
+		})
+	return res.Error
+}
+
+// RetryMessage puts a failed message back in the queue, to be picked up again after availableAt
+func (db *Gorm) RetryMessage(id uint, errMsg string, availableAt time.Time) error {
+	res := db.db.Model(&models.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":       models.New,
+			"error":        errMsg,
+			"available_at": availableAt,
+		})
+	return res.Error
+}
+
+// DeadLetterMessage parks a message which has run out of attempts until it is requeued
+func (db *Gorm) DeadLetterMessage(id uint, errMsg string) error {
+	res := db.db.Model(&models.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":       models.DeadLetter,
+			"completed_at": time.Now(),
+			"error":        errMsg,
+		})
+	return res.Error
+}
+
+// RequeueMessage moves a dead lettered message back into the queue with a fresh set of attempts
+func (db *Gorm) RequeueMessage(ctx context.Context, teamId uint, id uint) (models.Message, error) {
+	message, err := db.GetMessage(ctx, teamId, id)
+	if err != nil {
+		return message, err
+	}
+
+	if message.Status != models.DeadLetter {
+		return message, models.ErrNotDeadLettered
+	}
+
+	res := db.db.Model(&message).
+		Where("status = ?", models.DeadLetter).
+		Updates(map[string]any{
+			"status":       models.New,
+			"attempts":     0,
+			"available_at": time.Now(),
+			"completed_at": time.Time{},
+		})
+	if res.Error != nil {
+		return message, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return message, models.ErrNotDeadLettered
+	}
+
+	return db.GetMessage(ctx, teamId, id)
+}
+
+// teamMessages scopes a query to messages written to destinations owned by the team
+func (db *Gorm) teamMessages(ctx context.Context, teamId uint) *gorm.DB {
+	return db.db.WithContext(ctx).
+		Joins("JOIN destinations ON destinations.id = messages.destination_id").
+		Where("destinations.team_id = ?", teamId)
+}
+
+func (db *Gorm) GetMessage(ctx context.Context, teamId uint, id uint) (models.Message, error) {
+	var message models.Message
+	res := db.teamMessages(ctx, teamId).First(&message, "messages.id = ?", id)
+	return message, res.Error
+}
+
+func (db *Gorm) GetMessages(ctx context.Context, teamId uint, filter models.MessageFilter) ([]models.Message, error) {
+	tx := db.teamMessages(ctx, teamId)
+
+	if filter.MessageType != "" {
+		tx = tx.Where("messages.message_type = ?", filter.MessageType)
+	}
+	if filter.Status != "" {
+		tx = tx.Where("messages.status = ?", filter.Status)
+	}
+	if filter.DestinationID != 0 {
+		tx = tx.Where("messages.destination_id = ?", filter.DestinationID)
+	}
+	if filter.DestinationTable != "" {
+		tx = tx.Where("messages.destination_table = ?", filter.DestinationTable)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		tx = tx.Where("messages.created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		tx = tx.Where("messages.created_at < ?", filter.CreatedBefore)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	} else if limit > 1000 {
+		limit = 1000
+	}
+
+	var messages []models.Message
+	res := tx.Order("messages.id DESC").Limit(limit).Offset(filter.Offset).Find(&messages)
+	return messages, res.Error
+}
+
+// DeleteFinishedMessages removes messages which succeeded or failed before
+// completedBefore, along with their copy chunks. Dead lettered messages are kept
+// until they are requeued.
+func (db *Gorm) DeleteFinishedMessages(completedBefore time.Time) (int64, error) {
+	var deleted int64
+
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		finished := tx.Model(&models.Message{}).
+			Select("id").
+			Where("status IN ? AND completed_at < ?", []models.MessageStatus{models.Succeeded, models.Failed}, completedBefore)
+
+		res := tx.Unscoped().Where("message_id IN (?)", finished).Delete(&models.CopyChunk{})
+		if res.Error != nil {
+			return res.Error
+		}
+
+		res = tx.Unscoped().Where("id IN (?)", finished).Delete(&models.Message{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
 
+	return deleted, err
 }
 
 func (db *Gorm) Delete(id uint) error {
@@ -0,0 +1,488 @@
+package gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/scratchdata/scratchdata/pkg/config"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	"gorm.io/gorm"
+)
+
+func newTestGorm(t *testing.T, dsn string) *Gorm {
+	db, err := NewGorm(config.Database{
+		Type:     "sqlite",
+		Settings: map[string]any{"dsn": dsn},
+	})
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err)
+	}
+	return db
+}
+
+func TestDequeueNoDoubleClaims(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "queue.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+
+	// Two handles to the same file behave like two worker processes
+	dbs := []*Gorm{newTestGorm(t, dsn), newTestGorm(t, dsn)}
+
+	const messages = 200
+	const workersPerDB = 4
+
+	for i := 0; i < messages; i++ {
+		_, err := dbs[0].Enqueue(models.InsertData, map[string]int{"i": i}, 1, "t")
+		if err != nil {
+			t.Fatalf("Unable to enqueue: %s", err)
+		}
+	}
+
+	var (
+		claimed  sync.Map
+		total    atomic.Int64
+		doubles  atomic.Int64
+		wg       sync.WaitGroup
+		deadline = time.Now().Add(30 * time.Second)
+	)
+
+	for d, db := range dbs {
+		for w := 0; w < workersPerDB; w++ {
+			wg.Add(1)
+			go func(db *Gorm, workerID string) {
+				defer wg.Done()
+				for total.Load() < messages && time.Now().Before(deadline) {
+					message, ok := db.Dequeue(models.InsertData, workerID, models.DequeueOptions{})
+					if !ok {
+						continue
+					}
+					if prev, loaded := claimed.LoadOrStore(message.ID, workerID); loaded {
+						t.Errorf("Message %d claimed by %s and %s", message.ID, prev, workerID)
+						doubles.Add(1)
+					}
+					total.Add(1)
+				}
+			}(db, fmt.Sprintf("db%d-worker%d", d, w))
+		}
+	}
+
+	wg.Wait()
+
+	if n := doubles.Load(); n > 0 {
+		t.Fatalf("Expected no double claims; Got %d", n)
+	}
+	if n := total.Load(); n != messages {
+		t.Fatalf("Expected %d claimed messages; Got %d", messages, n)
+	}
+
+	if _, ok := dbs[0].Dequeue(models.InsertData, "late", models.DequeueOptions{}); ok {
+		t.Fatalf("Expected queue to be empty")
+	}
+
+	var message models.Message
+	dbs[0].db.First(&message)
+	if message.Status != models.Claimed || message.Attempts != 1 || message.ClaimedBy == "" {
+		t.Fatalf("Expected claimed message with 1 attempt; Got %s with %d attempts by %q", message.Status, message.Attempts, message.ClaimedBy)
+	}
+}
+
+func TestDequeueRespectsAvailableAt(t *testing.T) {
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "queue.db"))
+
+	message, err := db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+	if err != nil {
+		t.Fatalf("Unable to enqueue: %s", err)
+	}
+
+	err = db.RetryMessage(message.ID, "boom", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Unable to retry: %s", err)
+	}
+
+	if _, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); ok {
+		t.Fatalf("Expected delayed message not to be dequeued")
+	}
+
+	err = db.RetryMessage(message.ID, "boom", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatalf("Unable to retry: %s", err)
+	}
+
+	if m, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); !ok || m.ID != message.ID {
+		t.Fatalf("Expected message %d to be dequeued", message.ID)
+	}
+}
+
+func TestDequeueSkipsPaused(t *testing.T) {
+	ctx := context.Background()
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "queue.db"))
+
+	events, _ := db.Enqueue(models.InsertData, map[string]int{}, 1, "events")
+	users, _ := db.Enqueue(models.InsertData, map[string]int{}, 1, "users")
+
+	if _, err := db.PauseIngestion(ctx, 1, "events"); err != nil {
+		t.Fatalf("Unable to pause table: %s", err)
+	}
+
+	if m, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); !ok || m.ID != users.ID {
+		t.Fatalf("Expected message for unpaused table %d to be dequeued", users.ID)
+	}
+	if _, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); ok {
+		t.Fatalf("Expected message for paused table not to be dequeued")
+	}
+
+	// Pausing the whole destination keeps the table paused after the table is resumed
+	if _, err := db.PauseIngestion(ctx, 1, ""); err != nil {
+		t.Fatalf("Unable to pause destination: %s", err)
+	}
+	if err := db.ResumeIngestion(ctx, 1, "events"); err != nil {
+		t.Fatalf("Unable to resume table: %s", err)
+	}
+	if _, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); ok {
+		t.Fatalf("Expected message for paused destination not to be dequeued")
+	}
+
+	if err := db.ResumeIngestion(ctx, 1, ""); err != nil {
+		t.Fatalf("Unable to resume destination: %s", err)
+	}
+	if m, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); !ok || m.ID != events.ID {
+		t.Fatalf("Expected message %d to be dequeued after resuming", events.ID)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	ctx := context.Background()
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "queue.db"))
+
+	for _, destinationID := range []uint{1, 1, 2} {
+		if _, err := db.Enqueue(models.InsertData, map[string]int{}, destinationID, "events"); err != nil {
+			t.Fatalf("Unable to enqueue: %s", err)
+		}
+	}
+	delayed, _ := db.Enqueue(models.InsertData, map[string]int{}, 3, "events")
+	if err := db.RetryMessage(delayed.ID, "boom", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Unable to retry: %s", err)
+	}
+
+	if depth, err := db.QueueDepth(); err != nil || depth != 3 {
+		t.Fatalf("Expected 3 messages waiting, not counting a delayed retry; Got %d %v", depth, err)
+	}
+
+	if _, err := db.PauseIngestion(ctx, 1, ""); err != nil {
+		t.Fatalf("Unable to pause destination: %s", err)
+	}
+	if depth, err := db.QueueDepth(); err != nil || depth != 1 {
+		t.Fatalf("Expected messages for a paused destination not to be counted; Got %d %v", depth, err)
+	}
+}
+
+func TestBackfillAvailableAt(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "queue.db")
+	db := newTestGorm(t, dsn)
+
+	// Messages queued before available_at was added have it set to NULL
+	m, err := db.Enqueue(models.InsertData, map[string]int{}, 1, "events")
+	if err != nil {
+		t.Fatalf("Unable to enqueue: %s", err)
+	}
+	if err := db.db.Exec("UPDATE messages SET available_at = NULL WHERE id = ?", m.ID).Error; err != nil {
+		t.Fatalf("Unable to clear available_at: %s", err)
+	}
+
+	db = newTestGorm(t, dsn)
+	if depth, err := db.QueueDepth(); err != nil || depth != 1 {
+		t.Fatalf("Expected the message to be counted; Got %d %v", depth, err)
+	}
+	if claimed, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); !ok || claimed.ID != m.ID {
+		t.Fatalf("Expected message %d to be dequeued; Got %+v %v", m.ID, claimed, ok)
+	}
+}
+
+func TestBackfillHeartbeatAt(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "queue.db")
+	db := newTestGorm(t, dsn)
+
+	if _, err := db.Enqueue(models.InsertData, map[string]int{}, 1, "events"); err != nil {
+		t.Fatalf("Unable to enqueue: %s", err)
+	}
+	m, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{})
+	if !ok {
+		t.Fatal("Expected a message to be dequeued")
+	}
+
+	// Messages claimed before heartbeat_at was added have it set to NULL
+	res := db.db.Exec("UPDATE messages SET heartbeat_at = NULL, claimed_at = ? WHERE id = ?", time.Now().Add(-time.Hour), m.ID)
+	if res.Error != nil {
+		t.Fatalf("Unable to clear heartbeat_at: %s", res.Error)
+	}
+
+	db = newTestGorm(t, dsn)
+	reclaimed, err := db.ReclaimMessages(time.Now().Add(-time.Minute), 5)
+	if err != nil || reclaimed != 1 {
+		t.Fatalf("Expected the message to be reclaimed; Got %d %v", reclaimed, err)
+	}
+}
+
+func TestDequeueRoundRobin(t *testing.T) {
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "queue.db"))
+
+	// A backlog for destination 1 shouldn't hold up destinations 2 and 3
+	for _, destinationID := range []uint{1, 1, 1, 2, 3, 3} {
+		if _, err := db.Enqueue(models.InsertData, map[string]int{}, destinationID, "t"); err != nil {
+			t.Fatalf("Unable to enqueue: %s", err)
+		}
+	}
+
+	var order []uint
+	var last uint
+	for {
+		m, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{AfterDestinationID: last})
+		if !ok {
+			break
+		}
+		order = append(order, m.DestinationID)
+		last = m.DestinationID
+	}
+
+	expected := []uint{1, 2, 3, 1, 3, 1}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Fatalf("Expected destinations in order %v; Got %v", expected, order)
+	}
+}
+
+func TestDequeueMaxInFlight(t *testing.T) {
+	ctx := context.Background()
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "queue.db"))
+
+	limited, _ := db.CreateDestination(ctx, 1, "limited", "duckdb", map[string]any{"max_in_flight": 1})
+	unlimited, _ := db.CreateDestination(ctx, 1, "unlimited", "duckdb", map[string]any{})
+
+	for _, destinationID := range []uint{limited.ID, limited.ID, unlimited.ID, unlimited.ID} {
+		db.Enqueue(models.InsertData, map[string]int{}, destinationID, "t")
+	}
+
+	var claimed []uint
+	for {
+		m, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{})
+		if !ok {
+			break
+		}
+		claimed = append(claimed, m.DestinationID)
+	}
+
+	expected := []uint{limited.ID, unlimited.ID, unlimited.ID}
+	if fmt.Sprint(claimed) != fmt.Sprint(expected) {
+		t.Fatalf("Expected destinations %v to be claimed; Got %v", expected, claimed)
+	}
+
+	counts, err := db.InFlightCounts()
+	if err != nil {
+		t.Fatalf("Unable to count in-flight messages: %s", err)
+	}
+	if counts[limited.ID] != 1 || counts[unlimited.ID] != 2 {
+		t.Fatalf("Expected 1 and 2 messages in flight; Got %v", counts)
+	}
+
+	// The default limit applies to destinations which don't set one
+	db.Enqueue(models.InsertData, map[string]int{}, unlimited.ID, "t")
+	if _, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{DefaultMaxInFlight: 2}); ok {
+		t.Fatalf("Expected the default limit to stop the message being claimed")
+	}
+}
+
+func TestDequeueMaxInFlightConcurrent(t *testing.T) {
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "queue.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+	dbs := []*Gorm{newTestGorm(t, dsn), newTestGorm(t, dsn)}
+
+	dest, _ := dbs[0].CreateDestination(ctx, 1, "limited", "duckdb", map[string]any{"max_in_flight": 2})
+	for i := 0; i < 20; i++ {
+		dbs[0].Enqueue(models.InsertData, map[string]int{}, dest.ID, "t")
+	}
+
+	// Many workers claiming at once must not go over the limit
+	var claimed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(db *Gorm, workerID string) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, ok := db.Dequeue(models.InsertData, workerID, models.DequeueOptions{}); ok {
+					claimed.Add(1)
+				}
+			}
+		}(dbs[i%2], fmt.Sprintf("worker-%d", i))
+	}
+	wg.Wait()
+
+	if claimed.Load() != 2 {
+		t.Fatalf("Expected 2 messages to be claimed; Got %d", claimed.Load())
+	}
+}
+
+func TestDeleteFinishedMessages(t *testing.T) {
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "queue.db"))
+
+	old, _ := db.Enqueue(models.CopyData, map[string]int{}, 1, "t")
+	recent, _ := db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+	deadLetter, _ := db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+	pending, _ := db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+
+	db.CompleteMessage(old.ID, 1, 1)
+	db.FailMessage(recent.ID, "boom")
+	db.DeadLetterMessage(deadLetter.ID, "boom")
+	db.SetCopyChunks(old.ID, []models.CopyChunk{{Name: "chunk_0", Status: models.ChunkLoaded}})
+
+	weekAgo := time.Now().Add(-7 * 24 * time.Hour)
+	db.db.Model(&models.Message{}).Where("id IN ?", []uint{old.ID, deadLetter.ID}).Update("completed_at", weekAgo)
+
+	deleted, err := db.DeleteFinishedMessages(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("Unable to delete messages: %s", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("Expected 1 message to be deleted; Got %d", deleted)
+	}
+
+	var remaining []uint
+	db.db.Unscoped().Model(&models.Message{}).Order("id").Pluck("id", &remaining)
+	expected := []uint{recent.ID, deadLetter.ID, pending.ID}
+	if fmt.Sprint(remaining) != fmt.Sprint(expected) {
+		t.Fatalf("Expected messages %v to be kept; Got %v", expected, remaining)
+	}
+
+	chunks, _ := db.GetCopyChunks(old.ID)
+	if len(chunks) != 0 {
+		t.Fatalf("Expected chunks of deleted message to be deleted; Got %d", len(chunks))
+	}
+}
+
+func TestGetMessagesTeamScoping(t *testing.T) {
+	ctx := context.Background()
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "queue.db"))
+
+	ours, _ := db.CreateDestination(ctx, 1, "ours", "duckdb", map[string]any{})
+	theirs, _ := db.CreateDestination(ctx, 2, "theirs", "duckdb", map[string]any{})
+
+	insert, _ := db.Enqueue(models.InsertData, map[string]int{}, ours.ID, "events")
+	copied, _ := db.Enqueue(models.CopyData, map[string]int{}, ours.ID, "users")
+	other, _ := db.Enqueue(models.InsertData, map[string]int{}, theirs.ID, "events")
+	db.CompleteMessage(copied.ID, 1, 1)
+
+	messages, err := db.GetMessages(ctx, 1, models.MessageFilter{})
+	if err != nil {
+		t.Fatalf("Unable to get messages: %s", err)
+	}
+	if len(messages) != 2 || messages[0].ID != copied.ID || messages[1].ID != insert.ID {
+		t.Fatalf("Expected only the team's messages, newest first; Got %d messages", len(messages))
+	}
+
+	filters := []models.MessageFilter{
+		{MessageType: models.InsertData},
+		{Status: models.New},
+		{DestinationTable: "events"},
+	}
+	for _, filter := range filters {
+		messages, _ := db.GetMessages(ctx, 1, filter)
+		if len(messages) != 1 || messages[0].ID != insert.ID {
+			t.Fatalf("Expected message %d for filter %+v; Got %d messages", insert.ID, filter, len(messages))
+		}
+	}
+
+	if messages, _ := db.GetMessages(ctx, 1, models.MessageFilter{Limit: 1, Offset: 1}); len(messages) != 1 || messages[0].ID != insert.ID {
+		t.Fatalf("Expected the second page to hold message %d", insert.ID)
+	}
+
+	if _, err := db.GetMessage(ctx, 1, other.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("Expected another team's message not to be found; Got %v", err)
+	}
+	if m, err := db.GetMessage(ctx, 2, other.ID); err != nil || m.ID != other.ID {
+		t.Fatalf("Expected message %d for its own team; Got %v", other.ID, err)
+	}
+}
+
+func TestReclaimMessages(t *testing.T) {
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "queue.db"))
+
+	stale, _ := db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+	exhausted, _ := db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+	fresh, _ := db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+
+	for i := 0; i < 3; i++ {
+		if _, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); !ok {
+			t.Fatalf("Unable to claim message")
+		}
+	}
+
+	// The exhausted message has already been attempted as many times as allowed
+	db.db.Model(&models.Message{}).Where("id = ?", exhausted.ID).Update("attempts", 3)
+
+	staleBefore := time.Now().Add(-time.Minute)
+	db.db.Model(&models.Message{}).
+		Where("id IN ?", []uint{stale.ID, exhausted.ID}).
+		Update("heartbeat_at", staleBefore.Add(-time.Minute))
+
+	reclaimed, err := db.ReclaimMessages(staleBefore, 3)
+	if err != nil {
+		t.Fatalf("Unable to reclaim messages: %s", err)
+	}
+	if reclaimed != 2 {
+		t.Fatalf("Expected 2 messages to be reclaimed; Got %d", reclaimed)
+	}
+
+	expected := map[uint]models.MessageStatus{
+		stale.ID:     models.New,
+		exhausted.ID: models.DeadLetter,
+		fresh.ID:     models.Claimed,
+	}
+	for id, status := range expected {
+		var message models.Message
+		db.db.First(&message, id)
+		if message.Status != status {
+			t.Fatalf("Expected message %d to be %s; Got %s", id, status, message.Status)
+		}
+	}
+
+	if m, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); !ok || m.ID != stale.ID {
+		t.Fatalf("Expected reclaimed message %d to be dequeued again", stale.ID)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "queue.db"))
+
+	db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+	message, ok := db.Dequeue(models.InsertData, "worker-a", models.DequeueOptions{})
+	if !ok {
+		t.Fatalf("Unable to claim message")
+	}
+
+	old := time.Now().Add(-time.Hour)
+	db.db.Model(&models.Message{}).Where("id = ?", message.ID).Update("heartbeat_at", old)
+
+	// A worker which didn't claim the message can't keep it alive
+	if err := db.Heartbeat(message.ID, "worker-b"); err != nil {
+		t.Fatalf("Unable to send heartbeat: %s", err)
+	}
+	if reclaimed, _ := db.ReclaimMessages(old.Add(time.Minute), 5); reclaimed != 1 {
+		t.Fatalf("Expected heartbeat from another worker to be ignored; Got %d reclaimed", reclaimed)
+	}
+
+	message, ok = db.Dequeue(models.InsertData, "worker-a", models.DequeueOptions{})
+	if !ok {
+		t.Fatalf("Unable to claim message again")
+	}
+	db.db.Model(&models.Message{}).Where("id = ?", message.ID).Update("heartbeat_at", old)
+
+	if err := db.Heartbeat(message.ID, "worker-a"); err != nil {
+		t.Fatalf("Unable to send heartbeat: %s", err)
+	}
+	if reclaimed, _ := db.ReclaimMessages(old.Add(time.Minute), 5); reclaimed != 0 {
+		t.Fatalf("Expected message with a fresh heartbeat not to be reclaimed; Got %d reclaimed", reclaimed)
+	}
+}
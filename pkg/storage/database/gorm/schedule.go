@@ -0,0 +1,141 @@
+package gorm
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	"gorm.io/gorm"
+)
+
+func (db *Gorm) CreateSchedule(ctx context.Context, schedule *models.Schedule) error {
+	res := db.db.WithContext(ctx).Create(schedule)
+	return res.Error
+}
+
+func (db *Gorm) GetSchedule(ctx context.Context, teamId uint, id uint) (models.Schedule, error) {
+	var schedule models.Schedule
+	res := db.db.WithContext(ctx).First(&schedule, "team_id = ? AND id = ?", teamId, id)
+	return schedule, res.Error
+}
+
+func (db *Gorm) GetSchedules(ctx context.Context, teamId uint) ([]models.Schedule, error) {
+	var schedules []models.Schedule
+	res := db.db.WithContext(ctx).Where("team_id = ?", teamId).Order("id").Find(&schedules)
+	return schedules, res.Error
+}
+
+func (db *Gorm) UpdateSchedule(ctx context.Context, schedule *models.Schedule) error {
+	res := db.db.WithContext(ctx).
+		Where("team_id = ?", schedule.TeamID).
+		Select("*").
+		Omit("created_at").
+		Updates(schedule)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (db *Gorm) DeleteSchedule(ctx context.Context, teamId uint, id uint) error {
+	res := db.db.WithContext(ctx).Delete(&models.Schedule{}, "team_id = ? AND id = ?", teamId, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetScheduleRuns returns the most recent runs of a schedule, along with the status of each copy job
+func (db *Gorm) GetScheduleRuns(ctx context.Context, teamId uint, scheduleId uint, limit int) ([]models.ScheduleRun, error) {
+	if _, err := db.GetSchedule(ctx, teamId, scheduleId); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = 100
+	} else if limit > 1000 {
+		limit = 1000
+	}
+
+	var runs []models.ScheduleRun
+	res := db.db.WithContext(ctx).
+		Select("schedule_runs.*, messages.status AS job_status").
+		Joins("LEFT JOIN messages ON messages.id = schedule_runs.message_id").
+		Where("schedule_runs.schedule_id = ?", scheduleId).
+		Order("schedule_runs.id DESC").
+		Limit(limit).
+		Find(&runs)
+	return runs, res.Error
+}
+
+// DueSchedules returns enabled schedules whose next run is at or before now
+func (db *Gorm) DueSchedules(now time.Time) ([]models.Schedule, error) {
+	var schedules []models.Schedule
+	res := db.db.Where("enabled = ? AND next_run_at <= ?", true, now).Order("next_run_at").Find(&schedules)
+	return schedules, res.Error
+}
+
+// RunSchedule enqueues a copy for a due schedule, records the run and moves the
+// schedule on to nextRunAt, all in one transaction. The schedule is only run if its
+// next run time hasn't changed since it was read, so when several schedulers see the
+// same due schedule only one of them enqueues it. The returned bool reports whether
+// this call was the one that ran it.
+func (db *Gorm) RunSchedule(schedule models.Schedule, nextRunAt time.Time, message any) (models.ScheduleRun, bool, error) {
+	var run models.ScheduleRun
+	ran := false
+
+	mStr, err := json.Marshal(message)
+	if err != nil {
+		return run, false, err
+	}
+
+	err = db.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&models.Schedule{}).
+			Where("id = ? AND next_run_at = ?", schedule.ID, schedule.NextRunAt).
+			Updates(map[string]any{
+				"next_run_at": nextRunAt,
+				"last_run_at": now,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+
+		msg := models.Message{
+			MessageType:      models.CopyData,
+			Status:           models.New,
+			Message:          string(mStr),
+			AvailableAt:      now,
+			DestinationID:    schedule.DestinationID,
+			DestinationTable: schedule.DestinationTable,
+		}
+		if err := tx.Create(&msg).Error; err != nil {
+			return err
+		}
+
+		run = models.ScheduleRun{
+			ScheduleID:   schedule.ID,
+			MessageID:    msg.ID,
+			ScheduledFor: schedule.NextRunAt,
+			JobStatus:    msg.Status,
+		}
+		if err := tx.Create(&run).Error; err != nil {
+			return err
+		}
+
+		ran = true
+		return nil
+	})
+
+	return run, ran, err
+}
@@ -0,0 +1,82 @@
+package gorm
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+)
+
+func TestRunSchedule(t *testing.T) {
+	ctx := context.Background()
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "schedule.db"))
+
+	schedule := models.Schedule{
+		TeamID:           1,
+		SourceID:         1,
+		Query:            "select 1",
+		DestinationID:    2,
+		DestinationTable: "t",
+		Cron:             "@hourly",
+		Enabled:          true,
+		NextRunAt:        time.Now().Add(-time.Minute).Truncate(time.Second),
+	}
+	if err := db.CreateSchedule(ctx, &schedule); err != nil {
+		t.Fatalf("Unable to create schedule: %s", err)
+	}
+
+	due, err := db.DueSchedules(time.Now())
+	if err != nil || len(due) != 1 {
+		t.Fatalf("Expected 1 due schedule; Got %d (%v)", len(due), err)
+	}
+
+	nextRunAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	run, ok, err := db.RunSchedule(due[0], nextRunAt, map[string]string{"query": "select 1"})
+	if err != nil || !ok {
+		t.Fatalf("Expected schedule to run; Got %v (%v)", ok, err)
+	}
+
+	// A second scheduler which read the same due schedule must not run it again
+	if _, ok, err := db.RunSchedule(due[0], nextRunAt, map[string]string{}); err != nil || ok {
+		t.Fatalf("Expected schedule not to run twice; Got %v (%v)", ok, err)
+	}
+
+	if due, _ := db.DueSchedules(time.Now()); len(due) != 0 {
+		t.Fatalf("Expected no due schedules after running; Got %d", len(due))
+	}
+
+	message, ok := db.Dequeue(models.CopyData, "worker", models.DequeueOptions{})
+	if !ok || message.ID != run.MessageID || message.DestinationID != 2 {
+		t.Fatalf("Expected copy message %d to be enqueued for destination 2", run.MessageID)
+	}
+
+	runs, err := db.GetScheduleRuns(ctx, 1, schedule.ID, 0)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("Expected 1 run; Got %d (%v)", len(runs), err)
+	}
+	if runs[0].JobStatus != models.Claimed {
+		t.Fatalf("Expected run job status %s; Got %s", models.Claimed, runs[0].JobStatus)
+	}
+
+	if _, err := db.GetScheduleRuns(ctx, 2, schedule.ID, 0); err == nil {
+		t.Fatalf("Expected runs not to be visible to another team")
+	}
+
+	schedule.Enabled = false
+	schedule.NextRunAt = time.Now().Add(-time.Minute)
+	if err := db.UpdateSchedule(ctx, &schedule); err != nil {
+		t.Fatalf("Unable to update schedule: %s", err)
+	}
+	if due, _ := db.DueSchedules(time.Now()); len(due) != 0 {
+		t.Fatalf("Expected disabled schedule not to be due")
+	}
+
+	if err := db.DeleteSchedule(ctx, 1, schedule.ID); err != nil {
+		t.Fatalf("Unable to delete schedule: %s", err)
+	}
+	if schedules, _ := db.GetSchedules(ctx, 1); len(schedules) != 0 {
+		t.Fatalf("Expected schedule to be deleted")
+	}
+}
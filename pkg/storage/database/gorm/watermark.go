@@ -0,0 +1,26 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	"gorm.io/gorm/clause"
+)
+
+func (db *Gorm) GetWatermark(key string) (models.Watermark, error) {
+	var watermark models.Watermark
+	res := db.db.First(&watermark, "key = ?", key)
+	return watermark, res.Error
+}
+
+func (db *Gorm) SetWatermark(key string, value string) error {
+	watermark := models.Watermark{Key: key, Value: value}
+	res := db.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"value":      value,
+			"updated_at": time.Now(),
+		}),
+	}).Create(&watermark)
+	return res.Error
+}
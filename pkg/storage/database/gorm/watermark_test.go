@@ -0,0 +1,28 @@
+package gorm
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestWatermark(t *testing.T) {
+	db := newTestGorm(t, filepath.Join(t.TempDir(), "watermark.db"))
+
+	if _, err := db.GetWatermark("copy"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("Expected no watermark; Got %v", err)
+	}
+
+	for _, value := range []string{"1", "2"} {
+		if err := db.SetWatermark("copy", value); err != nil {
+			t.Fatalf("Unable to set watermark: %s", err)
+		}
+	}
+
+	wm, err := db.GetWatermark("copy")
+	if err != nil || wm.Value != "2" {
+		t.Fatalf("Expected watermark 2; Got %q (%v)", wm.Value, err)
+	}
+}
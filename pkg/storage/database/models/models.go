@@ -1,6 +1,7 @@
 package models
 
 import (
+	"errors"
 	"time"
 
 	"github.com/scratchdata/scratchdata/pkg/config"
@@ -74,18 +75,131 @@ const CopyData MessageType = "COPY_DATA"
 
 type MessageStatus string
 
+var ErrNotDeadLettered = errors.New("message is not dead lettered")
+
 const New MessageStatus = "NEW"
 const Claimed MessageStatus = "CLAIMED"
+const Running MessageStatus = "RUNNING"
+const Succeeded MessageStatus = "SUCCEEDED"
+const Failed MessageStatus = "FAILED"
+const DeadLetter MessageStatus = "DEAD_LETTER"
 
+// Message is both a queued unit of work and the record of what happened to it,
+// which is exposed through the API as a job
 type Message struct {
 	gorm.Model
 	MessageType MessageType   `gorm:"index"`
 	Status      MessageStatus `gorm:"index"`
 	ClaimedAt   time.Time
 	ClaimedBy   string
+	HeartbeatAt time.Time
 	Message     string
 
-	// For future pause/unpause
+	Attempts       int
+	AvailableAt    time.Time `gorm:"index"`
+	StartedAt      time.Time
+	CompletedAt    time.Time
+	Error          string
+	RowsProcessed  int64
+	BytesProcessed int64
+
+	// Copies load data in chunks. See CopyChunk.
+	ChunksTotal  int
+	ChunksFailed int
+
+	// Where the data in this message is being written
 	DestinationID    uint   `gorm:"index"`
 	DestinationTable string `gorm:"index"`
 }
+
+// MessageFilter narrows down a list of messages. Zero values are ignored.
+type MessageFilter struct {
+	MessageType      MessageType
+	Status           MessageStatus
+	DestinationID    uint
+	DestinationTable string
+	CreatedAfter     time.Time
+	CreatedBefore    time.Time
+	Limit            int
+	Offset           int
+}
+
+// DequeueOptions controls which message is claimed next
+type DequeueOptions struct {
+	// Limit on claimed or running messages for destinations which don't set
+	// max_in_flight. 0 means no limit.
+	DefaultMaxInFlight int
+
+	// Destinations are served round-robin: the next message comes from the
+	// first destination after this one which has work waiting
+	AfterDestinationID uint
+}
+
+// Pause stops workers from delivering queued messages to a destination. An empty
+// DestinationTable pauses every table in the destination. Messages keep
+// accumulating in the queue while paused and are delivered once resumed.
+type Pause struct {
+	gorm.Model
+	DestinationID    uint   `gorm:"index:idx_pause_destination_table,unique"`
+	DestinationTable string `gorm:"index:idx_pause_destination_table,unique"`
+}
+
+// Schedule is a copy which is run on a cron schedule
+type Schedule struct {
+	gorm.Model
+	TeamID uint `gorm:"index"`
+
+	// The copy to run. SourceID is the destination the query is run against.
+	SourceID         uint
+	Query            string
+	DestinationID    uint
+	DestinationTable string
+
+	// See CopyDataMessage for how these change the copy
+	IncrementalColumn string
+	Mode              string
+	KeyColumn         string
+
+	Cron      string
+	Enabled   bool
+	NextRunAt time.Time `gorm:"index"`
+	LastRunAt time.Time
+}
+
+// ScheduleRun records each time a schedule enqueued a copy
+type ScheduleRun struct {
+	gorm.Model
+	ScheduleID   uint `gorm:"index"`
+	MessageID    uint
+	ScheduledFor time.Time
+
+	// Status of the copy job, read from the message
+	JobStatus MessageStatus `gorm:"->;-:migration"`
+}
+
+// Watermark is the highest value of the incremental column copied so far by
+// an incremental copy. Value is the raw JSON value as returned by the source.
+type Watermark struct {
+	gorm.Model
+	Key   string `gorm:"index:idx_watermark_key,unique"`
+	Value string
+}
+
+type ChunkStatus string
+
+const ChunkLoaded ChunkStatus = "LOADED"
+const ChunkFailed ChunkStatus = "FAILED"
+
+// CopyChunk is the outcome of loading one chunk of a copy's data into the destination.
+// Failed chunks are kept in the blob store under Key so that a retry only needs to
+// load those chunks, rather than running the whole copy again.
+type CopyChunk struct {
+	gorm.Model
+	MessageID uint `gorm:"index"`
+	Name      string
+	Key       string
+	Status    ChunkStatus
+	Rows      int64
+	Bytes     int64
+	Error     string
+}
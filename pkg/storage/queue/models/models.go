@@ -1,14 +1,81 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+
+	"github.com/scratchdata/scratchdata/pkg/util"
+)
+
 type FileUploadMessage struct {
 	DatabaseID int64  `json:"database_id"`
 	Table      string `json:"table"`
 	Key        string `json:"key"`
+
+	// Column types from the schema of an uploaded file. When set they are used
+	// instead of inferring types from the data.
+	Columns map[string]string `json:"columns,omitempty"`
+
+	// Staging format of the file, such as gzip or parquet. Empty for NDJSON.
+	Format string `json:"format,omitempty"`
 }
 
+// CopyMode is how copied rows are written to the destination table
+type CopyMode string
+
+const (
+	// Add rows to the table
+	CopyAppend CopyMode = "append"
+
+	// Atomically replace the contents of the table with the copied rows
+	CopyReplace CopyMode = "replace"
+
+	// Replace rows which have the same KeyColumn, and add the rest
+	CopyUpsert CopyMode = "upsert"
+)
+
 type CopyDataMessage struct {
 	SourceID         int64  `json:"source_id"`
 	Query            string `json:"query"`
 	DestinationID    uint   `json:"destination_id"`
 	DestinationTable string `json:"destination_table"`
+
+	// When set, only rows where this column is greater than the highest
+	// value copied by the previous run of the same copy are copied
+	IncrementalColumn string `json:"incremental_column,omitempty"`
+
+	Mode      CopyMode `json:"mode,omitempty"`
+	KeyColumn string   `json:"key_column,omitempty"`
+}
+
+// WriteMode returns the copy mode, defaulting to append
+func (m CopyDataMessage) WriteMode() CopyMode {
+	if m.Mode == "" {
+		return CopyAppend
+	}
+	return m.Mode
+}
+
+// Validate checks the options which change how a copy is run. Column names are put
+// into queries as-is, so they must be plain identifiers.
+func (m CopyDataMessage) Validate() error {
+	if m.IncrementalColumn != "" && !util.IsIdentifier(m.IncrementalColumn) {
+		return errors.New("invalid incremental_column")
+	}
+
+	switch m.WriteMode() {
+	case CopyAppend:
+	case CopyReplace:
+		if m.IncrementalColumn != "" {
+			return errors.New("replace mode can't be used with incremental_column")
+		}
+	case CopyUpsert:
+		if !util.IsIdentifier(m.KeyColumn) {
+			return errors.New("upsert mode requires a valid key_column")
+		}
+	default:
+		return fmt.Errorf("invalid mode %q: must be append, replace or upsert", m.Mode)
+	}
+
+	return nil
 }
@@ -0,0 +1,30 @@
+package models
+
+import "testing"
+
+func TestCopyDataMessageValidate(t *testing.T) {
+	valid := []CopyDataMessage{
+		{},
+		{Mode: CopyAppend, IncrementalColumn: "__row_id"},
+		{Mode: CopyReplace},
+		{Mode: CopyUpsert, KeyColumn: "id", IncrementalColumn: "updated_at"},
+	}
+	for _, m := range valid {
+		if err := m.Validate(); err != nil {
+			t.Fatalf("Expected %+v to be valid; Got %s", m, err)
+		}
+	}
+
+	invalid := []CopyDataMessage{
+		{Mode: "overwrite"},
+		{Mode: CopyReplace, IncrementalColumn: "__row_id"},
+		{Mode: CopyUpsert},
+		{Mode: CopyUpsert, KeyColumn: "id; drop table t"},
+		{IncrementalColumn: "id desc"},
+	}
+	for _, m := range invalid {
+		if err := m.Validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", m)
+		}
+	}
+}
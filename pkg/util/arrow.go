@@ -0,0 +1,195 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+)
+
+// ArrowColumnType maps an Arrow type onto the column types used when creating
+// columns. Types without an equivalent, such as lists and structs, are stored as
+// strings.
+func ArrowColumnType(dataType arrow.DataType) string {
+	switch t := dataType.(type) {
+	case *arrow.BooleanType:
+		return "bool"
+	case *arrow.Int8Type, *arrow.Int16Type, *arrow.Int32Type, *arrow.Int64Type,
+		*arrow.Uint8Type, *arrow.Uint16Type, *arrow.Uint32Type:
+		return "int"
+	case *arrow.Uint64Type:
+		// Values above math.MaxInt64 don't fit in a signed integer column
+		return DecimalType(20, 0)
+	case *arrow.Float16Type, *arrow.Float32Type, *arrow.Float64Type:
+		return "float"
+	case *arrow.TimestampType:
+		return "timestamp"
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "date"
+	case *arrow.Decimal128Type:
+		return DecimalType(int(t.Precision), int(t.Scale))
+	case *arrow.Decimal256Type:
+		return DecimalType(int(t.Precision), int(t.Scale))
+	}
+
+	return "string"
+}
+
+// ArrowColumnTypes returns the column type of each field in a schema
+func ArrowColumnTypes(schema *arrow.Schema) map[string]string {
+	rc := map[string]string{}
+	for _, field := range schema.Fields() {
+		rc[field.Name] = ArrowColumnType(field.Type)
+	}
+	return rc
+}
+
+// arrowValue converts a single value into something which can be marshalled to
+// JSON. Timestamps are RFC 3339 in UTC, and decimals and uint64s are strings,
+// so that none of them lose precision.
+func arrowValue(column arrow.Array, i int) any {
+	if column.IsNull(i) {
+		return nil
+	}
+
+	switch c := column.(type) {
+	case *array.Timestamp:
+		unit := c.DataType().(*arrow.TimestampType).Unit
+		return c.Value(i).ToTime(unit).Format(time.RFC3339Nano)
+	case *array.Date32:
+		return c.Value(i).ToTime().Format(time.DateOnly)
+	case *array.Date64:
+		return c.Value(i).ToTime().Format(time.DateOnly)
+	case *array.Decimal128:
+		return c.Value(i).ToString(c.DataType().(*arrow.Decimal128Type).Scale)
+	case *array.Decimal256:
+		return c.Value(i).ToString(c.DataType().(*arrow.Decimal256Type).Scale)
+	case *array.Uint64:
+		return strconv.FormatUint(c.Value(i), 10)
+	case *array.Float32:
+		return finiteOrNil(float64(c.Value(i)))
+	case *array.Float64:
+		return finiteOrNil(c.Value(i))
+	case *array.Binary:
+		return string(c.Value(i))
+	case *array.LargeBinary:
+		return string(c.Value(i))
+	}
+
+	value := column.GetOneForMarshal(i)
+
+	// Nested values are kept as JSON text in a string column
+	if arrow.IsNested(column.DataType().ID()) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+		return string(data)
+	}
+
+	return value
+}
+
+// JSON has no way to write NaN or infinity
+func finiteOrNil(f float64) any {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return f
+}
+
+// JSONField is a value written to every row by WriteArrowNDJSON
+type JSONField struct {
+	Name  string
+	Value any
+}
+
+// WriteArrowNDJSON writes each row of a record as a line of JSON. If the record
+// doesn't have a __row_id column, one is added using rowID. Each of extra is
+// added to every row, replacing the record's column of the same name.
+func WriteArrowNDJSON(record arrow.Record, w io.Writer, rowID func() int64, extra ...JSONField) error {
+	fields := record.Schema().Fields()
+
+	var extraJSON bytes.Buffer
+	replaced := map[string]bool{}
+	for _, field := range extra {
+		key, err := json.Marshal(field.Name)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return err
+		}
+		extraJSON.WriteByte(',')
+		extraJSON.Write(key)
+		extraJSON.WriteByte(':')
+		extraJSON.Write(value)
+		replaced[field.Name] = true
+	}
+
+	keys := make([][]byte, len(fields))
+	hasRowID := false
+	for i, field := range fields {
+		key, err := json.Marshal(field.Name)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+		hasRowID = hasRowID || field.Name == "__row_id"
+	}
+
+	var line bytes.Buffer
+	for row := 0; row < int(record.NumRows()); row++ {
+		line.Reset()
+		line.WriteByte('{')
+
+		for i, column := range record.Columns() {
+			if replaced[fields[i].Name] {
+				continue
+			}
+			if line.Len() > 1 {
+				line.WriteByte(',')
+			}
+
+			value, err := json.Marshal(arrowValue(column, row))
+			if err != nil {
+				return err
+			}
+
+			line.Write(keys[i])
+			line.WriteByte(':')
+			line.Write(value)
+		}
+
+		if !hasRowID {
+			if line.Len() > 1 {
+				line.WriteByte(',')
+			}
+			line.WriteString(`"__row_id":`)
+			line.WriteString(strconv.FormatInt(rowID(), 10))
+		}
+
+		if extraJSON.Len() > 0 {
+			if line.Len() > 1 {
+				line.Write(extraJSON.Bytes())
+			} else {
+				line.Write(extraJSON.Bytes()[1:])
+			}
+		}
+
+		line.WriteString("}\n")
+
+		_, err := w.Write(line.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package util
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/decimal128"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+)
+
+func TestArrowColumnTypes(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Uint32},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float32},
+		{Name: "ok", Type: arrow.FixedWidthTypes.Boolean},
+		{Name: "at", Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}},
+		{Name: "day", Type: arrow.FixedWidthTypes.Date32},
+		{Name: "price", Type: &arrow.Decimal128Type{Precision: 12, Scale: 3}},
+		{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String)},
+		{Name: "big", Type: arrow.PrimitiveTypes.Uint64},
+	}, nil)
+
+	expected := map[string]string{
+		"id":    "int",
+		"score": "float",
+		"ok":    "bool",
+		"at":    "timestamp",
+		"day":   "date",
+		"price": "decimal(12,3)",
+		"tags":  "string",
+		"big":   "decimal(20,0)",
+	}
+
+	types := ArrowColumnTypes(schema)
+	for name, colType := range expected {
+		if types[name] != colType {
+			t.Fatalf("Expected %s to be %s; Got %s", name, colType, types[name])
+		}
+	}
+
+	if precision, scale, ok := ParseDecimalType(types["price"]); !ok || precision != 12 || scale != 3 {
+		t.Fatalf("Expected decimal(12,3) to parse; Got %d %d %v", precision, scale, ok)
+	}
+}
+
+func TestWriteArrowNDJSON(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "at", Type: &arrow.TimestampType{Unit: arrow.Millisecond}, Nullable: true},
+		{Name: "day", Type: arrow.FixedWidthTypes.Date32},
+		{Name: "price", Type: &arrow.Decimal128Type{Precision: 20, Scale: 2}},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String)},
+		{Name: "big", Type: arrow.PrimitiveTypes.Uint64},
+	}, nil)
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	at := time.Date(2024, 3, 1, 12, 30, 0, 500_000_000, time.UTC)
+	builder.Field(0).(*array.TimestampBuilder).AppendValues([]arrow.Timestamp{arrow.Timestamp(at.UnixMilli()), 0}, []bool{true, false})
+	builder.Field(1).(*array.Date32Builder).AppendValues([]arrow.Date32{arrow.Date32FromTime(at), 0}, nil)
+	builder.Field(2).(*array.Decimal128Builder).AppendValues([]decimal128.Num{decimal128.FromI64(12345678901234567), decimal128.FromI64(-5)}, nil)
+	builder.Field(3).(*array.Float64Builder).AppendValues([]float64{1.5, math.NaN()}, nil)
+
+	tags := builder.Field(4).(*array.ListBuilder)
+	tags.Append(true)
+	tags.ValueBuilder().(*array.StringBuilder).AppendValues([]string{"a", "b"}, nil)
+	tags.Append(true)
+	builder.Field(5).(*array.Uint64Builder).AppendValues([]uint64{math.MaxUint64, 7}, nil)
+
+	record := builder.NewRecord()
+	defer record.Release()
+
+	var out bytes.Buffer
+	id := int64(0)
+	err := WriteArrowNDJSON(record, &out, func() int64 { id++; return id })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"at":"2024-03-01T12:30:00.5Z","day":"2024-03-01","price":"123456789012345.67","score":1.5,"tags":"[\"a\",\"b\"]","big":"18446744073709551615","__row_id":1}
+{"at":null,"day":"1970-01-01","price":"-0.05","score":null,"tags":"[]","big":"7","__row_id":2}
+`
+	if out.String() != expected {
+		t.Fatalf("Expected %s; Got %s", expected, out.String())
+	}
+}
+
+func TestWriteArrowNDJSONExtraFields(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "user", Type: arrow.BinaryTypes.String},
+		{Name: "__source_ip", Type: arrow.BinaryTypes.String},
+	}, nil)
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+	builder.Field(0).(*array.StringBuilder).AppendValues([]string{"alice"}, nil)
+	builder.Field(1).(*array.StringBuilder).AppendValues([]string{"10.0.0.1"}, nil)
+
+	record := builder.NewRecord()
+	defer record.Release()
+
+	var out bytes.Buffer
+	err := WriteArrowNDJSON(record, &out, func() int64 { return 1 },
+		JSONField{Name: "__source_ip", Value: "192.0.2.1"}, JSONField{Name: "__api_key_id", Value: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"user":"alice","__row_id":1,"__source_ip":"192.0.2.1","__api_key_id":7}` + "\n"
+	if out.String() != expected {
+		t.Fatalf("Expected %s; Got %s", expected, out.String())
+	}
+}
@@ -18,6 +18,8 @@ type ChunkedWriter struct {
 	currentChunkSize int
 	currentSize      int
 	fileCount        int
+	rowCount         int
+	lineOpen         bool
 
 	mu *sync.Mutex
 
@@ -87,6 +89,11 @@ func (w *ChunkedWriter) Write(data []byte) (int, error) {
 		w.currentChunkSize += n
 		w.currentSize += n
 
+		if newlinePosition > -1 {
+			w.rowCount++
+		}
+		w.lineOpen = newlinePosition == -1
+
 		// if the current chunk is at capacity and there is another line available
 		// then close the current file
 		if w.currentChunkSize >= w.chunkSize && newlinePosition > -1 {
@@ -118,6 +125,26 @@ func (w *ChunkedWriter) Write(data []byte) (int, error) {
 	return written, nil
 }
 
+// Size returns the total number of bytes written across all chunks
+func (w *ChunkedWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.currentSize
+}
+
+// Rows returns the number of lines written across all chunks, including
+// a final line without a trailing newline
+func (w *ChunkedWriter) Rows() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lineOpen {
+		return w.rowCount + 1
+	}
+	return w.rowCount
+}
+
 func (w *ChunkedWriter) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -0,0 +1,18 @@
+package util
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// NextCronTime returns the first time after `after` matched by a standard
+// 5 field cron expression, or a descriptor such as @hourly. Times are in UTC
+// unless the expression starts with CRON_TZ=.
+func NextCronTime(expr string, after time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(after.UTC()), nil
+}
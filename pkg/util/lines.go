@@ -0,0 +1,34 @@
+package util
+
+import (
+	"bytes"
+	"io"
+)
+
+// CountLines returns the number of lines in r, including a final line
+// without a trailing newline
+func CountLines(r io.Reader) (int64, error) {
+	var count int64
+	lineOpen := false
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			count += int64(bytes.Count(buf[:n], []byte{'\n'}))
+			lineOpen = buf[n-1] != '\n'
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+	}
+
+	if lineOpen {
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,593 @@
+package util
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/decimal128"
+	"github.com/apache/arrow/go/v14/arrow/decimal256"
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet"
+	"github.com/apache/arrow/go/v14/parquet/compress"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"github.com/xuri/excelize/v2"
+)
+
+// Formats which keep the types of query results
+const (
+	FormatParquet = "parquet"
+	FormatArrow   = "arrow"
+	FormatXLSX    = "xlsx"
+)
+
+// Rows written to each Arrow record or Parquet row group
+const resultBatchSize = 64 * 1024
+
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+// ResultColumn is a column of a query result. Type is one of the column types
+// used when creating columns, such as "int", "timestamp" or "decimal(10,2)".
+type ResultColumn struct {
+	Name string
+	Type string
+}
+
+// ResultWriter writes query results in a typed format. Values are converted to
+// the type of their column, and Close must be called to finish the file.
+type ResultWriter interface {
+	WriteRow(values []any) error
+	Close() error
+
+	// Discard releases the writer's memory and temporary files without
+	// finishing the file. It does nothing once the writer is closed, so it can
+	// be deferred to clean up after errors.
+	Discard()
+}
+
+// IsResultFormat returns whether format can be written by NewResultWriter
+func IsResultFormat(format string) bool {
+	return format == FormatParquet || format == FormatArrow || format == FormatXLSX
+}
+
+func NewResultWriter(format string, columns []ResultColumn, w io.Writer) (ResultWriter, error) {
+	switch format {
+	case FormatParquet, FormatArrow:
+		return newArrowResultWriter(format, columns, w)
+	case FormatXLSX:
+		return newXLSXResultWriter(columns, w)
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+}
+
+// resultArrowType is the Arrow type used to store a column. It is the reverse of
+// ArrowColumnType.
+func resultArrowType(columnType string) arrow.DataType {
+	switch columnType {
+	case "int":
+		return arrow.PrimitiveTypes.Int64
+	case "float":
+		return arrow.PrimitiveTypes.Float64
+	case "bool":
+		return arrow.FixedWidthTypes.Boolean
+	case "timestamp":
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+	case "date":
+		return arrow.FixedWidthTypes.Date32
+	}
+
+	if precision, scale, ok := ParseDecimalType(columnType); ok {
+		if precision <= 38 {
+			return &arrow.Decimal128Type{Precision: int32(precision), Scale: int32(scale)}
+		}
+		if precision <= 76 {
+			return &arrow.Decimal256Type{Precision: int32(precision), Scale: int32(scale)}
+		}
+	}
+
+	return arrow.BinaryTypes.String
+}
+
+type arrowResultWriter struct {
+	builder *array.RecordBuilder
+	rows    int
+	closed  bool
+
+	// Only one of these is set
+	parquet *pqarrow.FileWriter
+	ipc     *ipc.Writer
+}
+
+func newArrowResultWriter(format string, columns []ResultColumn, w io.Writer) (*arrowResultWriter, error) {
+	fields := make([]arrow.Field, len(columns))
+	for i, column := range columns {
+		fields[i] = arrow.Field{Name: column.Name, Type: resultArrowType(column.Type), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	rc := &arrowResultWriter{builder: array.NewRecordBuilder(memory.DefaultAllocator, schema)}
+
+	if format == FormatParquet {
+		props := parquet.NewWriterProperties(
+			parquet.WithCompression(compress.Codecs.Snappy),
+			parquet.WithMaxRowGroupLength(resultBatchSize),
+		)
+		writer, err := pqarrow.NewFileWriter(schema, w, props, pqarrow.DefaultWriterProps())
+		if err != nil {
+			rc.builder.Release()
+			return nil, err
+		}
+		rc.parquet = writer
+	} else {
+		rc.ipc = ipc.NewWriter(w, ipc.WithSchema(schema))
+	}
+
+	return rc, nil
+}
+
+func (a *arrowResultWriter) WriteRow(values []any) error {
+	for i, field := range a.builder.Fields() {
+		var value any
+		if i < len(values) {
+			value = values[i]
+		}
+
+		err := appendArrowValue(field, value)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", a.builder.Schema().Field(i).Name, err)
+		}
+	}
+
+	a.rows++
+	if a.rows >= resultBatchSize {
+		return a.flush()
+	}
+	return nil
+}
+
+func (a *arrowResultWriter) flush() error {
+	record := a.builder.NewRecord()
+	defer record.Release()
+	a.rows = 0
+
+	if a.parquet != nil {
+		return a.parquet.Write(record)
+	}
+	return a.ipc.Write(record)
+}
+
+func (a *arrowResultWriter) Close() error {
+	defer a.Discard()
+
+	if a.rows > 0 {
+		if err := a.flush(); err != nil {
+			return err
+		}
+	}
+
+	if a.parquet != nil {
+		return a.parquet.Close()
+	}
+	return a.ipc.Close()
+}
+
+func (a *arrowResultWriter) Discard() {
+	if !a.closed {
+		a.closed = true
+		a.builder.Release()
+	}
+}
+
+func appendArrowValue(builder array.Builder, value any) error {
+	if value == nil {
+		builder.AppendNull()
+		return nil
+	}
+
+	switch b := builder.(type) {
+	case *array.Int64Builder:
+		n, err := resultInt(value)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.Float64Builder:
+		f, err := resultFloat(value)
+		if err != nil {
+			return err
+		}
+		b.Append(f)
+	case *array.BooleanBuilder:
+		v, err := resultBool(value)
+		if err != nil {
+			return err
+		}
+		b.Append(v)
+	case *array.TimestampBuilder:
+		t, err := resultTime(value, time.RFC3339Nano)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Timestamp(t.UnixMicro()))
+	case *array.Date32Builder:
+		t, err := resultTime(value, time.DateOnly)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Date32FromTime(t))
+	case *array.Decimal128Builder:
+		t := b.Type().(*arrow.Decimal128Type)
+		n, err := decimal128.FromString(resultDecimal(value, t.Scale), t.Precision, t.Scale)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.Decimal256Builder:
+		t := b.Type().(*arrow.Decimal256Type)
+		n, err := decimal256.FromString(resultDecimal(value, t.Scale), t.Precision, t.Scale)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.StringBuilder:
+		b.Append(resultString(value))
+	default:
+		return fmt.Errorf("unexpected builder %T", builder)
+	}
+
+	return nil
+}
+
+func resultInt(value any) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, fmt.Errorf("%d is too large for a 64 bit integer", v)
+		}
+		return int64(v), nil
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return 0, fmt.Errorf("%d is too large for a 64 bit integer", v)
+		}
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return strconv.ParseInt(resultString(value), 10, 64)
+}
+
+func resultFloat(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f, nil
+	}
+	if n, err := resultInt(value); err == nil {
+		return float64(n), nil
+	}
+	return strconv.ParseFloat(resultString(value), 64)
+}
+
+func resultBool(value any) (bool, error) {
+	if v, ok := value.(bool); ok {
+		return v, nil
+	}
+	return strconv.ParseBool(resultString(value))
+}
+
+func resultTime(value any, layout string) (time.Time, error) {
+	if t, ok := value.(time.Time); ok {
+		return t, nil
+	}
+	return time.Parse(layout, resultString(value))
+}
+
+// resultDecimal returns a decimal as a string, so that it doesn't lose precision
+func resultDecimal(value any, scale int32) string {
+	switch v := value.(type) {
+	case *big.Rat:
+		return v.FloatString(int(scale))
+	case float64:
+		return strconv.FormatFloat(v, 'f', int(scale), 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', int(scale), 32)
+	}
+	return resultString(value)
+}
+
+// resultString formats any value as text. Lists, maps and structs are JSON.
+func resultString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case *big.Rat:
+		return v.RatString()
+	case fmt.Stringer:
+		return v.String()
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
+		return fmt.Sprint(v)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+type xlsxResultWriter struct {
+	w       io.Writer
+	file    *excelize.File
+	stream  *excelize.StreamWriter
+	columns []ResultColumn
+	row     int
+	closed  bool
+
+	dateStyle int
+}
+
+func newXLSXResultWriter(columns []ResultColumn, w io.Writer) (*xlsxResultWriter, error) {
+	file := excelize.NewFile()
+
+	stream, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Excel's built in date format
+	dateStyle, err := file.NewStyle(&excelize.Style{NumFmt: 14})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	header := make([]any, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	err = stream.SetRow("A1", header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &xlsxResultWriter{
+		w:         w,
+		file:      file,
+		stream:    stream,
+		columns:   columns,
+		row:       1,
+		dateStyle: dateStyle,
+	}, nil
+}
+
+func (x *xlsxResultWriter) WriteRow(values []any) error {
+	cells := make([]any, len(x.columns))
+	for i, column := range x.columns {
+		if i >= len(values) || values[i] == nil {
+			continue
+		}
+
+		cell, err := xlsxValue(column.Type, values[i])
+		if err != nil {
+			return fmt.Errorf("column %s: %w", column.Name, err)
+		}
+
+		if column.Type == "date" {
+			cell = excelize.Cell{StyleID: x.dateStyle, Value: cell}
+		}
+		cells[i] = cell
+	}
+
+	x.row++
+	name, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+	return x.stream.SetRow(name, cells)
+}
+
+// xlsxValue converts a value into a number, boolean, time or string cell.
+// Decimals are numbers, as Excel has no exact decimal type.
+func xlsxValue(columnType string, value any) (any, error) {
+	switch columnType {
+	case "int":
+		return resultInt(value)
+	case "float":
+		return resultFloat(value)
+	case "bool":
+		return resultBool(value)
+	case "timestamp":
+		t, err := resultTime(value, time.RFC3339Nano)
+		return t.UTC(), err
+	case "date":
+		return resultTime(value, time.DateOnly)
+	}
+
+	if strings.HasPrefix(columnType, "decimal(") {
+		return resultFloat(value)
+	}
+
+	return resultString(value), nil
+}
+
+func (x *xlsxResultWriter) Close() error {
+	defer x.Discard()
+
+	err := x.stream.Flush()
+	if err != nil {
+		return err
+	}
+
+	return x.file.Write(x.w)
+}
+
+// Discard closes the workbook, which removes the stream's temporary files
+func (x *xlsxResultWriter) Discard() {
+	if !x.closed {
+		x.closed = true
+		x.file.Close()
+	}
+}
+
+// SQLColumnType maps a database column onto the column types used when creating
+// columns. Types without an equivalent are strings.
+func SQLColumnType(column *sql.ColumnType) string {
+	name := strings.ToUpper(column.DatabaseTypeName())
+	params := ""
+	if i := strings.Index(name, "("); i >= 0 {
+		name, params = name[:i], name[i:]
+	}
+
+	switch name {
+	case "TINYINT", "SMALLINT", "INTEGER", "INT", "BIGINT", "INT2", "INT4", "INT8",
+		"UTINYINT", "USMALLINT", "UINTEGER":
+		return "int"
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8", "DOUBLE PRECISION":
+		return "float"
+	case "BOOL", "BOOLEAN":
+		return "bool"
+	case "TIMESTAMP", "TIMESTAMPTZ", "TIMESTAMP WITH TIME ZONE", "DATETIME",
+		"TIMESTAMP_S", "TIMESTAMP_MS", "TIMESTAMP_NS":
+		return "timestamp"
+	case "DATE":
+		return "date"
+	case "DECIMAL", "NUMERIC":
+		// Numbers without a declared precision are kept as strings
+		if precision, scale, ok := column.DecimalSize(); ok && precision > 0 && scale <= precision {
+			return DecimalType(int(precision), int(scale))
+		}
+		if precision, scale, ok := ParseDecimalType("decimal" + strings.ReplaceAll(params, " ", "")); ok {
+			return DecimalType(precision, scale)
+		}
+	}
+
+	return "string"
+}
+
+// WriteSQLRows writes rows in a typed format. If convert is set, it is applied
+// to each value before it is written.
+func WriteSQLRows(rows *sql.Rows, format string, w io.Writer, convert func(any) any) error {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	columns := make([]ResultColumn, len(columnTypes))
+	for i, column := range columnTypes {
+		columns[i] = ResultColumn{Name: column.Name(), Type: SQLColumnType(column)}
+	}
+
+	writer, err := NewResultWriter(format, columns, w)
+	if err != nil {
+		return err
+	}
+	defer writer.Discard()
+
+	values := make([]any, len(columns))
+	valuePtrs := make([]any, len(columns))
+	for i := range columns {
+		valuePtrs[i] = &values[i]
+	}
+
+	for rows.Next() {
+		err = rows.Scan(valuePtrs...)
+		if err != nil {
+			return err
+		}
+
+		if convert != nil {
+			for i, value := range values {
+				values[i] = convert(value)
+			}
+		}
+
+		err = writer.WriteRow(values)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// WriteArrowRecords writes the records from an Arrow stream in a typed format
+func WriteArrowRecords(reader array.RecordReader, format string, w io.Writer) error {
+	schema := reader.Schema()
+
+	columns := make([]ResultColumn, len(schema.Fields()))
+	for i, field := range schema.Fields() {
+		columns[i] = ResultColumn{Name: field.Name, Type: ArrowColumnType(field.Type)}
+	}
+
+	writer, err := NewResultWriter(format, columns, w)
+	if err != nil {
+		return err
+	}
+	defer writer.Discard()
+
+	values := make([]any, len(columns))
+	for reader.Next() {
+		record := reader.Record()
+		for row := 0; row < int(record.NumRows()); row++ {
+			for i, column := range record.Columns() {
+				values[i] = arrowValue(column, row)
+			}
+
+			err = writer.WriteRow(values)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return writer.Close()
+}
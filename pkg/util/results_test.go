@@ -0,0 +1,166 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet/file"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"github.com/xuri/excelize/v2"
+)
+
+var testResultColumns = []ResultColumn{
+	{Name: "id", Type: "int"},
+	{Name: "at", Type: "timestamp"},
+	{Name: "day", Type: "date"},
+	{Name: "price", Type: "decimal(10,2)"},
+	{Name: "name", Type: "string"},
+}
+
+var testResultAt = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func writeTestResults(t *testing.T, format string) []byte {
+	var out bytes.Buffer
+	writer, err := NewResultWriter(format, testResultColumns, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := [][]any{
+		{int32(1), testResultAt, "2024-05-06", big.NewRat(1999, 100), []byte("alice")},
+		{"2", "2024-05-06T07:08:09Z", testResultAt, "0.5", nil},
+	}
+	for _, row := range rows {
+		if err := writer.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func checkTestRecord(t *testing.T, record arrow.Record) {
+	schema := record.Schema()
+	if schema.Field(1).Type.ID() != arrow.TIMESTAMP || schema.Field(2).Type.ID() != arrow.DATE32 || schema.Field(3).Type.ID() != arrow.DECIMAL128 {
+		t.Fatalf("Expected typed columns; Got %s", schema)
+	}
+
+	if record.NumRows() != 2 {
+		t.Fatalf("Expected 2 rows; Got %d", record.NumRows())
+	}
+
+	ids := record.Column(0).(*array.Int64)
+	prices := record.Column(3).(*array.Decimal128)
+	names := record.Column(4).(*array.String)
+	if ids.Value(1) != 2 || prices.Value(0).ToString(2) != "19.99" || names.Value(0) != "alice" || !names.IsNull(1) {
+		t.Fatalf("Unexpected values %v %v %v", ids, prices, names)
+	}
+
+	at := record.Column(1).(*array.Timestamp)
+	if !at.Value(1).ToTime(arrow.Microsecond).Equal(testResultAt) {
+		t.Fatalf("Expected %s; Got %s", testResultAt, at.Value(1).ToTime(arrow.Microsecond))
+	}
+}
+
+func TestResultWriterArrow(t *testing.T) {
+	data := writeTestResults(t, FormatArrow)
+
+	reader, err := ipc.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Release()
+
+	if !reader.Next() {
+		t.Fatalf("Expected a record; Got %v", reader.Err())
+	}
+	checkTestRecord(t, reader.Record())
+}
+
+func TestResultWriterParquet(t *testing.T) {
+	data := writeTestResults(t, FormatParquet)
+
+	pf, err := file.NewParquetReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := fr.ReadTable(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Release()
+
+	reader := array.NewTableReader(table, -1)
+	defer reader.Release()
+
+	if !reader.Next() {
+		t.Fatalf("Expected a record")
+	}
+	checkTestRecord(t, reader.Record())
+}
+
+func TestResultWriterXLSX(t *testing.T) {
+	data := writeTestResults(t, FormatXLSX)
+
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows("Sheet1", excelize.Options{RawCellValue: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 3 || rows[0][0] != "id" || rows[1][3] != "19.99" || rows[1][4] != "alice" || rows[2][0] != "2" {
+		t.Fatalf("Unexpected rows %v", rows)
+	}
+
+	// Dates are stored as Excel serial numbers
+	if rows[1][2] != "45418" {
+		t.Fatalf("Expected 2024-05-06 to be day 45418; Got %s", rows[1][2])
+	}
+}
+
+func TestResultWriterUnsupported(t *testing.T) {
+	if _, err := NewResultWriter("pdf", testResultColumns, &bytes.Buffer{}); err == nil {
+		t.Fatalf("Expected an error for an unknown format")
+	}
+}
+
+func TestResultWriterOverflow(t *testing.T) {
+	for _, format := range []string{FormatArrow, FormatParquet, FormatXLSX} {
+		writer, err := NewResultWriter(format, testResultColumns, &bytes.Buffer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Integers which don't fit are an error rather than wrapping around
+		err = writer.WriteRow([]any{uint64(math.MaxUint64)})
+		if err == nil {
+			t.Fatalf("Expected an error writing MaxUint64 as %s", format)
+		}
+
+		// Discarding twice, as a deferred Discard would after an error, is safe
+		writer.Discard()
+		writer.Discard()
+	}
+}
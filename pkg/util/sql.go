@@ -2,9 +2,12 @@ package util
 
 import (
 	"encoding/json"
+	"regexp"
 	"strings"
 )
 
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // Trims whitespace and trailing ; characters from sql
 func TrimQuery(query string) string {
 	trimmed := strings.TrimSpace(query)
@@ -12,6 +15,12 @@ func TrimQuery(query string) string {
 	return semi
 }
 
+// Reports whether s is a plain column or table name which is safe to put into
+// sql without quoting
+func IsIdentifier(s string) bool {
+	return identifierRegex.MatchString(s)
+}
+
 // Takes a string and returns a JSON-escaped version
 func JsonEscape(i string) string {
 	b, err := json.Marshal(i)
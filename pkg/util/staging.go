@@ -0,0 +1,292 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet/file"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/tidwall/gjson"
+)
+
+// Formats data sinks can stage rows in before they are loaded. Staging files
+// are NDJSON unless a format is set.
+const (
+	StagingNDJSON  = "ndjson"
+	StagingGzip    = "gzip"
+	StagingZstd    = "zstd"
+	StagingParquet = "parquet"
+)
+
+// IsStagingFormat returns whether format can be written by EncodeStaging. An
+// empty format is NDJSON.
+func IsStagingFormat(format string) bool {
+	switch format {
+	case "", StagingNDJSON, StagingGzip, StagingZstd, StagingParquet:
+		return true
+	}
+	return false
+}
+
+// StagingExtension is the file extension for a staging format
+func StagingExtension(format string) string {
+	switch format {
+	case StagingGzip:
+		return "ndjson.gz"
+	case StagingZstd:
+		return "ndjson.zst"
+	case StagingParquet:
+		return "parquet"
+	}
+	return "ndjson"
+}
+
+// EncodeStaging writes NDJSON rows in a staging format. For Parquet, column types
+// are inferred from the rows, as they are when loading NDJSON.
+func EncodeStaging(format string, ndjson io.ReadSeeker, w io.Writer) error {
+	switch format {
+	case "", StagingNDJSON:
+		_, err := io.Copy(w, ndjson)
+		return err
+	case StagingGzip:
+		gz := gzip.NewWriter(w)
+		if _, err := io.Copy(gz, ndjson); err != nil {
+			return err
+		}
+		return gz.Close()
+	case StagingZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(zw, ndjson); err != nil {
+			return err
+		}
+		return zw.Close()
+	case StagingParquet:
+		return encodeParquet(ndjson, w)
+	}
+	return fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+}
+
+func encodeParquet(ndjson io.ReadSeeker, w io.Writer) error {
+	_, err := ndjson.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	typeCounts := map[string]map[string]int{}
+	_, err = countJSONReaderTypes(ndjson, typeCounts)
+	if err != nil {
+		return err
+	}
+
+	// A column with both booleans and numbers can't be written as either, so
+	// it's kept as strings
+	jsonTypes := resolveJSONTypes(typeCounts)
+	for name, columnType := range jsonTypes {
+		if (columnType == "int" || columnType == "float") && typeCounts[name]["bool"] > 0 {
+			jsonTypes[name] = "string"
+		}
+	}
+
+	columns := make([]ResultColumn, 0, len(jsonTypes))
+	for name, columnType := range jsonTypes {
+		columns = append(columns, ResultColumn{Name: name, Type: columnType})
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Name < columns[j].Name })
+
+	writer, err := NewResultWriter(FormatParquet, columns, w)
+	if err != nil {
+		return err
+	}
+	defer writer.Discard()
+
+	_, err = ndjson.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(ndjson)
+	scanner.Buffer(make([]byte, 2_000), 100_000_000)
+
+	index := make(map[string]int, len(columns))
+	for i, column := range columns {
+		index[column.Name] = i
+	}
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		values := make([]any, len(columns))
+		gjson.ParseBytes(scanner.Bytes()).ForEach(func(key, value gjson.Result) bool {
+			if i, ok := index[key.String()]; ok {
+				values[i] = stagingValue(columns[i].Type, value)
+			}
+			return true
+		})
+
+		err = writer.WriteRow(values)
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// stagingValue converts a JSON value for a column of the given type
+func stagingValue(columnType string, value gjson.Result) any {
+	switch {
+	case value.Type == gjson.Null:
+		return nil
+	case columnType == "int" && value.Type == gjson.Number:
+		return value.Int()
+	case columnType == "float" && value.Type == gjson.Number:
+		return value.Float()
+	case columnType == "bool" && (value.Type == gjson.True || value.Type == gjson.False):
+		return value.Bool()
+	}
+	return value.String()
+}
+
+// DecodeStaging writes the rows of a staging file as NDJSON. For Parquet, the
+// types of the columns are returned too.
+func DecodeStaging(format string, path string, ndjson io.Writer) (map[string]string, error) {
+	if format == StagingParquet {
+		return decodeParquet(path, ndjson)
+	}
+
+	r, err := openStagedNDJSON(format, path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(ndjson, r)
+	return nil, err
+}
+
+// InspectStaging returns the column types and number of rows in a staging file,
+// without converting it to NDJSON. Types are inferred from the rows unless the
+// file has a schema.
+func InspectStaging(format string, path string) (map[string]string, int64, error) {
+	if format == StagingParquet {
+		return inspectParquet(path)
+	}
+
+	r, err := openStagedNDJSON(format, path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+
+	typeCounts := map[string]map[string]int{}
+	rows, err := countJSONReaderTypes(r, typeCounts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resolveJSONTypes(typeCounts), rows, nil
+}
+
+// stagedNDJSON reads the NDJSON in a possibly compressed staging file
+type stagedNDJSON struct {
+	io.Reader
+	file  *os.File
+	close func()
+}
+
+func (s *stagedNDJSON) Close() error {
+	if s.close != nil {
+		s.close()
+	}
+	return s.file.Close()
+}
+
+func openStagedNDJSON(format string, path string) (io.ReadCloser, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "", StagingNDJSON:
+		return &stagedNDJSON{Reader: input, file: input}, nil
+	case StagingGzip:
+		gz, err := gzip.NewReader(input)
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+		return &stagedNDJSON{Reader: gz, file: input, close: func() { gz.Close() }}, nil
+	case StagingZstd:
+		zr, err := zstd.NewReader(input)
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+		return &stagedNDJSON{Reader: zr, file: input, close: zr.Close}, nil
+	}
+
+	input.Close()
+	return nil, fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+}
+
+func inspectParquet(path string) (map[string]string, int64, error) {
+	pf, err := file.OpenParquetFile(path, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer pf.Close()
+
+	schema, err := pqarrow.FromParquet(pf.MetaData().Schema, nil, pf.MetaData().KeyValueMetadata())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ArrowColumnTypes(schema), pf.NumRows(), nil
+}
+
+func decodeParquet(path string, ndjson io.Writer) (map[string]string, error) {
+	pf, err := file.OpenParquetFile(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: resultBatchSize}, memory.DefaultAllocator)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := fr.GetRecordReader(context.Background(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+
+	// Rows staged by a data sink already have a __row_id
+	for reader.Next() {
+		err = WriteArrowNDJSON(reader.Record(), ndjson, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := reader.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return ArrowColumnTypes(reader.Schema()), nil
+}
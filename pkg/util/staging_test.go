@@ -0,0 +1,101 @@
+package util
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testStagingRows = `{"__row_id":1,"user":"alice","age":30,"score":1.5,"active":true}
+{"__row_id":2,"user":"bob","age":null,"score":2,"active":false}
+`
+
+func TestStagingRoundTrip(t *testing.T) {
+	for _, format := range []string{StagingNDJSON, StagingGzip, StagingZstd, StagingParquet} {
+		var staged bytes.Buffer
+		err := EncodeStaging(format, strings.NewReader(testStagingRows), &staged)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+
+		path := filepath.Join(t.TempDir(), "staged."+StagingExtension(format))
+		if err := os.WriteFile(path, staged.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var ndjson bytes.Buffer
+		columns, err := DecodeStaging(format, path, &ndjson)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+
+		if format != StagingParquet {
+			if ndjson.String() != testStagingRows {
+				t.Fatalf("%s: Expected the rows back; Got %q", format, ndjson.String())
+			}
+			continue
+		}
+
+		expected := "{\"__row_id\":1,\"active\":true,\"age\":30,\"score\":1.5,\"user\":\"alice\"}\n" +
+			"{\"__row_id\":2,\"active\":false,\"age\":null,\"score\":2,\"user\":\"bob\"}\n"
+		if ndjson.String() != expected {
+			t.Fatalf("Expected %q; Got %q", expected, ndjson.String())
+		}
+		if columns["age"] != "int" || columns["score"] != "float" || columns["active"] != "bool" || columns["user"] != "string" {
+			t.Fatalf("Expected inferred column types; Got %v", columns)
+		}
+	}
+
+	if EncodeStaging("lz4", strings.NewReader(testStagingRows), &bytes.Buffer{}) == nil {
+		t.Fatalf("Expected an error for an unknown format")
+	}
+}
+
+func TestStagingMixedTypes(t *testing.T) {
+	rows := "{\"__row_id\":1,\"v\":1}\n{\"__row_id\":2,\"v\":true}\n{\"__row_id\":3,\"v\":null}\n"
+
+	var staged bytes.Buffer
+	if err := EncodeStaging(StagingParquet, strings.NewReader(rows), &staged); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "staged.parquet")
+	if err := os.WriteFile(path, staged.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var ndjson bytes.Buffer
+	columns, err := DecodeStaging(StagingParquet, path, &ndjson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "{\"__row_id\":1,\"v\":\"1\"}\n{\"__row_id\":2,\"v\":\"true\"}\n{\"__row_id\":3,\"v\":null}\n"
+	if columns["v"] != "string" || ndjson.String() != expected {
+		t.Fatalf("Expected a mixed column to be kept as strings; Got %v %q", columns, ndjson.String())
+	}
+}
+
+func TestInspectStaging(t *testing.T) {
+	for _, format := range []string{StagingZstd, StagingParquet} {
+		var staged bytes.Buffer
+		if err := EncodeStaging(format, strings.NewReader(testStagingRows), &staged); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), "staged."+StagingExtension(format))
+		if err := os.WriteFile(path, staged.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		columns, rows, err := InspectStaging(format, path)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if rows != 2 || columns["age"] != "int" || columns["score"] != "float" || columns["active"] != "bool" || columns["user"] != "string" {
+			t.Fatalf("%s: Expected 2 rows and their column types; Got %d %v", format, rows, columns)
+		}
+	}
+}
@@ -2,28 +2,78 @@ package util
 
 import (
 	"bufio"
+	"fmt"
 	"io"
+	"os"
 	"strconv"
 
 	"github.com/rs/zerolog/log"
 	"github.com/tidwall/gjson"
 )
 
+// SystemColumnTypes are the types of the system columns the API can add to rows.
+// They are sent as JSON strings or numbers, so are typed by name instead of
+// being inferred.
+var SystemColumnTypes = map[string]string{
+	"__ingested_at": "timestamp",
+	"__api_key_id":  "int",
+	"__source_ip":   "string",
+	"__batch_id":    "string",
+}
+
 func GetJSONTypes(file io.ReadSeeker) (map[string]string, error) {
-	rc := map[string]string{}
 	typeCounts := map[string]map[string]int{}
 
+	err := countJSONTypes(file, typeCounts)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	return resolveJSONTypes(typeCounts), nil
+}
+
+// GetJSONFilesTypes infers column types across several NDJSON files, as if they
+// were a single file
+func GetJSONFilesTypes(paths []string) (map[string]string, error) {
+	typeCounts := map[string]map[string]int{}
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return map[string]string{}, err
+		}
+
+		err = countJSONTypes(file, typeCounts)
+		file.Close()
+		if err != nil {
+			return map[string]string{}, err
+		}
+	}
+
+	return resolveJSONTypes(typeCounts), nil
+}
+
+func countJSONTypes(file io.ReadSeeker, typeCounts map[string]map[string]int) error {
 	_, err := file.Seek(0, io.SeekStart)
 	if err != nil {
-		return rc, err
+		return err
 	}
 
-	scanner := bufio.NewScanner(file)
+	_, err = countJSONReaderTypes(file, typeCounts)
+	return err
+}
+
+// countJSONReaderTypes counts the types of each column in NDJSON read from r, and
+// returns the number of lines
+func countJSONReaderTypes(r io.Reader, typeCounts map[string]map[string]int) (int64, error) {
+	var lines int64
+	scanner := bufio.NewScanner(r)
 	maxCapacity := 100_000_000
 	buf := make([]byte, 2_000)
 	scanner.Buffer(buf, maxCapacity)
 
 	for scanner.Scan() {
+		lines++
 		parsed := gjson.ParseBytes(scanner.Bytes())
 
 		parsed.ForEach(func(key, value gjson.Result) bool {
@@ -58,9 +108,11 @@ func GetJSONTypes(file io.ReadSeeker) (map[string]string, error) {
 
 	log.Trace().Interface("column_type_counts", typeCounts).Send()
 
-	if err := scanner.Err(); err != nil {
-		return rc, err
-	}
+	return lines, scanner.Err()
+}
+
+func resolveJSONTypes(typeCounts map[string]map[string]int) map[string]string {
+	rc := map[string]string{}
 
 	for colName, colTypeCounts := range typeCounts {
 		if colTypeCounts["string"] > 0 {
@@ -83,7 +135,31 @@ func GetJSONTypes(file io.ReadSeeker) (map[string]string, error) {
 
 	}
 
+	// Timestamps are JSON strings, so system columns are typed the same way as
+	// when they're added to uploads
+	for colName, systemType := range SystemColumnTypes {
+		if rc[colName] == "string" {
+			rc[colName] = systemType
+		}
+	}
+
 	log.Trace().Interface("column_types", rc).Send()
 
-	return rc, nil
+	return rc
+}
+
+// DecimalType is the column type for a decimal with the given precision and scale,
+// alongside the types returned by GetJSONTypes
+func DecimalType(precision int, scale int) string {
+	return fmt.Sprintf("decimal(%d,%d)", precision, scale)
+}
+
+// ParseDecimalType returns the precision and scale of a type created by DecimalType
+func ParseDecimalType(columnType string) (int, int, bool) {
+	var precision, scale int
+	_, err := fmt.Sscanf(columnType, "decimal(%d,%d)", &precision, &scale)
+	if err != nil || precision < 1 || scale < 0 || scale > precision {
+		return 0, 0, false
+	}
+	return precision, scale, true
 }
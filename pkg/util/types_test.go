@@ -0,0 +1,60 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetJSONFilesTypes(t *testing.T) {
+	dir := t.TempDir()
+	chunks := []string{
+		`{"a": 1, "b": 1, "c": null, "d": true}` + "\n",
+		`{"a": 2, "b": 1.5, "c": 3}` + "\n" + `{"a": "x", "e": {"f": 1}}` + "\n",
+	}
+
+	var paths []string
+	for i, chunk := range chunks {
+		path := filepath.Join(dir, fmt.Sprintf("file-%d", i))
+		if err := os.WriteFile(path, []byte(chunk), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	types, err := GetJSONFilesTypes(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"a": "string", "b": "float", "c": "int", "d": "bool", "e": "string"}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %v; Got %v", expected, types)
+	}
+}
+
+func TestGetJSONTypesSystemColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rows.ndjson")
+	rows := `{"__ingested_at": "2024-01-02T03:04:05.123Z", "__api_key_id": 7, "__source_ip": "10.0.0.1", "at": "2024-01-02T03:04:05Z"}` + "\n"
+	if err := os.WriteFile(path, []byte(rows), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	types, err := GetJSONTypes(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only system columns are typed by name
+	expected := map[string]string{"__api_key_id": "int", "__ingested_at": "timestamp", "__source_ip": "string", "at": "string"}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %v; Got %v", expected, types)
+	}
+}
@@ -2,77 +2,421 @@ package workers
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/destinations"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
 	"github.com/scratchdata/scratchdata/pkg/util"
 )
 
 var copyDir string = "copy"
 
-func (w *ScratchDataWorker) CopyData(sourceId int64, query string, destId uint, destTable string) error {
+// Number of chunks of a copy which are loaded at once, unless the destination
+// sets load_parallelism
+const defaultLoadParallelism = 4
+
+func (w *ScratchDataWorker) CopyData(messageId uint, message queue_models.CopyDataMessage) (jobStats, error) {
 	ctx := context.TODO()
 
+	sourceId := message.SourceID
+	destId := message.DestinationID
+	destTable := message.DestinationTable
+	query := message.Query
+
+	err := message.Validate()
+	if err != nil {
+		return jobStats{}, permanentError{err}
+	}
+
 	snowflake, err := util.NewSnowflakeGenerator()
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
-	localFolder := filepath.Join(w.Config.DataDirectory, copyDir, snowflake.Generate().String())
+	copyId := snowflake.Generate().String()
+	localFolder := filepath.Join(w.Config.DataDirectory, copyDir, copyId)
 	err = os.MkdirAll(localFolder, os.ModePerm)
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 	defer os.RemoveAll(localFolder)
 
-	source, err := w.destinationManager.Destination(ctx, sourceId)
+	dest, err := w.destinationManager.Destination(ctx, int64(destId))
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
-	dest, err := w.destinationManager.Destination(ctx, int64(destId))
+	// If an append failed part way through, only load the chunks which failed
+	// rather than copying everything again
+	mode := message.WriteMode()
+	if mode == queue_models.CopyAppend {
+		chunks, err := w.StorageServices.Database.GetCopyChunks(messageId)
+		if err != nil {
+			return jobStats{}, err
+		}
+
+		if countFailedChunks(chunks) > 0 {
+			return w.retryChunks(dest, messageId, message, localFolder, chunks)
+		}
+	}
+
+	var watermark string
+	incremental := message.IncrementalColumn != ""
+	if incremental {
+		watermark, err = w.getWatermark(watermarkKey(message))
+		if err != nil {
+			return jobStats{}, err
+		}
+
+		sourceCreds, err := w.StorageServices.Database.GetDestinationCredentials(ctx, sourceId)
+		if err != nil {
+			return jobStats{}, err
+		}
+
+		query = incrementalQuery(query, message.IncrementalColumn, watermark, sourceCreds.Type)
+	}
+
+	source, err := w.destinationManager.Destination(ctx, sourceId)
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
 	writer := util.NewChunkedWriter(w.Config.MaxBulkQuerySizeBytes, w.Config.BulkChunkSizeBytes, localFolder)
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
 	err = source.QueryNDJson(query, writer)
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
 	err = writer.Close()
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
 	files, err := os.ReadDir(localFolder)
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
-	err = dest.CreateEmptyTable(destTable)
+	// Replace and upsert load everything into a staging table first, so the
+	// destination table only changes once all of the data has been loaded
+	loadTable := destTable
+	if mode != queue_models.CopyAppend {
+		loadTable = fmt.Sprintf("%s__stage_%s", destTable, copyId)
+	}
+
+	err = dest.CreateEmptyTable(loadTable)
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
+	var paths []string
+	var chunks []models.CopyChunk
 	for _, f := range files {
 		path := filepath.Join(localFolder, f.Name())
-		err = dest.CreateColumns(destTable, path)
+		paths = append(paths, path)
+
+		stats, err := fileStats(path)
 		if err != nil {
-			log.Error().Err(err).Int64("source_id", sourceId).Uint("dest_id", destId).Str("table", destTable).Msg("Unable to create columns")
+			return jobStats{}, err
+		}
+
+		chunks = append(chunks, models.CopyChunk{
+			Name:  f.Name(),
+			Rows:  stats.rows,
+			Bytes: stats.bytes,
+		})
+	}
+
+	var pending []*models.CopyChunk
+	for i := range chunks {
+		pending = append(pending, &chunks[i])
+	}
+
+	err = w.loadChunks(dest, destId, loadTable, localFolder, pending)
+	if err != nil {
+		if mode != queue_models.CopyAppend {
+			if dropErr := dest.DropTable(loadTable); dropErr != nil {
+				log.Error().Err(dropErr).Uint("dest_id", destId).Str("table", loadTable).Msg("Unable to drop staging table")
+			}
+		}
+		return jobStats{}, err
+	}
+
+	failed := countFailedChunks(chunks)
+
+	if mode != queue_models.CopyAppend {
+		err = w.applyStaging(dest, message, loadTable, paths, failed)
+		if err != nil {
+			if dropErr := dest.DropTable(loadTable); dropErr != nil {
+				log.Error().Err(dropErr).Uint("dest_id", destId).Str("table", loadTable).Msg("Unable to drop staging table")
+			}
+			if chunkErr := w.StorageServices.Database.SetCopyChunks(messageId, chunks); chunkErr != nil {
+				log.Error().Err(chunkErr).Uint("message_id", messageId).Msg("Unable to record chunks")
+			}
+			return jobStats{}, err
+		}
+	} else if failed > 0 {
+		err = w.keepFailedChunks(messageId, localFolder, chunks)
+		if err != nil {
+			// The chunks which were loaded still need to be recorded, so that a
+			// retry doesn't load them again
+			if chunkErr := w.StorageServices.Database.SetCopyChunks(messageId, chunks); chunkErr != nil {
+				log.Error().Err(chunkErr).Uint("message_id", messageId).Msg("Unable to record chunks")
+			}
+			return jobStats{}, err
+		}
+	}
+
+	err = w.StorageServices.Database.SetCopyChunks(messageId, chunks)
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	// Rows in failed chunks are kept for retry, so the watermark can move past them
+	if incremental {
+		newWatermark, err := maxWatermark(paths, message.IncrementalColumn, watermark)
+		if err != nil {
+			return jobStats{}, err
+		}
+
+		if newWatermark != watermark {
+			err = w.StorageServices.Database.SetWatermark(watermarkKey(message), newWatermark)
+			if err != nil {
+				return jobStats{}, err
+			}
+		}
+	}
+
+	if failed > 0 {
+		return jobStats{}, fmt.Errorf("unable to load %d of %d chunks", failed, len(chunks))
+	}
+
+	return chunkStats(chunks), nil
+}
+
+// loadChunks loads the given chunks from localFolder into table, marking each
+// one as loaded or failed. Columns are created once for all of the chunks, then
+// the chunks are inserted concurrently, up to the destination's load_parallelism.
+func (w *ScratchDataWorker) loadChunks(dest destinations.Destination, destId uint, table string, localFolder string, chunks []*models.CopyChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	var paths []string
+	for _, chunk := range chunks {
+		paths = append(paths, filepath.Join(localFolder, chunk.Name))
+	}
+
+	jsonTypes, err := util.GetJSONFilesTypes(paths)
+	if err != nil {
+		return err
+	}
+
+	if len(jsonTypes) > 0 {
+		err = dest.CreateColumnsWithTypes(table, jsonTypes)
+		if err != nil {
+			return fmt.Errorf("unable to create columns: %w", err)
+		}
+	}
+
+	parallelism := defaultLoadParallelism
+	options, err := w.destinationOptions(destId)
+	if err != nil {
+		log.Error().Err(err).Uint("dest_id", destId).Msg("Unable to get destination options")
+	} else if options.LoadParallelism > 0 {
+		parallelism = options.LoadParallelism
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	for i, chunk := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(chunk *models.CopyChunk, path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := dest.InsertFromNDJsonFile(table, path, nil)
+			if err != nil {
+				log.Error().Err(err).Uint("dest_id", destId).Str("table", table).Str("chunk", chunk.Name).Msg("Unable to load chunk")
+				chunk.Status = models.ChunkFailed
+				chunk.Error = fmt.Sprintf("unable to insert data: %s", err)
+				return
+			}
+
+			chunk.Status = models.ChunkLoaded
+			chunk.Error = ""
+		}(chunk, paths[i])
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func countFailedChunks(chunks []models.CopyChunk) int {
+	failed := 0
+	for _, chunk := range chunks {
+		if chunk.Status == models.ChunkFailed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// chunkStats adds up the data in chunks which have been loaded
+func chunkStats(chunks []models.CopyChunk) jobStats {
+	var stats jobStats
+	for _, chunk := range chunks {
+		if chunk.Status == models.ChunkLoaded {
+			stats.rows += chunk.Rows
+			stats.bytes += chunk.Bytes
+		}
+	}
+	return stats
+}
+
+func chunkKey(messageId uint, name string) string {
+	return fmt.Sprintf("%s/%d/%s", copyDir, messageId, name)
+}
+
+// keepFailedChunks uploads chunks which failed to load to the blob store, so
+// that they can be loaded again when the message is retried
+func (w *ScratchDataWorker) keepFailedChunks(messageId uint, localFolder string, chunks []models.CopyChunk) error {
+	// Keep as many chunks as possible, so that fewer are lost if one can't be kept
+	var rc error
+	for i := range chunks {
+		if chunks[i].Status != models.ChunkFailed {
+			continue
+		}
+
+		key := chunkKey(messageId, chunks[i].Name)
+		file, err := os.Open(filepath.Join(localFolder, chunks[i].Name))
+		if err != nil {
+			rc = err
+			continue
+		}
+
+		err = w.StorageServices.BlobStore.Upload(key, file)
+		file.Close()
+		if err != nil {
+			rc = fmt.Errorf("unable to keep failed chunk %s: %w", chunks[i].Name, err)
+			continue
+		}
+
+		chunks[i].Key = key
+	}
+
+	return rc
+}
+
+// retryChunks loads the chunks which failed on a previous attempt of an append
+func (w *ScratchDataWorker) retryChunks(dest destinations.Destination, messageId uint, message queue_models.CopyDataMessage, localFolder string, chunks []models.CopyChunk) (jobStats, error) {
+	table := message.DestinationTable
+
+	err := dest.CreateEmptyTable(table)
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	var pending []*models.CopyChunk
+	for i := range chunks {
+		if chunks[i].Status != models.ChunkFailed {
+			continue
+		}
+
+		// A chunk which couldn't be kept can't be loaded again, and the rest of
+		// the copy can't be run again without duplicating the loaded chunks
+		if chunks[i].Key == "" {
+			chunks[i].Error = "chunk was not kept for retry"
 			continue
 		}
-		err = dest.InsertFromNDJsonFile(destTable, path)
+
+		path := filepath.Join(localFolder, chunks[i].Name)
+		err := w.downloadFile(path, chunks[i].Key)
 		if err != nil {
-			log.Error().Err(err).Int64("source_id", sourceId).Uint("dest_id", destId).Str("table", destTable).Msg("Unable to insert data")
+			log.Error().Err(err).Uint("message_id", messageId).Str("chunk", chunks[i].Name).Msg("Unable to download kept chunk")
+			chunks[i].Error = err.Error()
 			continue
 		}
+
+		pending = append(pending, &chunks[i])
+	}
+
+	err = w.loadChunks(dest, message.DestinationID, table, localFolder, pending)
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	var loadedKeys []string
+	for _, chunk := range pending {
+		if chunk.Status == models.ChunkLoaded {
+			loadedKeys = append(loadedKeys, chunk.Key)
+			chunk.Key = ""
+		}
+	}
+
+	err = w.StorageServices.Database.SetCopyChunks(messageId, chunks)
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	// Only remove kept chunks once they're recorded as loaded, so a chunk is never
+	// marked as failed without its data
+	for _, key := range loadedKeys {
+		if err := w.StorageServices.BlobStore.Delete(key); err != nil {
+			log.Error().Err(err).Str("key", key).Msg("Unable to delete kept chunk")
+		}
+	}
+
+	failed := countFailedChunks(chunks)
+	if failed > 0 {
+		return jobStats{}, fmt.Errorf("unable to load %d of %d chunks", failed, len(chunks))
+	}
+
+	return chunkStats(chunks), nil
+}
+
+// applyStaging moves the data loaded into staging into the destination table,
+// according to the copy's mode
+func (w *ScratchDataWorker) applyStaging(dest destinations.Destination, message queue_models.CopyDataMessage, staging string, paths []string, failed int) error {
+	// A partial replace or upsert would leave the table in a state which
+	// doesn't match the source
+	if failed > 0 {
+		return fmt.Errorf("unable to load %d of %d chunks", failed, len(paths))
+	}
+
+	switch message.WriteMode() {
+	case queue_models.CopyReplace:
+		return dest.ReplaceTable(message.DestinationTable, staging)
+	case queue_models.CopyUpsert:
+		err := dest.CreateEmptyTable(message.DestinationTable)
+		if err != nil {
+			return err
+		}
+
+		// Add any columns which are new in this copy
+		jsonTypes, err := util.GetJSONFilesTypes(paths)
+		if err != nil {
+			return err
+		}
+
+		if len(jsonTypes) > 0 {
+			err = dest.CreateColumnsWithTypes(message.DestinationTable, jsonTypes)
+			if err != nil {
+				return err
+			}
+		}
+
+		return dest.MergeTable(message.DestinationTable, staging, message.KeyColumn)
 	}
 
 	return nil
@@ -0,0 +1,212 @@
+package workers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/scratchdata/scratchdata/pkg/config"
+	"github.com/scratchdata/scratchdata/pkg/destinations"
+	"github.com/scratchdata/scratchdata/pkg/storage"
+	"github.com/scratchdata/scratchdata/pkg/storage/blobstore/memory"
+	blob_models "github.com/scratchdata/scratchdata/pkg/storage/blobstore/models"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/gorm"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+)
+
+// flakyDestination fails to insert until fail is set to false
+type flakyDestination struct {
+	destinations.Destination
+
+	mu       sync.Mutex
+	fail     bool
+	inserted []string
+	columns  []map[string]string
+}
+
+func (d *flakyDestination) CreateEmptyTable(table string) error { return nil }
+
+func (d *flakyDestination) CreateColumnsWithTypes(table string, jsonTypes map[string]string) error {
+	d.columns = append(d.columns, jsonTypes)
+	return nil
+}
+
+func (d *flakyDestination) InsertFromNDJsonFile(table string, path string, jsonTypes map[string]string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.fail {
+		return errors.New("connection reset")
+	}
+	d.inserted = append(d.inserted, filepath.Base(path))
+	return nil
+}
+
+func newTestWorker(t *testing.T) (*ScratchDataWorker, *gorm.Gorm, *memory.Storage) {
+	db, err := gorm.NewGorm(config.Database{
+		Type:     "sqlite",
+		Settings: map[string]any{"dsn": filepath.Join(t.TempDir(), "copy.db")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ := memory.NewStorage(nil)
+
+	services := &storage.Services{Database: db, BlobStore: blobs}
+	w := &ScratchDataWorker{
+		StorageServices:    services,
+		destinationManager: destinations.NewDestinationManager(services),
+	}
+
+	return w, db, blobs
+}
+
+func TestRetryChunks(t *testing.T) {
+	w, db, blobs := newTestWorker(t)
+
+	message := queue_models.CopyDataMessage{DestinationID: 1, DestinationTable: "t"}
+	msg, err := db.Enqueue(models.CopyData, message, 1, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := chunkKey(msg.ID, "file-1")
+	blobs.Upload(key, bytes.NewReader([]byte("{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n")))
+
+	err = db.SetCopyChunks(msg.ID, []models.CopyChunk{
+		{Name: "file-0", Status: models.ChunkLoaded, Rows: 2, Bytes: 16},
+		{Name: "file-1", Status: models.ChunkFailed, Rows: 3, Bytes: 24, Key: key, Error: "timeout"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := &flakyDestination{fail: true}
+	chunks, _ := db.GetCopyChunks(msg.ID)
+	if _, err := w.retryChunks(dest, msg.ID, message, t.TempDir(), chunks); err == nil {
+		t.Fatalf("Expected retry to fail while the destination is failing")
+	}
+
+	chunks, _ = db.GetCopyChunks(msg.ID)
+	if countFailedChunks(chunks) != 1 || chunks[1].Error == "timeout" {
+		t.Fatalf("Expected failed chunk to be kept with the new error; Got %+v", chunks)
+	}
+
+	dest.fail = false
+	stats, err := w.retryChunks(dest, msg.ID, message, t.TempDir(), chunks)
+	if err != nil {
+		t.Fatalf("Expected retry to succeed; Got %s", err)
+	}
+
+	// Only the failed chunk is loaded again, but the stats cover the whole copy
+	if len(dest.inserted) != 1 || dest.inserted[0] != "file-1" {
+		t.Fatalf("Expected only file-1 to be inserted; Got %v", dest.inserted)
+	}
+	if stats.rows != 5 || stats.bytes != 40 {
+		t.Fatalf("Expected 5 rows and 40 bytes; Got %+v", stats)
+	}
+
+	if err := blobs.Download(key, &os.File{}); !errors.Is(err, blob_models.ErrNotFound) {
+		t.Fatalf("Expected kept chunk to be deleted; Got %v", err)
+	}
+
+	chunks, _ = db.GetCopyChunks(msg.ID)
+	if countFailedChunks(chunks) != 0 {
+		t.Fatalf("Expected no failed chunks; Got %+v", chunks)
+	}
+}
+
+func TestLoadChunks(t *testing.T) {
+	w, _, _ := newTestWorker(t)
+	dir := t.TempDir()
+
+	var chunks []models.CopyChunk
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("file-%d", i)
+		data := fmt.Sprintf("{\"a\":%d,\"col_%d\":true}\n", i, i)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, models.CopyChunk{Name: name})
+	}
+
+	var pending []*models.CopyChunk
+	for i := range chunks {
+		pending = append(pending, &chunks[i])
+	}
+
+	dest := &flakyDestination{}
+	if err := w.loadChunks(dest, 1, "t", dir, pending); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dest.columns) != 1 || len(dest.columns[0]) != 11 {
+		t.Fatalf("Expected columns to be created once for all chunks; Got %v", dest.columns)
+	}
+	if len(dest.inserted) != len(chunks) || countFailedChunks(chunks) != 0 {
+		t.Fatalf("Expected all chunks to be loaded; Got %v", dest.inserted)
+	}
+	for _, chunk := range chunks {
+		if chunk.Status != models.ChunkLoaded {
+			t.Fatalf("Expected %s to be loaded; Got %s", chunk.Name, chunk.Status)
+		}
+	}
+}
+
+// failingBlobStore fails to upload keys in fail
+type failingBlobStore struct {
+	*memory.Storage
+	fail map[string]bool
+}
+
+func (b *failingBlobStore) Upload(path string, r io.ReadSeeker) error {
+	if b.fail[path] {
+		return errors.New("bucket unavailable")
+	}
+	return b.Storage.Upload(path, r)
+}
+
+func TestKeepFailedChunks(t *testing.T) {
+	w, _, blobs := newTestWorker(t)
+	dir := t.TempDir()
+
+	chunks := []models.CopyChunk{
+		{Name: "file-0", Status: models.ChunkFailed},
+		{Name: "file-1", Status: models.ChunkLoaded},
+		{Name: "file-2", Status: models.ChunkFailed},
+	}
+	for _, c := range chunks {
+		os.WriteFile(filepath.Join(dir, c.Name), []byte("{\"a\":1}\n"), 0644)
+	}
+
+	w.StorageServices.BlobStore = &failingBlobStore{Storage: blobs, fail: map[string]bool{chunkKey(1, "file-0"): true}}
+
+	if err := w.keepFailedChunks(1, dir, chunks); err == nil {
+		t.Fatalf("Expected an error when a chunk can't be kept")
+	}
+
+	// The chunks after the one which couldn't be kept are still kept
+	if chunks[0].Key != "" || chunks[1].Key != "" || chunks[2].Key != chunkKey(1, "file-2") {
+		t.Fatalf("Expected only file-2 to be kept; Got %+v", chunks)
+	}
+
+	// A retry loads the kept chunk, and reports the one which wasn't kept
+	w.StorageServices.BlobStore = blobs
+	dest := &flakyDestination{}
+	message := queue_models.CopyDataMessage{DestinationID: 1, DestinationTable: "t"}
+	if _, err := w.retryChunks(dest, 1, message, t.TempDir(), chunks); err == nil {
+		t.Fatalf("Expected retry to report the chunk which wasn't kept")
+	}
+	if len(dest.inserted) != 1 || dest.inserted[0] != "file-2" {
+		t.Fatalf("Expected only file-2 to be inserted; Got %v", dest.inserted)
+	}
+	if chunks[0].Status != models.ChunkFailed || chunks[0].Error != "chunk was not kept for retry" {
+		t.Fatalf("Expected file-0 to stay failed; Got %+v", chunks[0])
+	}
+}
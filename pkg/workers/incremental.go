@@ -0,0 +1,141 @@
+package workers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// watermarkKey identifies an incremental copy, so that each run of the same copy
+// continues from where the previous one left off
+func watermarkKey(message queue_models.CopyDataMessage) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%d\x00%s\x00%s",
+		message.SourceID,
+		message.Query,
+		message.DestinationID,
+		message.DestinationTable,
+		message.IncrementalColumn,
+	)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sqlLiteral renders a watermark so it can be compared against in a query on a
+// sourceType database. Only JSON numbers are left unquoted, so that strings such
+// as "00123" from a text column keep their leading zeros.
+func sqlLiteral(value gjson.Result, sourceType string) string {
+	s := value.String()
+	if value.Type == gjson.Number {
+		return s
+	}
+
+	switch sourceType {
+	case "bigquery":
+		// BigQuery only escapes quotes with a backslash
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		s = strings.ReplaceAll(s, "'", `\'`)
+	case "clickhouse", "redshift":
+		// Backslashes start escape sequences in string literals
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		s = strings.ReplaceAll(s, "'", "''")
+	default:
+		s = strings.ReplaceAll(s, "'", "''")
+	}
+	return "'" + s + "'"
+}
+
+// incrementalQuery limits a query to rows after the watermark. With no watermark
+// yet, the whole query is copied.
+func incrementalQuery(query string, column string, watermark string, sourceType string) string {
+	query = util.TrimQuery(query)
+	if watermark == "" {
+		return query
+	}
+
+	return fmt.Sprintf(
+		"SELECT * FROM (%s) AS scratch_incremental WHERE %s > %s",
+		query, column, sqlLiteral(gjson.Parse(watermark), sourceType),
+	)
+}
+
+// compareWatermarks orders two values of the incremental column. Integers are
+// compared exactly, since IDs such as __row_id don't fit in a float64, and some
+// databases return 64 bit integers as JSON strings.
+func compareWatermarks(a, b gjson.Result) int {
+	as, bs := a.String(), b.String()
+
+	ai, aErr := strconv.ParseInt(as, 10, 64)
+	bi, bErr := strconv.ParseInt(bs, 10, 64)
+	if aErr == nil && bErr == nil {
+		switch {
+		case ai < bi:
+			return -1
+		case ai > bi:
+			return 1
+		}
+		return 0
+	}
+
+	if a.Type == gjson.Number && b.Type == gjson.Number {
+		switch {
+		case a.Float() < b.Float():
+			return -1
+		case a.Float() > b.Float():
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(as, bs)
+}
+
+// maxWatermark returns the raw JSON of the highest value of column in the NDJSON files,
+// starting from current
+func maxWatermark(paths []string, column string, current string) (string, error) {
+	highest := gjson.Parse(current)
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 1024*1024), 100*1024*1024)
+		for scanner.Scan() {
+			value := gjson.GetBytes(scanner.Bytes(), column)
+			if !value.Exists() || value.Type == gjson.Null {
+				continue
+			}
+			if !highest.Exists() || compareWatermarks(value, highest) > 0 {
+				highest = gjson.Parse(value.Raw)
+			}
+		}
+
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return highest.Raw, nil
+}
+
+func (w *ScratchDataWorker) getWatermark(key string) (string, error) {
+	watermark, err := w.StorageServices.Database.GetWatermark(key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return watermark.Value, err
+}
@@ -0,0 +1,93 @@
+package workers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestIncrementalQuery(t *testing.T) {
+	q := incrementalQuery("select * from events;", "__row_id", "", "duckdb")
+	if q != "select * from events" {
+		t.Fatalf("Expected query to be unchanged without a watermark; Got %q", q)
+	}
+
+	q = incrementalQuery("select * from events", "__row_id", "1790000000000000001", "duckdb")
+	expected := "SELECT * FROM (select * from events) AS scratch_incremental WHERE __row_id > 1790000000000000001"
+	if q != expected {
+		t.Fatalf("Expected %q; Got %q", expected, q)
+	}
+
+	q = incrementalQuery("select * from events", "ts", `"2024-01-01 00:00:00'"`, "duckdb")
+	expected = "SELECT * FROM (select * from events) AS scratch_incremental WHERE ts > '2024-01-01 00:00:00'''"
+	if q != expected {
+		t.Fatalf("Expected %q; Got %q", expected, q)
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	tests := []struct {
+		value      string
+		sourceType string
+		expected   string
+	}{
+		{"123", "postgres", "123"},
+		{"1.5", "postgres", "1.5"},
+		// Strings from text columns stay strings
+		{`"00123"`, "postgres", "'00123'"},
+		{`"it's a \\ b"`, "postgres", `'it''s a \ b'`},
+		{`"it's a \\ b"`, "clickhouse", `'it''s a \\ b'`},
+		{`"it's a \\ b"`, "bigquery", `'it\'s a \\ b'`},
+	}
+
+	for _, test := range tests {
+		if literal := sqlLiteral(gjson.Parse(test.value), test.sourceType); literal != test.expected {
+			t.Fatalf("Rendering %s for %s: expected %s; Got %s", test.value, test.sourceType, test.expected, literal)
+		}
+	}
+}
+
+func TestCompareWatermarks(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		// Differ by less than float64 precision
+		{"1790000000000000001", "1790000000000000000", 1},
+		{`"1790000000000000001"`, "1790000000000000002", -1},
+		{"1.5", "1.25", 1},
+		{`"2024-01-02"`, `"2024-01-01"`, 1},
+		{"7", "7", 0},
+	}
+
+	for _, test := range tests {
+		if c := compareWatermarks(gjson.Parse(test.a), gjson.Parse(test.b)); c != test.expected {
+			t.Fatalf("Comparing %s to %s: expected %d; Got %d", test.a, test.b, test.expected, c)
+		}
+	}
+}
+
+func TestMaxWatermark(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "file-0"), filepath.Join(dir, "file-1")}
+	os.WriteFile(paths[0], []byte("{\"id\":1790000000000000003}\n{\"id\":null}\n"), 0644)
+	os.WriteFile(paths[1], []byte("{\"id\":1790000000000000009}\n{\"other\":1}"), 0644)
+
+	wm, err := maxWatermark(paths, "id", "1790000000000000005")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wm != "1790000000000000009" {
+		t.Fatalf("Expected 1790000000000000009; Got %s", wm)
+	}
+
+	wm, err = maxWatermark(paths, "id", "1790000000000000010")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wm != "1790000000000000010" {
+		t.Fatalf("Expected existing watermark to be kept; Got %s", wm)
+	}
+}
@@ -0,0 +1,112 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultVisibilityTimeout = 5 * time.Minute
+
+// How often finished messages older than the retention period are deleted
+const cleanupInterval = time.Hour
+
+func (w *ScratchDataWorker) visibilityTimeout() time.Duration {
+	if w.Config.VisibilityTimeoutSeconds > 0 {
+		return time.Duration(w.Config.VisibilityTimeoutSeconds) * time.Second
+	}
+	return defaultVisibilityTimeout
+}
+
+// heartbeatInterval is how often a worker reports that it is still busy
+// with a message. It is a fraction of the visibility timeout so that one
+// slow or missed heartbeat doesn't cause the message to be reclaimed.
+func (w *ScratchDataWorker) heartbeatInterval() time.Duration {
+	return w.visibilityTimeout() / 3
+}
+
+// Heartbeat periodically marks a message as still being processed until stop is called
+func (w *ScratchDataWorker) Heartbeat(id uint, claimedBy string) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(w.heartbeatInterval())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				err := w.StorageServices.Database.Heartbeat(id, claimedBy)
+				if err != nil {
+					log.Error().Err(err).Uint("message_id", id).Msg("Unable to send heartbeat")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// Reap returns messages claimed by workers that have crashed or been
+// shut down back to the queue
+func (w *ScratchDataWorker) Reap(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(w.heartbeatInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			staleBefore := time.Now().Add(-w.visibilityTimeout())
+			reclaimed, err := w.StorageServices.Database.ReclaimMessages(staleBefore, w.maxAttempts())
+			if err != nil {
+				log.Error().Err(err).Msg("Unable to reclaim stale messages")
+			} else if reclaimed > 0 {
+				log.Warn().Int64("reclaimed", reclaimed).Msg("Reclaimed messages from unresponsive workers")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Cleanup deletes finished messages once they are older than
+// message_retention_days, so that the messages table doesn't grow forever
+func (w *ScratchDataWorker) Cleanup(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if w.Config.MessageRetentionDays <= 0 {
+		return
+	}
+	retention := time.Duration(w.Config.MessageRetentionDays) * 24 * time.Hour
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := w.StorageServices.Database.DeleteFinishedMessages(time.Now().Add(-retention))
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to delete finished messages")
+		} else if deleted > 0 {
+			log.Info().Int64("deleted", deleted).Msg("Deleted finished messages")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
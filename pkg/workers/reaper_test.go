@@ -0,0 +1,42 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+)
+
+func TestReap(t *testing.T) {
+	w, db, _ := newTestWorker(t)
+	w.Config.VisibilityTimeoutSeconds = 1
+
+	db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+	db.Enqueue(models.InsertData, map[string]int{}, 1, "t")
+
+	crashed, _ := db.Dequeue(models.InsertData, "crashed", models.DequeueOptions{})
+	alive, _ := db.Dequeue(models.InsertData, "alive", models.DequeueOptions{})
+
+	// The live worker keeps sending heartbeats, while the crashed one doesn't
+	stop := w.Heartbeat(alive.ID, "alive")
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go w.Reap(ctx, &wg)
+
+	time.Sleep(2 * time.Second)
+	cancel()
+	wg.Wait()
+
+	m, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{})
+	if !ok || m.ID != crashed.ID {
+		t.Fatalf("Expected message %d from the crashed worker to be back in the queue", crashed.ID)
+	}
+	if _, ok := db.Dequeue(models.InsertData, "worker", models.DequeueOptions{}); ok {
+		t.Fatalf("Expected message %d with heartbeats not to be reclaimed", alive.ID)
+	}
+}
@@ -0,0 +1,86 @@
+package workers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+)
+
+const (
+	defaultMaxAttempts    = 5
+	defaultRetryBaseDelay = 10 * time.Second
+	defaultRetryMaxDelay  = time.Hour
+)
+
+// permanentError marks a failure that retrying the message will not fix,
+// such as a payload that can't be decoded
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// retryDelay returns how long to wait before the next attempt, doubling
+// after every failed attempt up to maxDelay
+func retryDelay(attempt int, baseDelay time.Duration, maxDelay time.Duration) time.Duration {
+	delay := baseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+func (w *ScratchDataWorker) maxAttempts() int {
+	if w.Config.MaxAttempts > 0 {
+		return w.Config.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+func (w *ScratchDataWorker) retryDelay(attempt int) time.Duration {
+	baseDelay := defaultRetryBaseDelay
+	if w.Config.RetryBaseDelaySeconds > 0 {
+		baseDelay = time.Duration(w.Config.RetryBaseDelaySeconds) * time.Second
+	}
+
+	maxDelay := defaultRetryMaxDelay
+	if w.Config.RetryMaxDelaySeconds > 0 {
+		maxDelay = time.Duration(w.Config.RetryMaxDelaySeconds) * time.Second
+	}
+
+	return retryDelay(attempt, baseDelay, maxDelay)
+}
+
+// handleFailure decides whether a message that failed to process is retried
+// later, dead lettered because it has run out of attempts, or failed outright
+func (w *ScratchDataWorker) handleFailure(item *models.Message, err error) {
+	var updateErr error
+	logger := log.With().Uint("message_id", item.ID).Int("attempts", item.Attempts).Logger()
+
+	if errors.As(err, &permanentError{}) {
+		logger.Error().Err(err).Msg("Message cannot be processed, not retrying")
+		updateErr = w.StorageServices.Database.FailMessage(item.ID, err.Error())
+	} else if item.Attempts >= w.maxAttempts() {
+		logger.Error().Err(err).Msg("Message is out of attempts, moving to dead letter")
+		updateErr = w.StorageServices.Database.DeadLetterMessage(item.ID, err.Error())
+	} else {
+		delay := w.retryDelay(item.Attempts)
+		logger.Warn().Err(err).Dur("retry_in", delay).Msg("Message failed, retrying")
+		updateErr = w.StorageServices.Database.RetryMessage(item.ID, err.Error(), time.Now().Add(delay))
+	}
+
+	if updateErr != nil {
+		logger.Error().Err(updateErr).Msg("Unable to update failed message")
+	}
+}
@@ -0,0 +1,30 @@
+package workers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	base := 10 * time.Second
+	max := time.Minute
+
+	expected := []time.Duration{
+		10 * time.Second,
+		20 * time.Second,
+		40 * time.Second,
+		time.Minute,
+		time.Minute,
+	}
+
+	for i, exp := range expected {
+		attempt := i + 1
+		if d := retryDelay(attempt, base, max); d != exp {
+			t.Fatalf("Attempt %d: expected %s; Got %s", attempt, exp, d)
+		}
+	}
+
+	if d := retryDelay(1000, base, max); d != max {
+		t.Fatalf("Expected delay to be capped at %s; Got %s", max, d)
+	}
+}
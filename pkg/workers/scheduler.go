@@ -0,0 +1,74 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
+)
+
+// How often to look for schedules which are due
+const scheduleInterval = 10 * time.Second
+
+// Schedule enqueues copies for schedules as they come due
+func (w *ScratchDataWorker) Schedule(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.runDueSchedules()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *ScratchDataWorker) runDueSchedules() {
+	now := time.Now()
+	schedules, err := w.StorageServices.Database.DueSchedules(now)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get due schedules")
+		return
+	}
+
+	for _, schedule := range schedules {
+		// Runs missed while no scheduler was running are skipped rather than
+		// enqueued one after another
+		nextRunAt, err := util.NextCronTime(schedule.Cron, now)
+		if err != nil {
+			log.Error().Err(err).Uint("schedule_id", schedule.ID).Str("cron", schedule.Cron).Msg("Invalid cron expression")
+			continue
+		}
+
+		message := queue_models.CopyDataMessage{
+			SourceID:          int64(schedule.SourceID),
+			Query:             schedule.Query,
+			DestinationID:     schedule.DestinationID,
+			DestinationTable:  schedule.DestinationTable,
+			IncrementalColumn: schedule.IncrementalColumn,
+			Mode:              queue_models.CopyMode(schedule.Mode),
+			KeyColumn:         schedule.KeyColumn,
+		}
+
+		run, ok, err := w.StorageServices.Database.RunSchedule(schedule, nextRunAt, message)
+		if err != nil {
+			log.Error().Err(err).Uint("schedule_id", schedule.ID).Msg("Unable to run schedule")
+			continue
+		}
+
+		if ok {
+			log.Debug().
+				Uint("schedule_id", schedule.ID).
+				Uint("message_id", run.MessageID).
+				Time("next_run_at", nextRunAt).
+				Msg("Enqueued scheduled copy")
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/scratchdata/scratchdata/pkg/destinations"
+	"github.com/scratchdata/scratchdata/pkg/storage/database/models"
+)
+
+// How long destination options are cached before being read from the database again
+const optionsTTL = 30 * time.Second
+
+type cachedOptions struct {
+	options destinations.Options
+	expires time.Time
+}
+
+// optionsCache saves reading a destination's settings, such as load_parallelism,
+// from the database for every copy
+type optionsCache struct {
+	mu      sync.Mutex
+	entries map[uint]cachedOptions
+}
+
+func (w *ScratchDataWorker) destinationOptions(destinationID uint) (destinations.Options, error) {
+	w.options.mu.Lock()
+	defer w.options.mu.Unlock()
+
+	if w.options.entries == nil {
+		w.options.entries = map[uint]cachedOptions{}
+	}
+
+	entry, ok := w.options.entries[destinationID]
+	if ok && time.Now().Before(entry.expires) {
+		return entry.options, nil
+	}
+
+	options, err := w.destinationManager.Options(context.TODO(), int64(destinationID))
+	if err != nil {
+		return options, err
+	}
+
+	w.options.entries[destinationID] = cachedOptions{options: options, expires: time.Now().Add(optionsTTL)}
+	return options, nil
+}
+
+// dequeueOptions picks up where the last message left off, so that each
+// destination gets a turn. Destinations which already have their maximum number
+// of messages in flight are skipped by Dequeue.
+func (w *ScratchDataWorker) dequeueOptions(lastDestinationID uint) models.DequeueOptions {
+	return models.DequeueOptions{
+		AfterDestinationID: lastDestinationID,
+		DefaultMaxInFlight: w.Config.DefaultMaxInFlight,
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/scratchdata/scratchdata/pkg/config"
 	"github.com/scratchdata/scratchdata/pkg/storage"
+	"github.com/scratchdata/scratchdata/pkg/util"
 
 	"github.com/rs/zerolog/log"
 	"github.com/scratchdata/scratchdata/pkg/destinations"
@@ -22,22 +24,34 @@ type ScratchDataWorker struct {
 	Config             config.Workers
 	StorageServices    *storage.Services
 	destinationManager *destinations.DestinationManager
+
+	// Unique to this process so that heartbeats from a restarted
+	// worker on the same host aren't confused with the old one
+	workerID string
+
+	options optionsCache
 }
 
 func (w *ScratchDataWorker) Produce(ctx context.Context, ch chan<- *models.Message, wg *sync.WaitGroup, messageType models.MessageType) {
 	defer wg.Done()
 
-	hostname, _ := os.Hostname()
-	workerLabel := fmt.Sprintf("%s-%s", hostname, messageType)
+	workerLabel := fmt.Sprintf("%s-%s", w.workerID, messageType)
+	var lastDestinationID uint
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			item, ok := w.StorageServices.Database.Dequeue(messageType, workerLabel)
+			opts := w.dequeueOptions(lastDestinationID)
+			item, ok := w.StorageServices.Database.Dequeue(messageType, workerLabel, opts)
 			if ok {
+				lastDestinationID = item.DestinationID
+
+				// Keep the claim alive while we wait for a free consumer
+				stopHeartbeat := w.Heartbeat(item.ID, item.ClaimedBy)
 				ch <- item
+				stopHeartbeat()
 			} else {
 				time.Sleep(1 * time.Second)
 			}
@@ -50,72 +64,101 @@ func (w *ScratchDataWorker) Consume(ctx context.Context, ch <-chan *models.Messa
 	defer wg.Done()
 
 	for item := range ch {
-		var err error
-
-		switch item.MessageType {
-		case models.InsertData:
-			message, processErr := w.messageToStruct([]byte(item.Message))
-			if processErr != nil {
-				log.Error().Err(processErr).Int("thread", threadId).Str("message", item.Message).Msg("Unable to decode message")
-				continue
-			}
-			err = w.processInsertMessage(threadId, message)
-		case models.CopyData:
-			message := queue_models.CopyDataMessage{}
-			jsonErr := json.Unmarshal([]byte(item.Message), &message)
-			if jsonErr != nil {
-				log.Error().Err(jsonErr).Int("thread", threadId).Str("message", item.Message).Msg("Unable to decode message")
-				continue
-			}
-			err = w.CopyData(message.SourceID, message.Query, message.DestinationID, message.DestinationTable)
-		default:
-			log.Error().Int("thread", threadId).Interface("message", item).Msg("Unrecognized message type")
-			continue
+		startErr := w.StorageServices.Database.StartMessage(item.ID)
+		if startErr != nil {
+			log.Error().Err(startErr).Uint("message_id", item.ID).Msg("Unable to mark message as running")
 		}
 
+		stopHeartbeat := w.Heartbeat(item.ID, item.ClaimedBy)
+		stats, err := w.processMessage(threadId, item)
+		stopHeartbeat()
+
 		if err == nil {
-			deleteErr := w.StorageServices.Database.Delete(item.ID)
-			if deleteErr != nil {
-				log.Error().Err(deleteErr).Uint("message_id", item.ID).Msg("Unable to delete message from queue")
+			completeErr := w.StorageServices.Database.CompleteMessage(item.ID, stats.rows, stats.bytes)
+			if completeErr != nil {
+				log.Error().Err(completeErr).Uint("message_id", item.ID).Msg("Unable to mark message as succeeded")
 			}
 		} else {
 			log.Error().Err(err).Int("thread", threadId).Interface("message", item).Msg("Unable to process message")
+			w.handleFailure(item, err)
+		}
+	}
+}
+
+// jobStats is how much data a message moved, which is recorded on the job
+type jobStats struct {
+	rows  int64
+	bytes int64
+}
+
+func (w *ScratchDataWorker) processMessage(threadId int, item *models.Message) (jobStats, error) {
+	switch item.MessageType {
+	case models.InsertData:
+		message, err := w.messageToStruct([]byte(item.Message))
+		if err != nil {
+			return jobStats{}, permanentError{fmt.Errorf("unable to decode message: %w", err)}
 		}
+		return w.processInsertMessage(threadId, message)
+	case models.CopyData:
+		message := queue_models.CopyDataMessage{}
+		err := json.Unmarshal([]byte(item.Message), &message)
+		if err != nil {
+			return jobStats{}, permanentError{fmt.Errorf("unable to decode message: %w", err)}
+		}
+		return w.CopyData(item.ID, message)
 	}
+
+	return jobStats{}, permanentError{fmt.Errorf("unrecognized message type: %s", item.MessageType)}
 }
 
-func (w *ScratchDataWorker) processInsertMessage(threadId int, message queue_models.FileUploadMessage) error {
+func (w *ScratchDataWorker) processInsertMessage(threadId int, message queue_models.FileUploadMessage) (jobStats, error) {
 	destination, err := w.destinationManager.Destination(context.TODO(), message.DatabaseID)
 	if err != nil {
-		return err
+		return jobStats{}, err
+	}
+
+	if message.Format != "" && message.Format != util.StagingNDJSON {
+		inserter, ok := destination.(destinations.StagedFileInserter)
+		if ok && inserter.InsertsStagingFormat(message.Format) {
+			return w.insertStagedFile(threadId, destination, inserter, message)
+		}
 	}
 
 	fileIdent := filepath.Base(message.Key)
 	fileName := fmt.Sprintf("%d_%s_%s.ndjson", message.DatabaseID, message.Table, fileIdent)
 	filePath := filepath.Join(w.Config.DataDirectory, fileName)
 
-	err = w.downloadFile(filePath, message.Key)
+	if message.Format == "" || message.Format == util.StagingNDJSON {
+		err = w.downloadFile(filePath, message.Key)
+	} else {
+		err = w.downloadStagedFile(filePath, &message)
+	}
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
-	err = destination.CreateEmptyTable(message.Table)
+	stats, err := fileStats(filePath)
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
+	err = destination.CreateEmptyTable(message.Table)
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
-	err = destination.CreateColumns(message.Table, filePath)
+	if len(message.Columns) > 0 {
+		err = destination.CreateColumnsWithTypes(message.Table, message.Columns)
+	} else {
+		err = destination.CreateColumns(message.Table, filePath)
+	}
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
-	err = destination.InsertFromNDJsonFile(message.Table, filePath)
+	err = destination.InsertFromNDJsonFile(message.Table, filePath, message.Columns)
 	if err != nil {
-		return err
+		return jobStats{}, err
 	}
 
 	err = os.Remove(filePath)
@@ -123,7 +166,76 @@ func (w *ScratchDataWorker) processInsertMessage(threadId int, message queue_mod
 		log.Error().Err(err).Int("thread", threadId).Str("filename", filePath).Msg("Unable to remove temp file")
 	}
 
-	return nil
+	return stats, nil
+}
+
+// insertStagedFile loads a staging file, such as a parquet file, into a
+// destination which can read its format without it being converted to NDJSON
+func (w *ScratchDataWorker) insertStagedFile(threadId int, destination destinations.Destination, inserter destinations.StagedFileInserter, message queue_models.FileUploadMessage) (jobStats, error) {
+	fileName := fmt.Sprintf("%d_%s_%s", message.DatabaseID, message.Table, filepath.Base(message.Key))
+	filePath := filepath.Join(w.Config.DataDirectory, fileName)
+
+	err := w.downloadFile(filePath, message.Key)
+	defer func() {
+		err := os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Int("thread", threadId).Str("filename", filePath).Msg("Unable to remove temp file")
+		}
+	}()
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	columns, rows, err := util.InspectStaging(message.Format, filePath)
+	if err != nil {
+		return jobStats{}, err
+	}
+	if len(message.Columns) > 0 {
+		columns = message.Columns
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	err = destination.CreateEmptyTable(message.Table)
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	err = destination.CreateColumnsWithTypes(message.Table, columns)
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	err = inserter.InsertFromStagedFile(message.Table, filePath, message.Format, columns)
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	return jobStats{rows: rows, bytes: info.Size()}, nil
+}
+
+// fileStats counts the rows and bytes in a local NDJSON file
+func fileStats(path string) (jobStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return jobStats{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	rows, err := util.CountLines(file)
+	if err != nil {
+		return jobStats{}, err
+	}
+
+	return jobStats{rows: rows, bytes: info.Size()}, nil
 }
 
 func (w *ScratchDataWorker) messageToStruct(item []byte) (queue_models.FileUploadMessage, error) {
@@ -146,6 +258,35 @@ func (w *ScratchDataWorker) downloadFile(path string, key string) error {
 	return file.Close()
 }
 
+// downloadStagedFile downloads a file in a staging format such as gzip or parquet,
+// and converts it to NDJSON. Column types from a parquet schema are set on the
+// message, unless it already has them.
+func (w *ScratchDataWorker) downloadStagedFile(path string, message *queue_models.FileUploadMessage) error {
+	stagedPath := path + "." + util.StagingExtension(message.Format)
+	err := w.downloadFile(stagedPath, message.Key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(stagedPath)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	columns, err := util.DecodeStaging(message.Format, stagedPath, file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if len(columns) > 0 && len(message.Columns) == 0 {
+		message.Columns = columns
+	}
+
+	return file.Close()
+}
+
 func RunWorkers(ctx context.Context, config config.Workers, storageServices *storage.Services, destinationManager *destinations.DestinationManager) {
 	err := os.MkdirAll(config.DataDirectory, os.ModePerm)
 	if err != nil {
@@ -153,10 +294,13 @@ func RunWorkers(ctx context.Context, config config.Workers, storageServices *sto
 		return
 	}
 
+	hostname, _ := os.Hostname()
+
 	workers := &ScratchDataWorker{
 		Config:             config,
 		StorageServices:    storageServices,
 		destinationManager: destinationManager,
+		workerID:           fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8]),
 	}
 
 	values := make(chan *models.Message)
@@ -168,6 +312,17 @@ func RunWorkers(ctx context.Context, config config.Workers, storageServices *sto
 	go workers.Produce(ctx, values, &producerWg, models.InsertData)
 	go workers.Produce(ctx, values, &producerWg, models.CopyData)
 
+	log.Debug().Msg("Starting Reaper")
+	var reaperWg sync.WaitGroup
+	reaperWg.Add(2)
+	go workers.Reap(ctx, &reaperWg)
+	go workers.Cleanup(ctx, &reaperWg)
+
+	log.Debug().Msg("Starting Scheduler")
+	var schedulerWg sync.WaitGroup
+	schedulerWg.Add(1)
+	go workers.Schedule(ctx, &schedulerWg)
+
 	log.Debug().Msg("Starting Consumers")
 	var consumerWg sync.WaitGroup
 	for i := 0; i < config.Count; i++ {
@@ -182,4 +337,7 @@ func RunWorkers(ctx context.Context, config config.Workers, storageServices *sto
 
 	log.Debug().Msg("Closing Consumers...")
 	consumerWg.Wait()
+
+	reaperWg.Wait()
+	schedulerWg.Wait()
 }
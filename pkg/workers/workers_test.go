@@ -0,0 +1,109 @@
+package workers
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	queue_models "github.com/scratchdata/scratchdata/pkg/storage/queue/models"
+	"github.com/scratchdata/scratchdata/pkg/util"
+)
+
+func TestDownloadStagedFile(t *testing.T) {
+	w, _, blobs := newTestWorker(t)
+
+	rows := "{\"__row_id\":1,\"user\":\"alice\"}\n{\"__row_id\":2,\"user\":\"bob\"}\n"
+	for _, format := range []string{util.StagingZstd, util.StagingParquet} {
+		var staged bytes.Buffer
+		if err := util.EncodeStaging(format, strings.NewReader(rows), &staged); err != nil {
+			t.Fatal(err)
+		}
+
+		key := "1/events/1." + util.StagingExtension(format)
+		if err := blobs.Upload(key, bytes.NewReader(staged.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+
+		message := queue_models.FileUploadMessage{DatabaseID: 1, Table: "events", Key: key, Format: format}
+		path := filepath.Join(t.TempDir(), "events.ndjson")
+		if err := w.downloadStagedFile(path, &message); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+
+		data, _ := os.ReadFile(path)
+		if string(data) != rows {
+			t.Fatalf("%s: Expected NDJSON rows; Got %q", format, data)
+		}
+
+		if format == util.StagingParquet && message.Columns["user"] != "string" {
+			t.Fatalf("Expected column types from the parquet schema; Got %v", message.Columns)
+		}
+	}
+}
+
+// stagedDestination loads parquet files without them being converted
+type stagedDestination struct {
+	flakyDestination
+	format  string
+	loaded  string
+	columns map[string]string
+}
+
+func (d *stagedDestination) InsertsStagingFormat(format string) bool {
+	return format == util.StagingParquet
+}
+
+func (d *stagedDestination) CreateColumnsWithTypes(table string, jsonTypes map[string]string) error {
+	d.columns = jsonTypes
+	return nil
+}
+
+func (d *stagedDestination) InsertFromStagedFile(table string, path string, format string, jsonTypes map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	d.format = format
+	d.loaded = string(data)
+	return nil
+}
+
+func TestInsertStagedFile(t *testing.T) {
+	w, _, blobs := newTestWorker(t)
+	w.Config.DataDirectory = t.TempDir()
+
+	rows := "{\"__row_id\":1,\"user\":\"alice\"}\n{\"__row_id\":2,\"user\":\"bob\"}\n"
+	var staged bytes.Buffer
+	if err := util.EncodeStaging(util.StagingParquet, strings.NewReader(rows), &staged); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "1/events/1.parquet"
+	if err := blobs.Upload(key, bytes.NewReader(staged.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := &stagedDestination{}
+	message := queue_models.FileUploadMessage{DatabaseID: 1, Table: "events", Key: key, Format: util.StagingParquet}
+	stats, err := w.insertStagedFile(0, dest, dest, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dest.format != util.StagingParquet || dest.loaded != staged.String() {
+		t.Fatalf("Expected the parquet file to be loaded as it is; Got %s", dest.format)
+	}
+	if dest.columns["user"] != "string" || dest.columns["__row_id"] != "int" {
+		t.Fatalf("Expected columns from the parquet schema; Got %v", dest.columns)
+	}
+	if stats.rows != 2 || stats.bytes != int64(staged.Len()) {
+		t.Fatalf("Expected 2 rows and the file size; Got %+v", stats)
+	}
+
+	files, _ := os.ReadDir(w.Config.DataDirectory)
+	if len(files) != 0 {
+		t.Fatalf("Expected the staged file to be removed; Got %d files", len(files))
+	}
+}